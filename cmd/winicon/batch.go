@@ -0,0 +1,135 @@
+// batch 子命令：批量处理目录中的ico文件
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// runBatch 对目录树中的每个ico文件执行指定的操作
+// Apply an operation to every icon file of a directory tree
+func runBatch(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	op := fs.String("op", "info", "operation: info, extract, validate, optimize or convert")
+	workers := fs.Int("workers", 0, "number of parallel workers, the CPU count by default")
+	out := fs.String("o", "", "output `directory` for extract, optimize and convert; optimize and convert rewrite in place when empty")
+	format := fs.String("format", "png", "extract format: original, png or bmp")
+	to := fs.String("to", "png", "convert entries to png or dib")
+	report := fs.String("report", "", "write the JSON report to `file`")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	root := fs.Arg(0)
+	fn, err := batchOperation(root, *op, *out, *format, *to)
+	if err != nil {
+		return err
+	}
+	r, err := ico.Batch(root, &ico.BatchOptions{Workers: *workers}, fn)
+	if err != nil {
+		return err
+	}
+	if *report != "" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*report, b, 0666); err != nil {
+			return err
+		}
+	}
+	for _, v := range r.WalkErrors {
+		fmt.Fprintf(stdout, "FAIL %s: %s\n", v.Path, v.Error)
+	}
+	for _, v := range r.Results {
+		if v.Error != "" {
+			fmt.Fprintf(stdout, "FAIL %s: %s\n", v.Path, v.Error)
+		}
+	}
+	fmt.Fprintf(stdout, "%s: %d files, %d failed\n", root, r.Files, r.Failed)
+	return nil
+}
+
+// batchOperation 根据操作名称创建 ico.BatchFunc
+// Build the ico.BatchFunc of the named operation
+func batchOperation(root, op, out, format, to string) (ico.BatchFunc, error) {
+	// target 输出文件的路径，保持相对于root的目录结构
+	// Path of the output file, mirroring the tree below root
+	target := func(p string) (string, error) {
+		if out == "" {
+			return p, nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return "", err
+		}
+		t := filepath.Join(out, rel)
+		return t, os.MkdirAll(filepath.Dir(t), 0777)
+	}
+	switch op {
+	case "info":
+		return func(p string, wi *ico.WinIcon) (interface{}, error) {
+			return wi.Entries(), nil
+		}, nil
+	case "validate":
+		return func(p string, wi *ico.WinIcon) (interface{}, error) {
+			return nil, wi.Validate()
+		}, nil
+	case "extract":
+		f, ok := extractFormats[format]
+		if !ok || out == "" {
+			return nil, errUsage
+		}
+		return func(p string, wi *ico.WinIcon) (interface{}, error) {
+			t, err := target(p)
+			if err != nil {
+				return nil, err
+			}
+			opt := &ico.ExtractOptions{Format: f, NameTemplate: "{name}-{w}x{h}-{bpp}{dup}.{ext}"}
+			if err := wi.ExtractIconToFileWithOptions("", filepath.Dir(t), opt); err != nil {
+				return nil, err
+			}
+			return len(wi.Entries()), nil
+		}, nil
+	case "optimize":
+		return func(p string, wi *ico.WinIcon) (interface{}, error) {
+			saved, err := wi.Optimize()
+			if err != nil {
+				return nil, err
+			}
+			t, err := target(p)
+			if err != nil {
+				return nil, err
+			}
+			if saved == 0 && t == p {
+				return 0, nil
+			}
+			return saved, writeIcon(t, wi)
+		}, nil
+	case "convert":
+		if to != ico.FormatPNG && to != ico.FormatDIB {
+			return nil, errUsage
+		}
+		return func(p string, wi *ico.WinIcon) (interface{}, error) {
+			if err := wi.ConvertEntries(to); err != nil {
+				return nil, err
+			}
+			t, err := target(p)
+			if err != nil {
+				return nil, err
+			}
+			return len(wi.Entries()), writeIcon(t, wi)
+		}, nil
+	}
+	return nil, errUsage
+}
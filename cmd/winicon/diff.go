@@ -0,0 +1,110 @@
+// winicon diff 子命令，比较两个ico文件
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// runDiff 比较两个ico文件并打印每个尺寸的差异
+// -o 指定目录时，为像素不同的条目写入高亮差异图
+// Compare two icon files and print the difference of every size.
+// With -o the highlighted images of the changed entries are written
+// to that directory.
+func runDiff(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	out := fs.String("o", "", "write highlighted diff images to `dir`")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	icons := make([]*ico.WinIcon, 2)
+	for i, name := range fs.Args() {
+		wi, err := loadIcon(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		icons[i] = wi
+	}
+	r := ico.Diff(icons[0], icons[1], &ico.DiffOptions{Image: *out != ""})
+	if *out != "" {
+		if err := writeDiffImages(*out, r); err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tCHANGE\tBPP\tFORMAT\tPIXELS\tMAXDELTA\tPSNR")
+	for _, e := range r.Entries {
+		bpp := fmt.Sprintf("%d", e.BitsB)
+		format := e.FormatB
+		switch e.Change {
+		case ico.DiffRemoved:
+			bpp, format = fmt.Sprintf("%d", e.BitsA), e.FormatA
+		case ico.DiffChanged:
+			if e.BitsA != e.BitsB {
+				bpp = fmt.Sprintf("%d->%d", e.BitsA, e.BitsB)
+			}
+			if e.FormatA != e.FormatB {
+				format = e.FormatA + "->" + e.FormatB
+			}
+		}
+		psnr := "-"
+		switch {
+		case e.Identical:
+			psnr = "inf"
+		case e.PSNR > 0:
+			psnr = fmt.Sprintf("%.2f", e.PSNR)
+		}
+		fmt.Fprintf(tw, "%dx%d\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			e.Width, e.Height, e.Change, bpp, format, e.ChangedPixels, e.MaxDelta, psnr)
+		if e.Error != "" {
+			fmt.Fprintf(tw, "\t%s\n", e.Error)
+		}
+	}
+	return tw.Flush()
+}
+
+// writeDiffImages 将高亮差异图写入目录 dir
+// Write the highlighted diff images to dir
+func writeDiffImages(dir string, r *ico.DiffReport) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for _, e := range r.Entries {
+		if e.Image == nil {
+			continue
+		}
+		// 同一尺寸及位深度可能有多个条目，新图标中的索引区分它们
+		// entries may share size and depth, the new index tells them apart
+		name := fmt.Sprintf("diff%d-%dx%d@%dbit.png", e.IndexB, e.Width, e.Height, e.BitsB)
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, e.Image); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
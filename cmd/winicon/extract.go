@@ -0,0 +1,53 @@
+// extract 子命令：提取图标条目到文件
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// extractFormats 命令行中的提取格式名称
+// Extract format names accepted on the command line
+var extractFormats = map[string]ico.ExtractFormat{
+	"original": ico.ExtractOriginal,
+	"png":      ico.ExtractPNG,
+	"bmp":      ico.ExtractBMP,
+}
+
+// runExtract 提取ico文件中的所有图标条目
+// Extract every entry of an icon file
+func runExtract(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	format := fs.String("format", "original", "output format: original, png or bmp")
+	prefix := fs.String("prefix", "", "file name prefix")
+	v5 := fs.Bool("v5", false, "write 32-bit bmp files with a V5 header")
+	out := fs.String("o", ".", "output `directory`")
+	name := fs.String("name", "", "file name `template`, e.g. {name}-{w}x{h}-{bpp}{dup}.{ext}")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	f, ok := extractFormats[*format]
+	if !ok || fs.NArg() != 1 {
+		return errUsage
+	}
+	wi, err := loadIcon(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	opt := &ico.ExtractOptions{
+		Format:       f,
+		BMP:          &ico.BMPOptions{V5: *v5},
+		NameTemplate: *name,
+		Overwrite:    *force,
+	}
+	if err := wi.ExtractIconToFileWithOptions(*prefix, *out, opt); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %d entries extracted to %s\n", fs.Arg(0), len(wi.Entries()), *out)
+	return nil
+}
@@ -0,0 +1,59 @@
+// info 子命令：打印图标条目的元数据
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// runInfo 打印ico文件中每个图标条目的元数据
+// Print the metadata of every entry of an icon file
+func runInfo(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the entries as JSON")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	// 只需要目录，不载入图像数据
+	// Only the directory is needed, the payloads stay on disk
+	wi, err := ico.OpenIconFile(fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	defer wi.Close()
+	es := wi.Entries()
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(es)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSIZE\tBPP\tPALETTE\tFORMAT\tOFFSET\tBYTES\tMASK")
+	for _, e := range es {
+		fmt.Fprintf(tw, "%d\t%dx%d\t%d\t%d\t%s\t%d\t%d\t%v\n",
+			e.Index, e.Width, e.Height, e.BitsPerPixel, e.PaletteSize,
+			e.Format, e.Offset, e.Size, e.HasMask)
+	}
+	return tw.Flush()
+}
+
+// loadIcon 打开并载入ico文件
+// Open and load an icon file
+func loadIcon(path string) (*ico.WinIcon, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ico.LoadIconFile(f)
+}
@@ -0,0 +1,71 @@
+// winicon 命令行工具
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// command 子命令
+// A subcommand of winicon
+type command struct {
+	usage string                                      // 用法说明
+	run   func(args []string, stdout io.Writer) error // 执行函数
+}
+
+// commands 所有子命令
+// All subcommands, keyed by name
+var commands = map[string]command{
+	"batch":   {"batch [-op info|extract|validate|optimize|convert] [-workers n] [-o dir] [-format f] [-to png|dib] [-report file.json] dir", runBatch},
+	"diff":    {"diff [-json] [-o dir] old.ico new.ico", runDiff},
+	"extract": {"extract [-format original|png|bmp] [-v5] [-prefix p] [-name tmpl] [-force] [-o dir] file.ico", runExtract},
+	"info":    {"info [-json] file.ico", runInfo},
+	"merge":   {"merge [-policy first|last|png|depth] -o out.ico a.ico b.ico...", runMerge},
+	"preview": {"preview [-bg checker,light,dark] [-nolabels] [-o out.png] file.ico", runPreview},
+}
+
+// errUsage 参数错误
+// Returned when the command line is malformed
+var errUsage = errors.New("winicon: invalid arguments")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if err == errUsage {
+			usage(os.Stderr)
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run 根据第一个参数分派子命令
+// Dispatch to the subcommand named by the first argument
+func run(args []string, stdout io.Writer) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		return errUsage
+	}
+	return c.run(args[1:], stdout)
+}
+
+// usage 打印用法
+// Print the usage of every subcommand
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "usage:")
+	for _, k := range names {
+		fmt.Fprintln(w, "  winicon", commands[k].usage)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+func TestRunInfoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := run([]string{"info", "-json", "../../testico/icon.ico"}, &buf); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	var es []ico.EntryInfo
+	if err := json.Unmarshal(buf.Bytes(), &es); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if len(es) != 6 || es[0].Format != ico.FormatPNG || es[5].Width != 16 {
+		t.Errorf("info -json = %+v", es)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"nope"}, {"info"}} {
+		if err := run(args, new(bytes.Buffer)); err != errUsage {
+			t.Errorf("run(%q) = %v, want errUsage", args, err)
+		}
+	}
+}
+
+func TestRunMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "merged.ico")
+	args := []string{"merge", "-policy", "png", "-o", out, "../../testico/ICON16_1.ico", "../../testico/icon.ico"}
+	if err := run(args, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	wi, err := loadIcon(out)
+	if err != nil {
+		t.Fatalf("loadIcon() = %v", err)
+	}
+	if n := len(wi.Entries()); n != 8 {
+		t.Errorf("merged entries = %d, want 8", n)
+	}
+	// 输出文件也是输入时原地改写，保留权限，不留下临时文件
+	if err := os.Chmod(out, 0600); err != nil {
+		t.Fatal(err)
+	}
+	args = []string{"merge", "-policy", "last", "-o", out, out, "../../testico/ICON16_1.ico"}
+	if err := run(args, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run(in place) = %v", err)
+	}
+	if wi, err = loadIcon(out); err != nil || len(wi.Entries()) != 8 {
+		t.Errorf("in place merge = %v, %v", wi, err)
+	}
+	if fi, err := os.Stat(out); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("in place merge mode = %v, %v", fi, err)
+	}
+	if names, err := filepath.Glob(filepath.Join(dir, "*")); err != nil || len(names) != 1 {
+		t.Errorf("files after merge = %v, %v", names, err)
+	}
+}
+
+func TestRunExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	args := []string{"extract", "-format", "png", "-prefix", "x", "-o", dir, "../../testico/favicon.ico"}
+	if err := run(args, new(bytes.Buffer)); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x_icon256x256@32bit.png")); err != nil {
+		t.Errorf("extract: %v", err)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"favicon.ico", "icon.ico"} {
+		b, err := ioutil.ReadFile(filepath.Join("../../testico", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(src, n), b, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := filepath.Join(dir, "report.json")
+	out := filepath.Join(dir, "out")
+	var buf bytes.Buffer
+	args := []string{"batch", "-op", "extract", "-o", out, "-report", report, src}
+	if err := run(args, &buf); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	b, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r ico.BatchReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if r.Failed != 0 || r.Files != 2 {
+		t.Errorf("batch report = %+v\n%s", r, buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(out, "favicon-256x256-32.png")); err != nil {
+		t.Errorf("batch extract: %v", err)
+	}
+	// 没有 -o 时原地改写，不留下临时文件
+	buf.Reset()
+	if err := run([]string{"batch", "-op", "convert", "-to", "png", src}, &buf); err != nil {
+		t.Fatalf("run(convert) = %v", err)
+	}
+	names, err := filepath.Glob(filepath.Join(src, "*"))
+	if err != nil || len(names) != 2 {
+		t.Errorf("files after convert = %v, %v", names, err)
+	}
+	for _, n := range names {
+		f, err := os.Open(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wi, err := ico.LoadIconFile(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("LoadIconFile(%s) = %v", n, err)
+			continue
+		}
+		for _, e := range wi.Entries() {
+			if e.Format != ico.FormatPNG {
+				t.Errorf("%s entry %d format = %s", n, e.Index, e.Format)
+			}
+		}
+	}
+}
+
+func TestRunPreview(t *testing.T) {
+	var buf bytes.Buffer
+	if err := run([]string{"preview", "-bg", "light,dark", "../../testico/favicon.ico"}, &buf); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("preview is not a PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() < 256 || b.Dy() < 512 {
+		t.Errorf("preview is %dx%d", b.Dx(), b.Dy())
+	}
+	if err := run([]string{"preview", "-bg", "plaid", "../../testico/favicon.ico"}, &buf); err != errUsage {
+		t.Errorf("run(-bg plaid) = %v, want errUsage", err)
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	args := []string{"diff", "-json", "-o", dir, "../../testico/icon.ico", "../../testico/ICON16_1.ico"}
+	if err := run(args, &buf); err != nil {
+		t.Fatalf("run() = %v", err)
+	}
+	var r ico.DiffReport
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	if !r.Changed || len(r.Entries) != 8 {
+		t.Errorf("diff = %+v", r)
+	}
+	var images []string
+	for _, e := range r.Entries {
+		if e.Change == ico.DiffChanged && e.ChangedPixels > 0 {
+			images = append(images, fmt.Sprintf("diff%d-%dx%d@%dbit.png", e.IndexB, e.Width, e.Height, e.BitsB))
+		}
+	}
+	if len(images) == 0 {
+		t.Fatal("no entry with changed pixels")
+	}
+	for _, n := range images {
+		if _, err := os.Stat(filepath.Join(dir, n)); err != nil {
+			t.Errorf("diff image: %v", err)
+		}
+	}
+
+	// 相同的图标：PSNR 显示为 inf
+	buf.Reset()
+	if err := run([]string{"diff", "../../testico/icon.ico", "../../testico/icon.ico"}, &buf); err != nil {
+		t.Fatalf("run(same) = %v", err)
+	}
+	if !strings.Contains(buf.String(), "inf") {
+		t.Errorf("diff of identical icons:\n%s", buf.String())
+	}
+}
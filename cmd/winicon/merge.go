@@ -0,0 +1,84 @@
+// merge 子命令：合并多个ico文件
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// mergePolicies 命令行中的合并策略名称
+// Merge policy names accepted on the command line
+var mergePolicies = map[string]ico.MergePolicy{
+	"first": ico.PreferFirst,
+	"last":  ico.PreferLast,
+	"png":   ico.PreferPNG,
+	"depth": ico.PreferHigherDepth,
+}
+
+// runMerge 合并多个ico文件并写入输出文件
+// Merge several icon files into the output file
+func runMerge(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("o", "", "output `file`")
+	policy := fs.String("policy", "first", "conflict policy: first, last, png or depth")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	p, ok := mergePolicies[*policy]
+	if !ok || *out == "" || fs.NArg() < 1 {
+		return errUsage
+	}
+	icons := make([]*ico.WinIcon, fs.NArg())
+	for i, name := range fs.Args() {
+		wi, err := loadIcon(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		icons[i] = wi
+	}
+	wi, err := ico.Merge(p, icons...)
+	if err != nil {
+		return err
+	}
+	if err := writeIcon(*out, wi); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %d entries\n", *out, len(wi.Entries()))
+	return nil
+}
+
+// writeIcon 将图标写入文件。先写入同一目录中的临时文件再重命名，
+// path 也是图标的来源时，失败不会留下被截断的文件
+// Write an icon to a file. The icon goes to a temporary file in the
+// same directory that is then renamed, so a failure never leaves a
+// truncated file behind when path is also where the icon came from.
+func writeIcon(path string, wi *ico.WinIcon) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := wi.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
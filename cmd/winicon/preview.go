@@ -0,0 +1,63 @@
+// winicon preview 子命令，生成所有条目的预览图
+
+package main
+
+import (
+	"flag"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// sheetBackgrounds 命令行中的背景名称
+// Background names accepted on the command line
+var sheetBackgrounds = map[string]ico.Background{
+	"checker": ico.BackgroundChecker,
+	"light":   ico.BackgroundLight,
+	"dark":    ico.BackgroundDark,
+}
+
+// runPreview 将ico文件的所有条目绘制为一张PNG预览图
+// 没有 -o 时写入标准输出
+// Render every entry of an icon file into one PNG contact sheet,
+// written to stdout without -o
+func runPreview(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("preview", flag.ContinueOnError)
+	out := fs.String("o", "", "output PNG `file`, stdout when empty")
+	bg := fs.String("bg", "checker,light,dark", "comma separated backgrounds: checker, light, dark")
+	noLabels := fs.Bool("nolabels", false, "omit the size and depth labels")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+	opt := &ico.SheetOptions{NoLabels: *noLabels}
+	for _, name := range strings.Split(*bg, ",") {
+		b, ok := sheetBackgrounds[strings.TrimSpace(name)]
+		if !ok {
+			return errUsage
+		}
+		opt.Backgrounds = append(opt.Backgrounds, b)
+	}
+	wi, err := loadIcon(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	img := wi.ContactSheet(opt)
+	if *out == "" {
+		return png.Encode(stdout, img)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,344 @@
+// Package favicon 获取网页的图标(ICO、PNG或SVG)。
+//
+// Package favicon fetches the icon of a web page as ICO, PNG or SVG.
+package favicon
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gemark/WinIconTools/ico"
+	pngtool "github.com/gemark/WinIconTools/png"
+)
+
+// 图标的格式 Icon formats
+const (
+	FormatICO = "ico"
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// 默认的选项 Default options
+const (
+	DefaultSize      = 32               // 需要的像素数 wanted pixels
+	DefaultMaxPage   = 512 << 10        // 页面最多读取的字节数 bytes read from the page
+	DefaultMaxIcon   = 1 << 20          // 图标的最大字节数 largest icon in bytes
+	DefaultMaxPixels = 1 << 22          // 解码的图像的最大像素数 most pixels of a decoded image
+	DefaultTimeout   = 10 * time.Second // 整个获取过程的时限 time limit of the whole fetch
+)
+
+// 定义错误 Errors
+var (
+	ErrNotFound = errors.New("favicon: no usable icon found")      // 所有候选的图标都失败
+	ErrTooLarge = errors.New("favicon: icon exceeds size limit")   // 图标超过 MaxIcon
+	ErrFormat   = errors.New("favicon: unsupported icon format")   // 不是ICO、PNG或SVG
+	ErrPixels   = errors.New("favicon: image exceeds pixel limit") // 图像超过 MaxPixels
+)
+
+// Options FetchOptions 的选项，nil 或零值使用默认值
+// Options configures FetchOptions; nil or zero fields use the defaults
+type Options struct {
+	Size      int           // 需要的像素数(较长的一边)
+	Scale     float64       // 显示缩放比例，1.5 为150%
+	MaxPage   int64         // 页面最多读取的字节数，之后的 <link> 被忽略
+	MaxIcon   int64         // 图标的最大字节数
+	MaxPixels int64         // 解码的图像的最大像素数(宽*高)，在解码前按文件头检查
+	Timeout   time.Duration // 整个获取过程(页面及所有候选图标)的时限
+}
+
+// Icon 获取到的图标
+// Icon is a fetched icon
+type Icon struct {
+	URL         string       // 图标的地址(重定向之后)
+	ContentType string       // 响应的 Content-Type
+	Format      string       // FormatICO、FormatPNG 或 FormatSVG
+	Data        []byte       // 响应的数据
+	Width       int          // 选择的图像的宽度，SVG 为0
+	Height      int          // 选择的图像的高度，SVG 为0
+	Image       image.Image  // 选择的图像(未缩放)，SVG 为 nil
+	Icon        *ico.WinIcon // FormatICO 时载入的图标
+	Index       int          // FormatICO 时选择的条目，其他为 -1
+}
+
+// Fetch 使用默认选项获取 pageURL 的图标，见 FetchOptions
+// Fetch the icon of pageURL with the default options, see FetchOptions
+func Fetch(ctx context.Context, client *http.Client, pageURL string) (*Icon, error) {
+	return FetchOptions(ctx, client, pageURL, nil)
+}
+
+// FetchOptions 获取 pageURL 的图标。页面中 rel 为 icon 或
+// apple-touch-icon 的 <link> 按声明的尺寸排序(与 ico.WinIcon.BestIndex
+// 相同：相同尺寸、较大、再较小；可缩放的排在相同尺寸之后，未声明尺寸
+// 的排在较小之前)，最后是网站根目录的 /favicon.ico。依次下载，
+// 返回第一个可以使用的图标；ICO 使用 ico.LoadIconFile 载入并按
+// BestIndex 选择条目。client 为 nil 时使用 http.DefaultClient。
+// 超过时限时返回 ctx 的错误，所有候选都失败时返回 ErrNotFound
+// FetchOptions fetches the icon of pageURL. The <link> tags whose rel
+// is icon or apple-touch-icon are ordered by their declared sizes
+// following ico.WinIcon.BestIndex (same size, larger, then smaller;
+// scalable icons come after the same size, undeclared sizes before the
+// smaller ones), and /favicon.ico at the root of the site comes last.
+// They are downloaded in turn and the first usable icon is returned;
+// an ICO is loaded with ico.LoadIconFile and its entry chosen with
+// BestIndex. A nil client uses http.DefaultClient. The context error
+// is returned when time runs out, ErrNotFound when every candidate fails.
+func FetchOptions(ctx context.Context, client *http.Client, pageURL string, opt *Options) (*Icon, error) {
+	o := Options{}
+	if opt != nil {
+		o = *opt
+	}
+	if o.Size <= 0 {
+		o.Size = DefaultSize
+	}
+	if o.Scale <= 0 {
+		o.Scale = 1
+	}
+	if o.MaxPage <= 0 {
+		o.MaxPage = DefaultMaxPage
+	}
+	if o.MaxIcon <= 0 {
+		o.MaxIcon = DefaultMaxIcon
+	}
+	if o.MaxPixels <= 0 {
+		o.MaxPixels = DefaultMaxPixels
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	page, e := url.Parse(pageURL)
+	if e != nil {
+		return nil, e
+	}
+	if page.Scheme != "http" && page.Scheme != "https" {
+		return nil, fmt.Errorf("favicon: unsupported URL %q", pageURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+
+	cands := candidates(ctx, client, page, &o)
+	if e := ctx.Err(); e != nil {
+		return nil, e
+	}
+	for _, u := range cands {
+		icon, e := fetchIcon(ctx, client, u, &o)
+		if e == nil {
+			return icon, nil
+		}
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// candidates 下载页面并返回排序后的候选图标地址，最后是 /favicon.ico。
+// 页面无法获取或不是HTML时只有 /favicon.ico
+// Download the page and return the ordered icon URLs, /favicon.ico
+// last. Only /favicon.ico is returned when the page is not usable HTML.
+func candidates(ctx context.Context, client *http.Client, page *url.URL, o *Options) []string {
+	var us []string
+	seen := map[string]bool{}
+	add := func(u *url.URL) {
+		if (u.Scheme == "http" || u.Scheme == "https") && !seen[u.String()] {
+			seen[u.String()] = true
+			us = append(us, u.String())
+		}
+	}
+	res, e := get(ctx, client, page.String(), "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	if e == nil {
+		defer res.Body.Close()
+		page = res.Request.URL
+		if res.StatusCode == http.StatusOK && isHTML(res.Header.Get("Content-Type")) {
+			b, _ := ioutil.ReadAll(io.LimitReader(res.Body, o.MaxPage))
+			links, base := parseLinks(b)
+			t := int(float64(o.Size)*o.Scale + 0.5)
+			sort.SliceStable(links, func(i, j int) bool {
+				ri, di := links[i].rank(t)
+				rj, dj := links[j].rank(t)
+				if ri != rj {
+					return ri < rj
+				}
+				if di != dj {
+					return di < dj
+				}
+				return !links[i].touch && links[j].touch
+			})
+			ref := page
+			if b, e := page.Parse(base); base != "" && e == nil {
+				ref = b
+			}
+			for _, l := range links {
+				if u, e := ref.Parse(l.href); e == nil {
+					add(u)
+				}
+			}
+		}
+	}
+	add(&url.URL{Scheme: page.Scheme, Host: page.Host, Path: "/favicon.ico"})
+	return us
+}
+
+// isHTML Content-Type 是否为HTML，没有时也视为HTML
+// Whether the Content-Type is HTML; a missing one counts as HTML
+func isHTML(ct string) bool {
+	ct = strings.ToLower(ct)
+	return ct == "" || strings.HasPrefix(ct, "text/html") || strings.HasPrefix(ct, "application/xhtml+xml")
+}
+
+// rank 图标相对于 t 像素的顺序及距离，越小越合适：
+// 0 相同尺寸，1 可缩放，2 较大，3 未声明尺寸，4 较小
+// Order and distance of the icon for t pixels, smaller is better:
+// 0 the same size, 1 scalable, 2 larger, 3 undeclared, 4 smaller
+func (l link) rank(t int) (int, int) {
+	r, d := 5, 0
+	if l.any || l.typ == "image/svg+xml" {
+		r = 1
+	}
+	for _, s := range l.sizes {
+		var rs, ds int
+		switch {
+		case s == t:
+			rs, ds = 0, 0
+		case s > t:
+			rs, ds = 2, s-t
+		default:
+			rs, ds = 4, t-s
+		}
+		if rs < r || rs == r && ds < d {
+			r, d = rs, ds
+		}
+	}
+	if r == 5 {
+		r = 3
+	}
+	return r, d
+}
+
+// get 发送 GET 请求
+// Send a GET request
+func get(ctx context.Context, client *http.Client, u, accept string) (*http.Response, error) {
+	req, e := http.NewRequest(http.MethodGet, u, nil)
+	if e != nil {
+		return nil, e
+	}
+	req.Header.Set("Accept", accept)
+	return client.Do(req.WithContext(ctx))
+}
+
+// fetchIcon 下载并解码一个候选图标
+// Download and decode a candidate icon
+func fetchIcon(ctx context.Context, client *http.Client, u string, o *Options) (*Icon, error) {
+	res, e := get(ctx, client, u, "image/png,image/x-icon,image/svg+xml,image/*;q=0.8,*/*;q=0.5")
+	if e != nil {
+		return nil, e
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("favicon: %s: %s", u, res.Status)
+	}
+	if res.ContentLength > o.MaxIcon {
+		return nil, ErrTooLarge
+	}
+	b, e := ioutil.ReadAll(io.LimitReader(res.Body, o.MaxIcon+1))
+	if e != nil {
+		return nil, e
+	}
+	if int64(len(b)) > o.MaxIcon {
+		return nil, ErrTooLarge
+	}
+	icon := &Icon{
+		URL:         res.Request.URL.String(),
+		ContentType: res.Header.Get("Content-Type"),
+		Data:        b,
+		Index:       -1,
+	}
+	if e := icon.decode(o); e != nil {
+		return nil, e
+	}
+	return icon, nil
+}
+
+// decode 根据数据的开头判断格式并解码，ICO 按 BestIndex 选择条目。
+// 解码前按PNG的IHDR或条目的图像数据检查像素数，超过 MaxPixels 时
+// 返回 ErrPixels
+// Detect the format from the leading bytes and decode the data,
+// choosing the entry of an ICO with BestIndex. The pixel count is
+// checked from the IHDR of a PNG or the image data of the entry
+// before decoding; more than MaxPixels fails with ErrPixels.
+func (icon *Icon) decode(o *Options) error {
+	b := icon.Data
+	switch {
+	case bytes.HasPrefix(b, []byte{0, 0, 1, 0}):
+		wi, e := ico.LoadIconFile(bytes.NewReader(b))
+		if e != nil {
+			return e
+		}
+		i := wi.BestIndex(o.Size, o.Scale)
+		w, h, e := wi.ImageSize(i)
+		if e != nil {
+			return e
+		}
+		if int64(w)*int64(h) > o.MaxPixels {
+			return ErrPixels
+		}
+		m, e := wi.Image(i)
+		if e != nil {
+			return e
+		}
+		icon.Format, icon.Icon, icon.Index, icon.Image = FormatICO, wi, i, m
+	case bytes.HasPrefix(b, pngtool.PNGHEAD):
+		c, e := png.DecodeConfig(bytes.NewReader(b))
+		if e != nil {
+			return e
+		}
+		if int64(c.Width)*int64(c.Height) > o.MaxPixels {
+			return ErrPixels
+		}
+		m, e := png.Decode(bytes.NewReader(b))
+		if e != nil {
+			return e
+		}
+		icon.Format, icon.Image = FormatPNG, m
+	case isSVG(b):
+		icon.Format = FormatSVG
+		return nil
+	default:
+		return ErrFormat
+	}
+	icon.Width, icon.Height = icon.Image.Bounds().Dx(), icon.Image.Bounds().Dy()
+	return nil
+}
+
+// isSVG 数据的根元素是否为 <svg>
+// Whether the root element of the data is <svg>
+func isSVG(b []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	// 只需要根元素的名字，不转换字符集
+	// only the name of the root element matters, keep the bytes as is
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	for {
+		t, e := d.Token()
+		if e != nil {
+			return false
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			return se.Name.Local == "svg"
+		}
+	}
+}
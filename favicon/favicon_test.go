@@ -0,0 +1,240 @@
+package favicon
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+func solid(size int) image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i := range m.Pix {
+		m.Pix[i] = 0xff
+	}
+	return m
+}
+
+func encodePNG(t *testing.T, size int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, solid(size)); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// encodeICO 包含指定尺寸条目的图标
+func encodeICO(t *testing.T, sizes ...int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := ico.NewWriter(&b, new(bytes.Buffer))
+	for _, s := range sizes {
+		if err := w.AddImage(solid(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+const svg = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 16 16"><rect width="16" height="16"/></svg>`
+
+// site 测试用的网站，路径对应响应的内容，Content-Type 根据扩展名设置
+type site map[string]string
+
+func (s site) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := s[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".svg"):
+			w.Header().Set("Content-Type", "image/svg+xml")
+		case strings.HasSuffix(r.URL.Path, ".ico"):
+			w.Header().Set("Content-Type", "image/x-icon")
+		case strings.HasSuffix(r.URL.Path, ".png"):
+			w.Header().Set("Content-Type", "image/png")
+		case strings.HasSuffix(r.URL.Path, "/"):
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write([]byte(body))
+	}))
+	return ts
+}
+
+func TestFetch(t *testing.T) {
+	icon := string(encodeICO(t, 16, 32, 48))
+	tests := []struct {
+		name   string
+		site   site
+		opt    *Options
+		path   string // 期望的图标路径
+		format string
+		size   int
+	}{
+		{
+			name: "fallback",
+			site: site{"/": `<html><head><title>x</title></head></html>`, "/favicon.ico": icon},
+			path: "/favicon.ico", format: FormatICO, size: 32,
+		},
+		{
+			name: "page not found",
+			site: site{"/favicon.ico": icon},
+			path: "/favicon.ico", format: FormatICO, size: 32,
+		},
+		{
+			name: "best size",
+			site: site{
+				"/": `<link rel=icon href=/i16.png sizes=16x16><link rel=icon href=i64.png sizes=64x64>
+<link rel=icon href=/i32.png sizes=32x32>`,
+				"/i16.png": string(encodePNG(t, 16)), "/i32.png": string(encodePNG(t, 32)), "/i64.png": string(encodePNG(t, 64)),
+			},
+			path: "/i32.png", format: FormatPNG, size: 32,
+		},
+		{
+			name: "scale",
+			site: site{
+				"/":        `<link rel=icon href=/i32.png sizes=32x32><link rel=icon href=i64.png sizes=64x64>`,
+				"/i32.png": string(encodePNG(t, 32)), "/i64.png": string(encodePNG(t, 64)),
+			},
+			opt:  &Options{Size: 32, Scale: 2},
+			path: "/i64.png", format: FormatPNG, size: 64,
+		},
+		{
+			name: "ico entry",
+			site: site{"/": `<link rel="shortcut icon" href="/static/app.ico">`, "/static/app.ico": icon},
+			opt:  &Options{Size: 16},
+			path: "/static/app.ico", format: FormatICO, size: 16,
+		},
+		{
+			name: "svg",
+			site: site{"/": `<link rel=icon href=/i16.png sizes=16x16><link rel=icon type=image/svg+xml href=/i.svg>`,
+				"/i16.png": string(encodePNG(t, 16)), "/i.svg": svg},
+			path: "/i.svg", format: FormatSVG,
+		},
+		{
+			name: "base",
+			site: site{"/": `<base href="/assets/"><link rel=icon href=i.png>`, "/assets/i.png": string(encodePNG(t, 16))},
+			path: "/assets/i.png", format: FormatPNG, size: 16,
+		},
+		{
+			name: "skip broken",
+			site: site{
+				"/":            `<link rel=icon href=/missing.png sizes=32x32><link rel=icon href=/text.png sizes=32x32><link rel=icon href=/ok.png>`,
+				"/text.png":    "<html>not an image</html>",
+				"/ok.png":      string(encodePNG(t, 24)),
+				"/favicon.ico": icon,
+			},
+			path: "/ok.png", format: FormatPNG, size: 24,
+		},
+		{
+			name: "too large",
+			site: site{"/": `<link rel=icon href=/big.png sizes=32x32>`, "/big.png": string(encodePNG(t, 32)) + strings.Repeat("x", 4096),
+				"/favicon.ico": string(encodePNG(t, 16))},
+			opt:  &Options{MaxIcon: 4096},
+			path: "/favicon.ico", format: FormatPNG, size: 16,
+		},
+		{
+			name: "too many pixels",
+			site: site{"/": `<link rel=icon href=/big.png sizes=32x32>`, "/big.png": string(encodePNG(t, 64)),
+				"/favicon.ico": string(encodePNG(t, 16))},
+			opt:  &Options{MaxPixels: 32 * 32},
+			path: "/favicon.ico", format: FormatPNG, size: 16,
+		},
+	}
+	for _, tt := range tests {
+		ts := tt.site.server(t)
+		got, err := FetchOptions(context.Background(), ts.Client(), ts.URL+"/", tt.opt)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.URL != ts.URL+tt.path || got.Format != tt.format || got.Width != tt.size || got.Data == nil {
+			t.Errorf("%s: got %s %s %dx%d, want %s %s %d", tt.name, got.URL, got.Format, got.Width, got.Height, tt.path, tt.format, tt.size)
+		}
+		if (got.Format == FormatICO) != (got.Icon != nil && got.Index >= 0) {
+			t.Errorf("%s: Icon %v, Index %d", tt.name, got.Icon, got.Index)
+		}
+		if (got.Format == FormatSVG) != (got.Image == nil) {
+			t.Errorf("%s: Image %v", tt.name, got.Image)
+		}
+	}
+}
+
+func TestFetch_Errors(t *testing.T) {
+	ts := site{"/": `<link rel=icon href=/i.gif>`, "/i.gif": "GIF89a", "/favicon.ico": "\x00\x00\x01\x00"}.server(t)
+	defer ts.Close()
+	if _, err := Fetch(context.Background(), ts.Client(), ts.URL); err != ErrNotFound {
+		t.Errorf("Fetch() = %v, want ErrNotFound", err)
+	}
+	if _, err := Fetch(context.Background(), nil, "ftp://example.com/"); err == nil {
+		t.Error("Fetch(ftp) = nil error")
+	}
+
+	// 超过时限
+	done := make(chan struct{})
+	defer close(done)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	start := time.Now()
+	_, err := FetchOptions(context.Background(), slow.Client(), slow.URL, &Options{Timeout: 50 * time.Millisecond})
+	if err != context.DeadlineExceeded {
+		t.Errorf("FetchOptions(slow) = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("FetchOptions(slow) took %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Fetch(ctx, ts.Client(), ts.URL); err != context.Canceled {
+		t.Errorf("Fetch(canceled) = %v, want context.Canceled", err)
+	}
+}
+
+func TestIcon_DecodePixels(t *testing.T) {
+	icon := &Icon{Data: encodeICO(t, 16, 48), Index: -1}
+	if err := icon.decode(&Options{Size: 48, Scale: 1, MaxPixels: 32 * 32}); err != ErrPixels {
+		t.Errorf("decode(48x48 entry) = %v, want ErrPixels", err)
+	}
+	if err := icon.decode(&Options{Size: 16, Scale: 1, MaxPixels: 32 * 32}); err != nil || icon.Width != 16 {
+		t.Errorf("decode(16x16 entry) = %v, width %d", err, icon.Width)
+	}
+}
+
+func TestIsSVG(t *testing.T) {
+	for s, want := range map[string]bool{
+		svg:                           true,
+		`<svg/>`:                      true,
+		`<!-- c --><svg></svg>`:       true,
+		`<html><svg></svg></html>`:    false,
+		"GIF89a":                      false,
+		"\x89PNG\r\n\x1a\n":           false,
+		`<?xml version="1.0"?><rss/>`: false,
+	} {
+		if got := isSVG([]byte(s)); got != want {
+			t.Errorf("isSVG(%q) = %v", s, got)
+		}
+	}
+}
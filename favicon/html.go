@@ -0,0 +1,180 @@
+// 从HTML页面中读取图标的<link>标签及<base>
+
+package favicon
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// link 页面中声明的一个图标
+// An icon declared by the page
+type link struct {
+	href  string
+	typ   string // type 属性 the type attribute
+	sizes []int  // sizes 属性中的尺寸(较长的一边) longer sides listed by sizes
+	any   bool   // sizes="any"，可缩放的图标 a scalable icon
+	touch bool   // rel="apple-touch-icon"
+}
+
+// parseLinks 读取 <head> 中 rel 含有 icon 或 apple-touch-icon 的 <link>
+// 标签及 <base href>。注释、<script> 及 <style> 的内容被跳过，
+// 遇到 </head> 或 <body> 时停止
+// Read the <link> tags whose rel lists icon or apple-touch-icon and
+// the <base href> of the head. Comments and the contents of <script>
+// and <style> are skipped; parsing stops at </head> or <body>.
+func parseLinks(b []byte) (links []link, base string) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			break
+		}
+		b = b[i+1:]
+		if bytes.HasPrefix(b, []byte("!--")) {
+			if j := bytes.Index(b, []byte("-->")); j >= 0 {
+				b = b[j+3:]
+				continue
+			}
+			break
+		}
+		name, attrs, rest := parseTag(b)
+		b = rest
+		switch name {
+		case "script", "style":
+			// 跳过内容直到结束标签
+			// skip the contents up to the end tag
+			j := bytes.Index(bytes.ToLower(b), []byte("</"+name))
+			if j < 0 {
+				return links, base
+			}
+			b = b[j:]
+		case "/head", "body":
+			return links, base
+		case "base":
+			if h, ok := attrs["href"]; ok && base == "" {
+				base = h
+			}
+		case "link":
+			if l, ok := iconLink(attrs); ok {
+				links = append(links, l)
+			}
+		}
+	}
+	return links, base
+}
+
+// parseTag 解析 '<' 之后的标签名及属性，返回标签之后的数据。
+// 名字及属性名转为小写，属性值已解码HTML实体；没有 '>' 时名字为空
+// Parse the name and attributes of the tag following '<' and return
+// the data after it. Names are lower case and values are unescaped;
+// the name is empty when the tag has no '>'.
+func parseTag(b []byte) (name string, attrs map[string]string, rest []byte) {
+	i := 0
+	for i < len(b) && !isSpace(b[i]) && b[i] != '>' && !(b[i] == '/' && i > 0) {
+		i++
+	}
+	name = strings.ToLower(string(b[:i]))
+	attrs = map[string]string{}
+	for i < len(b) {
+		for i < len(b) && (isSpace(b[i]) || b[i] == '/') {
+			i++
+		}
+		if i >= len(b) {
+			break
+		}
+		if b[i] == '>' {
+			return name, attrs, b[i+1:]
+		}
+		s := i
+		for i < len(b) && !isSpace(b[i]) && b[i] != '=' && b[i] != '>' && b[i] != '/' {
+			i++
+		}
+		key := strings.ToLower(string(b[s:i]))
+		for i < len(b) && isSpace(b[i]) {
+			i++
+		}
+		val := ""
+		if i < len(b) && b[i] == '=' {
+			i++
+			for i < len(b) && isSpace(b[i]) {
+				i++
+			}
+			if i < len(b) && (b[i] == '"' || b[i] == '\'') {
+				q := b[i]
+				i++
+				s = i
+				for i < len(b) && b[i] != q {
+					i++
+				}
+				val = string(b[s:i])
+				if i < len(b) {
+					i++
+				}
+			} else {
+				s = i
+				for i < len(b) && !isSpace(b[i]) && b[i] != '>' {
+					i++
+				}
+				val = string(b[s:i])
+			}
+		}
+		if _, dup := attrs[key]; !dup {
+			attrs[key] = html.UnescapeString(val)
+		}
+	}
+	// 没有结束的标签被忽略
+	// an unterminated tag is ignored
+	return "", nil, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// iconLink 将 <link> 的属性转换为 link，rel 不是图标时返回 false
+// Convert the attributes of a <link>; false when rel is not an icon
+func iconLink(attrs map[string]string) (link, bool) {
+	l := link{href: strings.TrimSpace(attrs["href"]), typ: strings.ToLower(attrs["type"])}
+	if l.href == "" {
+		return l, false
+	}
+	ok := false
+	for _, r := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		switch r {
+		case "icon":
+			ok = true
+		case "apple-touch-icon", "apple-touch-icon-precomposed":
+			ok, l.touch = true, true
+		}
+	}
+	for _, s := range strings.Fields(strings.ToLower(attrs["sizes"])) {
+		if s == "any" {
+			l.any = true
+			continue
+		}
+		wh := strings.SplitN(s, "x", 2)
+		if len(wh) != 2 {
+			continue
+		}
+		w, e1 := strconv.Atoi(wh[0])
+		h, e2 := strconv.Atoi(wh[1])
+		if e1 == nil && e2 == nil && w > 0 && h > 0 {
+			l.sizes = append(l.sizes, imax(w, h))
+		}
+	}
+	// 没有声明尺寸的 apple-touch-icon 为180像素
+	// an apple-touch-icon without sizes is 180 pixels
+	if l.touch && len(l.sizes) == 0 {
+		l.sizes = []int{180}
+	}
+	return l, ok
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
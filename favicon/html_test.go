@@ -0,0 +1,65 @@
+package favicon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	page := `<!DOCTYPE html>
+<HTML><Head>
+<base href="/static/">
+<!-- <link rel="icon" href="commented.png"> -->
+<script>document.write('<link rel="icon" href="script.png">')</script>
+<LINK REL="Shortcut Icon" HREF="favicon.ico">
+<link rel=icon href=a.png sizes=16x16>
+<link rel="icon" type="image/svg+xml" sizes="any" href="icon.svg?v=1&amp;x=2"/>
+<link rel="apple-touch-icon" href='touch.png'>
+<link rel="icon" sizes="32x32 48X64 bad" href="b.png">
+<link rel="stylesheet" href="style.css">
+<link rel="icon">
+</head>
+<body><link rel="icon" href="body.png"></body>`
+	links, base := parseLinks([]byte(page))
+	if base != "/static/" {
+		t.Errorf("base = %q", base)
+	}
+	want := []link{
+		{href: "favicon.ico"},
+		{href: "a.png", sizes: []int{16}},
+		{href: "icon.svg?v=1&x=2", typ: "image/svg+xml", any: true},
+		{href: "touch.png", sizes: []int{180}, touch: true},
+		{href: "b.png", sizes: []int{32, 64}},
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("parseLinks() =\n%+v\nwant\n%+v", links, want)
+	}
+
+	// 没有结束的注释及标签
+	for _, s := range []string{`<link rel="icon" href="x`, `<!-- <link rel=icon href=x>`, `<`, `<script><link rel=icon href=x>`} {
+		if links, _ := parseLinks([]byte(s)); len(links) != 0 {
+			t.Errorf("parseLinks(%q) = %+v", s, links)
+		}
+	}
+}
+
+func TestLinkRank(t *testing.T) {
+	tests := []struct {
+		l    link
+		r, d int
+	}{
+		{link{sizes: []int{32}}, 0, 0},
+		{link{sizes: []int{16, 32, 64}}, 0, 0},
+		{link{any: true}, 1, 0},
+		{link{typ: "image/svg+xml"}, 1, 0},
+		{link{sizes: []int{48, 180}}, 2, 16},
+		{link{}, 3, 0},
+		{link{sizes: []int{16, 24}}, 4, 8},
+		{link{any: true, sizes: []int{16}}, 1, 0},
+	}
+	for _, tt := range tests {
+		if r, d := tt.l.rank(32); r != tt.r || d != tt.d {
+			t.Errorf("%+v.rank(32) = %d, %d, want %d, %d", tt.l, r, d, tt.r, tt.d)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// 使用有限数量的协程批量处理目录中的ico文件
+
+package ico
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BatchFunc 批量处理时对每个ico文件执行的操作
+// 返回值会记录在 BatchResult 中
+// BatchFunc is the operation applied to every icon file of a
+// batch. The returned value is recorded in the BatchResult.
+type BatchFunc func(path string, wi *WinIcon) (interface{}, error)
+
+// BatchOptions 批量处理的选项
+// Options of a batch run
+type BatchOptions struct {
+	Workers int                    // 并发数，<=0 时为CPU数量 number of workers, the CPU count when <= 0
+	Match   func(path string) bool // 选择要处理的文件，默认为 .ico 文件 selects the files, .ico files by default
+}
+
+// BatchResult 单个文件的处理结果
+// Outcome of a single file
+type BatchResult struct {
+	Path   string      `json:"path"`             // 文件路径
+	Result interface{} `json:"result,omitempty"` // BatchFunc 的返回值
+	Error  string      `json:"error,omitempty"`  // 错误信息，成功时为空
+}
+
+// BatchReport 批量处理的汇总报告，结果按路径排序
+// Summary of a batch run, with the results sorted by path
+type BatchReport struct {
+	Root    string        `json:"root"`    // 处理的目录
+	Files   int           `json:"files"`   // 处理的文件数
+	Failed  int           `json:"failed"`  // 失败的文件数
+	Results []BatchResult `json:"results"` // 每个文件的结果
+	// 遍历目录树时无法访问的路径，不计入 Files 及 Failed
+	// paths that could not be walked, not counted in Files or Failed
+	WalkErrors []BatchResult `json:"walk_errors,omitempty"`
+}
+
+// Batch 遍历 root 目录树，使用有限数量的协程对每个匹配的文件
+// 载入ico并执行 fn。单个文件的错误记录在报告中，不会中止处理。
+// 只有 root 本身无法访问时才返回 error。opt 为 nil 时使用默认选项
+// Batch walks the tree at root and loads every matching file and
+// applies fn to it on a bounded pool of workers. Errors of single
+// files are recorded in the report and do not stop the run; an
+// error is returned only when root itself cannot be walked.
+// A nil opt uses the defaults.
+func Batch(root string, opt *BatchOptions, fn BatchFunc) (*BatchReport, error) {
+	if opt == nil {
+		opt = new(BatchOptions)
+	}
+	workers := opt.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	match := opt.Match
+	if match == nil {
+		match = func(p string) bool { return strings.EqualFold(filepath.Ext(p), ".ico") }
+	}
+	if _, e := os.Stat(root); e != nil {
+		return nil, e
+	}
+
+	var (
+		paths    = make(chan string)
+		results  = make(chan BatchResult)
+		walkErrs []BatchResult // 关闭 results 之前由遍历的协程写入
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				results <- batchFile(p, fn)
+			}
+		}()
+	}
+	go func() {
+		filepath.Walk(root, func(p string, fi os.FileInfo, e error) error {
+			if e != nil {
+				walkErrs = append(walkErrs, BatchResult{Path: p, Error: e.Error()})
+				return nil
+			}
+			if !fi.IsDir() && match(p) {
+				paths <- p
+			}
+			return nil
+		})
+		close(paths)
+		wg.Wait()
+		close(results)
+	}()
+
+	r := &BatchReport{Root: root}
+	for v := range results {
+		r.Files++
+		if v.Error != "" {
+			r.Failed++
+		}
+		r.Results = append(r.Results, v)
+	}
+	sort.Slice(r.Results, func(i, j int) bool { return r.Results[i].Path < r.Results[j].Path })
+	r.WalkErrors = walkErrs
+	return r, nil
+}
+
+// batchFile 载入单个ico文件并执行 fn，panic 也作为错误记录
+// Load a single file and apply fn, recording a panic as an error
+func batchFile(p string, fn BatchFunc) (r BatchResult) {
+	r.Path = p
+	defer func() {
+		if e := recover(); e != nil {
+			r.Result = nil
+			r.Error = fmt.Sprintf("panic: %v", e)
+		}
+	}()
+	fs, e := os.Open(p)
+	if e != nil {
+		r.Error = e.Error()
+		return r
+	}
+	defer fs.Close()
+	wi, e := LoadIconFile(fs)
+	if e != nil {
+		r.Error = e.Error()
+		return r
+	}
+	v, e := fn(p, wi)
+	if e != nil {
+		r.Error = e.Error()
+		return r
+	}
+	r.Result = v
+	return r
+}
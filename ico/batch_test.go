@@ -0,0 +1,118 @@
+package ico
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyTestIcons 将testico目录中的ico文件复制到 dir，并写入一个损坏的ico文件
+func copyTestIcons(t *testing.T, dir string) {
+	t.Helper()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"ICON16_1.ico", "favicon.ico", "icon.ico"} {
+		b, err := ioutil.ReadFile(testFile(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(sub, n), b, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := []byte{0, 0, 1, 0, 1, 0, 16, 16, 0, 0, 1, 0, 32, 0, 0xff, 0xff, 0, 0, 22, 0, 0, 0}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.ico"), bad, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skip"), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	copyTestIcons(t, dir)
+
+	r, err := Batch(dir, &BatchOptions{Workers: 2}, func(p string, wi *WinIcon) (interface{}, error) {
+		return len(wi.Entries()), wi.Validate()
+	})
+	if err != nil {
+		t.Fatalf("Batch() = %v", err)
+	}
+	if r.Files != 4 || r.Failed != 1 {
+		t.Fatalf("Batch() files = %d, failed = %d, want 4 and 1", r.Files, r.Failed)
+	}
+	want := []struct {
+		name  string
+		count interface{}
+		fail  bool
+	}{
+		{"bad.ico", nil, true},
+		{"sub/ICON16_1.ico", 8, false},
+		{"sub/favicon.ico", 1, false},
+		{"sub/icon.ico", 6, false},
+	}
+	for i, w := range want {
+		v := r.Results[i]
+		if v.Path != filepath.Join(dir, w.name) || v.Result != w.count || (v.Error != "") != w.fail {
+			t.Errorf("result %d = %+v, want %+v", i, v, w)
+		}
+	}
+	if _, err := Batch(filepath.Join(dir, "missing"), nil, nil); err == nil {
+		t.Errorf("Batch(missing) = nil error")
+	}
+}
+
+func TestBatch_WalkError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	copyTestIcons(t, dir)
+	locked := filepath.Join(dir, "locked")
+	if err := os.Mkdir(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0777)
+	if _, err := ioutil.ReadDir(locked); err == nil {
+		t.Skip("directory permissions are not enforced")
+	}
+
+	r, err := Batch(dir, nil, func(p string, wi *WinIcon) (interface{}, error) { return nil, nil })
+	if err != nil {
+		t.Fatalf("Batch() = %v", err)
+	}
+	// 无法遍历的目录不算作文件
+	if r.Files != 4 || r.Failed != 1 || len(r.Results) != 4 {
+		t.Errorf("Batch() files = %d, failed = %d, results = %d, want 4, 1 and 4", r.Files, r.Failed, len(r.Results))
+	}
+	if len(r.WalkErrors) != 1 || r.WalkErrors[0].Path != locked || r.WalkErrors[0].Error == "" {
+		t.Errorf("WalkErrors = %+v", r.WalkErrors)
+	}
+}
+
+func TestBatch_Panic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	copyTestIcons(t, dir)
+	r, err := Batch(dir, nil, func(p string, wi *WinIcon) (interface{}, error) {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatalf("Batch() = %v", err)
+	}
+	if r.Failed != 4 || r.Results[1].Error != "panic: boom" {
+		t.Errorf("Batch() = %+v", r)
+	}
+}
@@ -0,0 +1,95 @@
+// 根据尺寸和DPI缩放比例选择最合适的图标条目
+
+package ico
+
+import (
+	"image"
+	"sort"
+)
+
+// BestIndex 按照Windows的规则选择最适合 size 像素、scale 缩放比例
+// (如1.5即150%)的条目：尺寸相同的优先，其次是最接近的较大尺寸，
+// 最后是最接近的较小尺寸；尺寸相同时位深度高的优先。
+// scale <= 0 时视为1。没有条目时返回-1
+// BestIndex picks the entry that suits size pixels at the display
+// scale (1.5 for 150%) following the Windows rules: the exact size
+// first, then the closest larger size, then the closest smaller one;
+// among entries of the same size the highest bit depth wins.
+// A scale <= 0 counts as 1. It returns -1 when there are no entries.
+func (wi *WinIcon) BestIndex(size int, scale float64) int {
+	c := wi.bestCandidates(targetSize(size, scale))
+	if len(c) == 0 {
+		return -1
+	}
+	return c[0]
+}
+
+// Best 返回 BestIndex 选择的条目及其图像。条目的尺寸不同时，
+// 图像按比例重新采样为 size*scale 像素(较长的一边)。
+// 选择的条目无法解码时依次尝试下一个，全部失败时返回 -1, nil
+// Best returns the entry chosen by BestIndex and its image. When the
+// entry has another size the image is resampled so that its longer
+// side is size*scale pixels. An entry that fails to decode is skipped
+// for the next best one; -1 and nil are returned when none decodes.
+func (wi *WinIcon) Best(size int, scale float64) (int, image.Image) {
+	t := targetSize(size, scale)
+	for _, i := range wi.bestCandidates(t) {
+		img, e := wi.Image(i)
+		if e != nil {
+			continue
+		}
+		b := img.Bounds()
+		d := imax(b.Dx(), b.Dy())
+		if d == t {
+			return i, img
+		}
+		return i, scaleImage(img, imax(1, b.Dx()*t/d), imax(1, b.Dy()*t/d))
+	}
+	return -1, nil
+}
+
+// targetSize 根据缩放比例计算实际需要的像素数，四舍五入
+// Pixels needed for size at scale, rounded to the nearest
+func targetSize(size int, scale float64) int {
+	if scale <= 0 {
+		scale = 1
+	}
+	return imax(1, int(float64(size)*scale+0.5))
+}
+
+// bestCandidates 按照适合程度排序的条目索引
+// Entry indexes ordered from the best match for t pixels to the worst
+func (wi *WinIcon) bestCandidates(t int) []int {
+	c := make([]int, len(wi.icos))
+	for i := range c {
+		c[i] = i
+	}
+	// rank 0为尺寸相同，1为较大，2为较小
+	// rank 0 is the exact size, 1 larger, 2 smaller
+	rank := func(d int) int {
+		switch {
+		case d == t:
+			return 0
+		case d > t:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		a, b := wi.icos[c[i]], wi.icos[c[j]]
+		da := imax(a.getIconWidth(), a.getIconHeight())
+		db := imax(b.getIconWidth(), b.getIconHeight())
+		ra, rb := rank(da), rank(db)
+		if ra != rb {
+			return ra < rb
+		}
+		if da != db {
+			// 较大的取最小的，较小的取最大的
+			// closest larger, or closest smaller
+			return (da < db) == (ra == 1)
+		}
+		return a.getIconBitsPerPixel() > b.getIconBitsPerPixel()
+	})
+	return c
+}
@@ -0,0 +1,54 @@
+package ico
+
+import "testing"
+
+func TestWinIcon_Best(t *testing.T) {
+	wi := loadTestIcon(t, "icon.ico")
+	tests := []struct {
+		size  int
+		scale float64
+		index int
+		px    int
+	}{
+		{24, 1, 4, 24},
+		{24, 0, 4, 24},
+		{24, 1.5, 2, 36},
+		{16, 2, 3, 32},
+		{20, 1, 4, 20},
+		{300, 1, 0, 300},
+		{8, 1, 5, 8},
+	}
+	for _, tt := range tests {
+		if got := wi.BestIndex(tt.size, tt.scale); got != tt.index {
+			t.Errorf("BestIndex(%d, %v) = %d, want %d", tt.size, tt.scale, got, tt.index)
+		}
+		i, img := wi.Best(tt.size, tt.scale)
+		if i != tt.index || img == nil {
+			t.Fatalf("Best(%d, %v) = %d, %v", tt.size, tt.scale, i, img)
+		}
+		if b := img.Bounds(); b.Dx() != tt.px || b.Dy() != tt.px {
+			t.Errorf("Best(%d, %v) image is %dx%d, want %d", tt.size, tt.scale, b.Dx(), b.Dy(), tt.px)
+		}
+	}
+	if i, img := new(WinIcon).Best(16, 1); i != -1 || img != nil {
+		t.Errorf("Best() of an empty icon = %d, %v", i, img)
+	}
+}
+
+func TestWinIcon_BestDepth(t *testing.T) {
+	dib8 := winIconStruct{Width: 32, Height: 32, BitsPerPixel: 8, data: encodeDIB(newTestImage(32, 32))}
+	dib32 := dib8
+	dib32.BitsPerPixel = 32
+	broken := winIconStruct{Width: 16, Height: 16, BitsPerPixel: 32, data: []byte{1, 2, 3}}
+	wi := &WinIcon{icos: WinIconStruct{dib8, dib32, broken}}
+	if got := wi.BestIndex(32, 1); got != 1 {
+		t.Errorf("BestIndex(32) = %d, want the 32bit entry", got)
+	}
+	// 无法解码的条目被跳过
+	if got := wi.BestIndex(16, 1); got != 2 {
+		t.Errorf("BestIndex(16) = %d, want 2", got)
+	}
+	if i, img := wi.Best(16, 1); i != 1 || img.Bounds().Dx() != 16 {
+		t.Errorf("Best(16) = %d, %v, want the 32bit entry scaled", i, img.Bounds())
+	}
+}
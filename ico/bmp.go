@@ -0,0 +1,136 @@
+// 将图标条目导出为标准的BMP文件
+
+package ico
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+// BMP 相关的常量
+// BMP constants
+const (
+	dibV5HeaderSize = 124        // BITMAPV5HEADER 的大小
+	biBitFields     = 3          // BI_BITFIELDS
+	lcsSRGB         = 0x73524742 // LCS_sRGB 'sRGB'
+	lcsGMImages     = 4          // LCS_GM_IMAGES
+)
+
+// BMPOptions 导出BMP文件时的选项
+// Options used when exporting an entry as a BMP file
+type BMPOptions struct {
+	// V5 使用BITMAPV5HEADER写入带alpha通道的32位BMP
+	// V5 writes a 32-bit BMP with a BITMAPV5HEADER so image
+	// editors keep the alpha channel.
+	V5 bool
+}
+
+// WriteBMP 将指定的图标条目以BMP文件格式写入 io.Writer
+// DIB条目保持原有的位深度和调色板，去掉AND掩码；没有alpha通道的
+// 32位条目使用AND掩码生成alpha。PNG条目写入为32位BMP。
+// opt 为 nil 时使用默认选项
+// WriteBMP writes the entry at index as a BMP file to w. DIB entries
+// keep their bit depth and color table and the AND mask is dropped;
+// for 32-bit entries without alpha the mask is turned into alpha.
+// PNG entries are written as 32-bit BMPs. A nil opt uses the defaults.
+func (wi *WinIcon) WriteBMP(w io.Writer, index int, opt *BMPOptions) error {
+	if index < 0 || index >= len(wi.icos) {
+		return ErrIconsIndex
+	}
+	if e := wi.load(index); e != nil {
+		return e
+	}
+	d, e := wi.icos[index].encodeBMP(opt)
+	if e != nil {
+		return e
+	}
+	_, e = w.Write(d)
+	return e
+}
+
+// encodeBMP 将图标条目编码为BMP文件数据
+// Encode the entry as the data of a BMP file
+func (wis winIconStruct) encodeBMP(opt *BMPOptions) ([]byte, error) {
+	if opt == nil {
+		opt = new(BMPOptions)
+	}
+	if GetIconType(wis.data) != typeBMP || opt.V5 {
+		img, e := wis.decodeIcon()
+		if e != nil {
+			return nil, e
+		}
+		return encodeBMPImage(img, opt.V5), nil
+	}
+	di := getDIBInfo(wis.data)
+	w, h := di.width, di.height/2
+	if e := di.check(h, len(wis.data)); e != nil {
+		return nil, e
+	}
+	xs := di.xorStride()
+	po := di.headerSize
+	xo := po + di.paletteBytes()
+	pal := wis.data[po:xo]
+	bits := wis.data[xo : xo+xs*h]
+	if di.bits == 32 && di.hasMask(len(wis.data)) && !hasAlpha(bits) {
+		img, e := decodeDIB(wis.data)
+		if e != nil {
+			return nil, e
+		}
+		return encodeBMPImage(img, false), nil
+	}
+	dib := createDIBHeader(w, h, di.bits, len(bits), len(pal)/4, 0)
+	bmh := createBitmapHeader(dibHeaderSize+len(pal)+len(bits), dibHeaderSize+len(pal))
+	d := make([]byte, 0, bitmapHeaderSize+dibHeaderSize+len(pal)+len(bits))
+	d = append(d, bmh.headerToBytes()...)
+	d = append(d, dib.HeaderToBytes()...)
+	d = append(d, pal...)
+	return append(d, bits...), nil
+}
+
+// hasAlpha 32位像素数据中是否有非0的alpha值
+// Reports whether 32-bit pixel data has any non-zero alpha
+func hasAlpha(bits []byte) bool {
+	for i := 3; i < len(bits); i += 4 {
+		if bits[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBMPImage 将图像编码为32位BMP文件数据，v5 为真时使用BITMAPV5HEADER
+// Encode an image as a 32-bit BMP file, with a BITMAPV5HEADER when v5 is set
+func encodeBMPImage(img image.Image, v5 bool) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	hs := dibHeaderSize
+	if v5 {
+		hs = dibV5HeaderSize
+	}
+	bmh := createBitmapHeader(hs+w*h*4, hs)
+	d := make([]byte, bitmapHeaderSize+hs+w*h*4)
+	copy(d, bmh.headerToBytes())
+	copy(d[bitmapHeaderSize:], createDIBHeader(w, h, 32, w*h*4, 0, 0).HeaderToBytes())
+	if v5 {
+		v := d[bitmapHeaderSize:]
+		binary.LittleEndian.PutUint32(v[0:4], dibV5HeaderSize)
+		binary.LittleEndian.PutUint32(v[16:20], biBitFields)
+		binary.LittleEndian.PutUint32(v[40:44], 0x00ff0000)
+		binary.LittleEndian.PutUint32(v[44:48], 0x0000ff00)
+		binary.LittleEndian.PutUint32(v[48:52], 0x000000ff)
+		binary.LittleEndian.PutUint32(v[52:56], 0xff000000)
+		binary.LittleEndian.PutUint32(v[56:60], lcsSRGB)
+		binary.LittleEndian.PutUint32(v[108:112], lcsGMImages)
+	}
+	p := d[bitmapHeaderSize+hs:]
+	for y := 0; y < h; y++ {
+		row := p[(h-1-y)*w*4:]
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = c.B, c.G, c.R, c.A
+		}
+	}
+	return d
+}
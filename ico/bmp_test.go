@@ -0,0 +1,121 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+// newPalettedDIB 创建一个8位带调色板的DIB图标数据，左上角的像素是透明的
+func newPalettedDIB(w, h int) []byte {
+	di := dibInfo{headerSize: dibHeaderSize, width: w, height: h * 2, bits: 8, colors: 256}
+	xs, as := di.xorStride(), di.andStride()
+	d := make([]byte, dibHeaderSize+256*4+xs*h+as*h)
+	copy(d, createDIBHeader(w, h*2, 8, xs*h+as*h, 0, 0).HeaderToBytes())
+	for i := 0; i < 256; i++ {
+		p := d[dibHeaderSize+i*4:]
+		p[0], p[1], p[2] = uint8(i), uint8(255-i), 0x40
+	}
+	xor := d[dibHeaderSize+256*4:]
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			xor[y*xs+x] = uint8(x*16 + y)
+		}
+	}
+	xor[xs*h+(h-1)*as] = 0x80
+	return d
+}
+
+func TestWinIcon_WriteBMP(t *testing.T) {
+	d := newPalettedDIB(16, 16)
+	wi := &WinIcon{icos: WinIconStruct{{Width: 16, Height: 16, BitsPerPixel: 8, data: d}}}
+	orig := append([]byte(nil), d...)
+
+	var buf bytes.Buffer
+	if err := wi.WriteBMP(&buf, 0, nil); err != nil {
+		t.Fatalf("WriteBMP() = %v", err)
+	}
+	b := buf.Bytes()
+	if got := int(binary.LittleEndian.Uint32(b[2:6])); got != len(b) {
+		t.Errorf("bmp file size = %d, want %d", got, len(b))
+	}
+	if got := int(binary.LittleEndian.Uint32(b[10:14])); got != bitmapHeaderSize+dibHeaderSize+256*4 {
+		t.Errorf("bmp data offset = %d", got)
+	}
+	img, err := bmp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("bmp.Decode() = %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 16, 16) {
+		t.Fatalf("bmp bounds = %v", img.Bounds())
+	}
+	// 第0行是最下面的一行 row 0 of the DIB is the bottom row
+	i := 3*16 + 2
+	want := color.RGBA{0x40, uint8(255 - i), uint8(i), 0xff}
+	if got := color.RGBAModel.Convert(img.At(3, 13)); got != want {
+		t.Errorf("pixel (3, 13) = %v, want %v", got, want)
+	}
+	if !bytes.Equal(d, orig) {
+		t.Errorf("WriteBMP() modified the icon data")
+	}
+}
+
+func TestWinIcon_WriteBMP_Invalid(t *testing.T) {
+	for name, set := range map[string]func([]byte){
+		"huge height": func(d []byte) { binary.LittleEndian.PutUint32(d[8:], 0x7ffffffe) },
+		"colors":      func(d []byte) { binary.LittleEndian.PutUint32(d[32:], 1000) },
+		"bits 7":      func(d []byte) { binary.LittleEndian.PutUint16(d[14:], 7) },
+	} {
+		d := newPalettedDIB(16, 16)
+		set(d)
+		wi := &WinIcon{icos: WinIconStruct{{Width: 16, Height: 16, BitsPerPixel: 8, data: d}}}
+		if err := wi.WriteBMP(ioutil.Discard, 0, nil); err != ErrIcoInvalid {
+			t.Errorf("%s: WriteBMP() = %v, want ErrIcoInvalid", name, err)
+		}
+	}
+}
+
+func TestWinIcon_WriteBMPV5(t *testing.T) {
+	wi := loadTestIcon(t, "ICON16_1.ico")
+	for _, i := range []int{0, 1} {
+		var buf bytes.Buffer
+		if err := wi.WriteBMP(&buf, i, &BMPOptions{V5: true}); err != nil {
+			t.Fatalf("WriteBMP(%d) = %v", i, err)
+		}
+		if got := binary.LittleEndian.Uint32(buf.Bytes()[14:18]); got != dibV5HeaderSize {
+			t.Errorf("WriteBMP(%d) header size = %d", i, got)
+		}
+		got, err := bmp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("bmp.Decode() = %v", err)
+		}
+		want, err := wi.icos[i].decodeIcon()
+		if err != nil {
+			t.Fatalf("decodeIcon() = %v", err)
+		}
+		if !sameNRGBA(got, want) {
+			t.Errorf("WriteBMP(%d) pixels differ from the entry", i)
+		}
+	}
+}
+
+// sameNRGBA 比较两个图像的NRGBA像素
+func sameNRGBA(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
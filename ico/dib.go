@@ -0,0 +1,288 @@
+// 图标图像数据(DIB/PNG)的编码
+
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// encodeDIB 将图像编码为ico中使用的32位DIB数据(包含AND掩码)
+// Encode an image as the 32-bit DIB used inside ico files,
+// including the AND mask. Rows are stored bottom to top.
+func encodeDIB(img image.Image) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	di := dibInfo{headerSize: dibHeaderSize, width: w, height: h * 2, bits: 32}
+	xs, as := di.xorStride(), di.andStride()
+	d := make([]byte, dibHeaderSize+xs*h+as*h)
+	dib := createDIBHeader(w, h*2, 32, xs*h+as*h, 0, 0)
+	copy(d, dib.HeaderToBytes())
+	xor := d[dibHeaderSize:]
+	and := xor[xs*h:]
+	for y := 0; y < h; y++ {
+		row := h - 1 - y
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			p := xor[row*xs+x*4:]
+			p[0], p[1], p[2], p[3] = c.B, c.G, c.R, c.A
+			if c.A == 0 {
+				and[row*as+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return d
+}
+
+// bmpToIconData 将BMP文件中的DIB数据转换为ico中的DIB数据
+// 高度加倍并在末尾追加AND掩码，32位图像的掩码根据alpha通道生成，
+// alpha全为0(XRGB)时图像不透明。
+// 使用标准掩码的 BI_BITFIELDS 转换为 BI_RGB；其他掩码、压缩、
+// 无效的尺寸或位深度返回 ErrIcoInvalid
+// Convert the DIB of a BMP file to the DIB stored in an ico file.
+// The height is doubled and an AND mask is appended, derived from
+// the alpha channel for 32-bit images; an all-zero alpha channel
+// (XRGB) makes the image opaque. BI_BITFIELDS with the standard
+// masks becomes BI_RGB; other masks or compressions, invalid sizes
+// and bit depths fail with ErrIcoInvalid.
+func bmpToIconData(d []byte) ([]byte, error) {
+	if len(d) < dibHeaderSize {
+		return nil, ErrIcoInvalid
+	}
+	d, e := bitfieldsToRGB(d)
+	if e != nil {
+		return nil, e
+	}
+	di := getDIBInfo(d)
+	topDown := di.height < 0
+	if topDown {
+		di.height = -di.height
+	}
+	h := di.height
+	if e := di.check(h, len(d)); e != nil {
+		return nil, e
+	}
+	xs, as := di.xorStride(), di.andStride()
+	o := di.headerSize + di.paletteBytes()
+	r := make([]byte, o+xs*h+as*h)
+	copy(r, d[:o+xs*h])
+	if topDown { // ico中的DIB总是自下而上存储
+		for y := 0; y < h; y++ {
+			copy(r[o+y*xs:o+(y+1)*xs], d[o+(h-1-y)*xs:])
+		}
+	}
+	binary.LittleEndian.PutUint32(r[8:12], uint32(h*2))
+	if di.bits == 32 {
+		// alpha全为0的是XRGB图像，所有像素不透明，掩码保持为空
+		// all-zero alpha means XRGB: every pixel is opaque, the mask stays clear
+		xrgb := !hasAlpha(r[o : o+xs*h])
+		and := r[o+xs*h:]
+		for y := 0; y < h; y++ {
+			for x := 0; x < di.width; x++ {
+				p := o + y*xs + x*4 + 3
+				if xrgb {
+					r[p] = 0xff
+				} else if r[p] == 0 {
+					and[y*as+x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+// bitfieldsToRGB 将使用标准掩码(与 BI_RGB 的布局相同)的 BI_BITFIELDS
+// 转换为 BI_RGB：40字节的头之后的3个掩码被去掉，更大的头中的掩码保留
+// 但不再使用。其他掩码返回 ErrIcoInvalid，不是 BI_BITFIELDS 时原样返回
+// Turn BI_BITFIELDS with the standard masks, which have the BI_RGB
+// layout, into BI_RGB: the three masks following a 40-byte header are
+// dropped, those inside a larger header stay unused. Other masks fail
+// with ErrIcoInvalid; data that is not BI_BITFIELDS is returned as is.
+func bitfieldsToRGB(d []byte) ([]byte, error) {
+	di := getDIBInfo(d)
+	if di.compression != biBitFields {
+		return d, nil
+	}
+	if len(d) < dibHeaderSize+12 {
+		return nil, ErrIcoInvalid
+	}
+	masks := [3]uint32{
+		binary.LittleEndian.Uint32(d[40:44]),
+		binary.LittleEndian.Uint32(d[44:48]),
+		binary.LittleEndian.Uint32(d[48:52]),
+	}
+	switch {
+	case di.bits == 32 && masks == [3]uint32{0xff0000, 0xff00, 0xff}:
+	case di.bits == 16 && masks == [3]uint32{0x7c00, 0x3e0, 0x1f}:
+	default:
+		return nil, ErrIcoInvalid
+	}
+	var r []byte
+	if di.headerSize == dibHeaderSize {
+		r = append(append(r, d[:dibHeaderSize]...), d[dibHeaderSize+12:]...)
+	} else {
+		r = append(r, d...)
+	}
+	binary.LittleEndian.PutUint32(r[16:20], 0)
+	return r, nil
+}
+
+// check 检查DIB头：未压缩(BI_RGB)、宽及高 h 为正、支持的位深度
+// (1、4、8、16、24、32)、调色板的颜色数有效，并且调色板及 h 行的
+// 颜色数据不超过 size 字节。先用除法比较，计算步长时不会溢出
+// Check the DIB header: uncompressed (BI_RGB), a positive width and
+// height h, a supported depth (1, 4, 8, 16, 24 or 32), a valid color
+// count, and the color table plus h rows fitting in size bytes. The
+// comparisons divide first so that no stride computation overflows.
+func (di dibInfo) check(h, size int) error {
+	if di.headerSize < dibHeaderSize || di.compression != 0 || di.width <= 0 || h <= 0 {
+		return ErrIcoInvalid
+	}
+	switch di.bits {
+	case 1, 4, 8, 16, 24, 32:
+	default:
+		return ErrIcoInvalid
+	}
+	if di.colors < 0 || di.colors > 256 || di.bits <= 8 && di.colors > 1<<uint(di.bits) {
+		return ErrIcoInvalid
+	}
+	o := di.headerSize + di.paletteBytes()
+	if di.headerSize > size || o > size || di.width > size*8 {
+		return ErrIcoInvalid
+	}
+	if h > (size-o)/di.xorStride() {
+		return ErrIcoInvalid
+	}
+	return nil
+}
+
+// encodePNG 将图像编码为PNG数据
+// Encode an image as PNG data
+func encodePNG(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := new(png.Encoder)
+	enc.CompressionLevel = png.BestCompression
+	if e := enc.Encode(buf, img); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// imageToIcon 将图像转换为 winIconStruct 对象
+// 宽或高达到256时使用PNG存储，否则使用32位DIB
+// Convert an image to a winIconStruct object. Images of 256
+// pixels or more are stored as PNG, smaller ones as 32-bit DIB.
+func imageToIcon(img image.Image, asPNG bool) (winIconStruct, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 {
+		return winIconStruct{}, ErrIcoInvalid
+	}
+	var (
+		d []byte
+		e error
+	)
+	if asPNG || w >= 256 || h >= 256 {
+		d, e = encodePNG(img)
+		if e != nil {
+			return winIconStruct{}, e
+		}
+	} else {
+		d = encodeDIB(img)
+	}
+	if GetIconType(d) == typePNG {
+		// 编码器可能使用24位、灰度或索引色，位数从IHDR读取
+		// the encoder may pick RGB, gray or paletted, read the depth from IHDR
+		wis, e := pngToIcon(d)
+		wis.data = d
+		return wis, e
+	}
+	wis := winIconStruct{
+		ColorPlanes:   1,
+		BitsPerPixel:  32,
+		ImageDataSize: uint32(len(d)),
+		data:          d,
+	}
+	wis.setIconWidth(w)
+	wis.setIconHeight(h)
+	return wis, nil
+}
+
+// decodeDIB 解码ico中的DIB数据(包含AND掩码)
+// 支持未压缩的1、4、8、16、24及32位图像
+// Decode the DIB stored in an ico file, applying the AND mask.
+// Uncompressed 1, 4, 8, 16, 24 and 32-bit images are supported.
+func decodeDIB(d []byte) (*image.NRGBA, error) {
+	di := getDIBInfo(d)
+	w, h := di.width, di.height/2
+	if e := di.check(h, len(d)); e != nil {
+		return nil, e
+	}
+	po := di.headerSize
+	xo := po + di.paletteBytes()
+	xs, as := di.xorStride(), di.andStride()
+	var and []byte
+	if di.hasMask(len(d)) {
+		and = d[xo+xs*h:]
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	alpha := false
+	for y := 0; y < h; y++ {
+		row := d[xo+(h-1-y)*xs:]
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch di.bits {
+			case 32:
+				p := row[x*4:]
+				c = color.NRGBA{p[2], p[1], p[0], p[3]}
+				alpha = alpha || p[3] != 0
+			case 24:
+				p := row[x*3:]
+				c = color.NRGBA{p[2], p[1], p[0], 0xff}
+			case 16: // 5-5-5
+				v := binary.LittleEndian.Uint16(row[x*2:])
+				c = color.NRGBA{uint8(v>>10&0x1f) << 3, uint8(v>>5&0x1f) << 3, uint8(v&0x1f) << 3, 0xff}
+			default:
+				ppb := 8 / di.bits
+				s := uint(8 - di.bits*(x%ppb+1))
+				i := int(row[x/ppb]>>s) & (1<<uint(di.bits) - 1)
+				if i >= di.colors || po+i*4+4 > len(d) {
+					return nil, ErrIcoInvalid
+				}
+				p := d[po+i*4:]
+				c = color.NRGBA{p[2], p[1], p[0], 0xff}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	// 没有alpha通道的32位图像以及低于32位的图像使用AND掩码
+	// Images without an alpha channel use the AND mask
+	if !alpha {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				o := img.PixOffset(x, y)
+				img.Pix[o+3] = 0xff
+				if and != nil && and[(h-1-y)*as+x/8]&(0x80>>uint(x%8)) != 0 {
+					img.Pix[o+3] = 0
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// decodeIcon 将图标条目解码为图像
+// Decode an icon entry to an image
+func (wis winIconStruct) decodeIcon() (image.Image, error) {
+	switch GetIconType(wis.data) {
+	case typePNG:
+		return png.Decode(bytes.NewReader(wis.data))
+	case typeBMP:
+		return decodeDIB(wis.data)
+	default:
+		return nil, ErrIcoInvalid
+	}
+}
@@ -0,0 +1,112 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// craftedBMP 2x2 的32位BMP文件，set 修改DIB头
+func craftedBMP(set func(dib []byte)) []byte {
+	d := make([]byte, bitmapHeaderSize+dibHeaderSize+2*2*4)
+	copy(d, createBitmapHeader(dibHeaderSize+16, dibHeaderSize).headerToBytes())
+	copy(d[bitmapHeaderSize:], createDIBHeader(2, 2, 32, 16, 0, 0).HeaderToBytes())
+	set(d[bitmapHeaderSize:])
+	return d
+}
+
+func TestCreateWinIcon_InvalidBMP(t *testing.T) {
+	u32 := func(o int, v uint32) func([]byte) {
+		return func(d []byte) { binary.LittleEndian.PutUint32(d[o:], v) }
+	}
+	u16 := func(o int, v uint16) func([]byte) {
+		return func(d []byte) { binary.LittleEndian.PutUint16(d[o:], v) }
+	}
+	tests := map[string]func([]byte){
+		"negative width":  u32(4, uint32(0xfffffffe)),
+		"zero height":     u32(8, 0),
+		"huge width":      u32(4, 0x7fffffff),
+		"huge height":     u32(8, 0x7fffffff),
+		"bits 7":          u16(14, 7),
+		"rle":             u32(16, 1),
+		"bitfields":       u32(16, 3),
+		"colors":          u32(32, 1000),
+		"short header":    u32(0, 12),
+		"header too long": u32(0, 0x10000),
+	}
+	dir, err := ioutil.TempDir("", "dib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, set := range tests {
+		p := filepath.Join(dir, name+".bmp")
+		if err := ioutil.WriteFile(p, craftedBMP(set), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CreateWinIcon([]string{p}); err == nil || !strings.Contains(err.Error(), ErrIcoInvalid.Error()) {
+			t.Errorf("%s: CreateWinIcon() = %v, want ErrIcoInvalid", name, err)
+		}
+	}
+}
+
+func TestBmpToIconData_Bitfields(t *testing.T) {
+	rgb := craftedBMP(func([]byte) {})[bitmapHeaderSize:]
+	for i := dibHeaderSize; i < len(rgb); i++ {
+		rgb[i] = uint8(i)
+	}
+	// 标准掩码的 BI_BITFIELDS 与 BI_RGB 得到相同的数据
+	bf := append([]byte(nil), rgb[:dibHeaderSize]...)
+	binary.LittleEndian.PutUint32(bf[16:], biBitFields)
+	var masks [12]byte
+	binary.LittleEndian.PutUint32(masks[0:], 0xff0000)
+	binary.LittleEndian.PutUint32(masks[4:], 0xff00)
+	binary.LittleEndian.PutUint32(masks[8:], 0xff)
+	bf = append(append(bf, masks[:]...), rgb[dibHeaderSize:]...)
+	want, err := bmpToIconData(rgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bmpToIconData(bf)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("bmpToIconData(BI_BITFIELDS) = %v, %v", got, err)
+	}
+	// 其他掩码
+	binary.LittleEndian.PutUint32(bf[dibHeaderSize:], 0xff)
+	if _, err := bmpToIconData(bf); err != ErrIcoInvalid {
+		t.Errorf("bmpToIconData(BGR masks) error = %v, want ErrIcoInvalid", err)
+	}
+}
+
+func TestBmpToIconData_XRGB(t *testing.T) {
+	// alpha全为0的32位BMP是不透明的
+	d, err := bmpToIconData(craftedBMP(func([]byte) {})[bitmapHeaderSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := decodeDIB(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			t.Fatalf("pixel %d alpha = %d, want 255", i/4, img.Pix[i])
+		}
+	}
+	if mask := d[len(d)-2*4:]; !bytes.Equal(mask, make([]byte, len(mask))) {
+		t.Errorf("AND mask = %v, want clear", mask)
+	}
+	// 有alpha时透明像素写入掩码
+	bmp := craftedBMP(func([]byte) {})
+	bmp[len(bmp)-1] = 0xff
+	if d, err = bmpToIconData(bmp[bitmapHeaderSize:]); err != nil {
+		t.Fatal(err)
+	}
+	if mask := d[len(d)-2*4:]; mask[0] != 0xc0 || mask[4] != 0x80 {
+		t.Errorf("AND mask = %v", mask)
+	}
+}
@@ -0,0 +1,237 @@
+// 比较两个ico图标的结构及像素差异
+
+package ico
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// 条目差异的类型
+// Kinds of entry differences
+const (
+	DiffSame    = "same"    // 完全相同
+	DiffChanged = "changed" // 位深度、存储格式或像素不同
+	DiffAdded   = "added"   // 只在新图标中存在
+	DiffRemoved = "removed" // 只在旧图标中存在
+)
+
+// DiffOptions 比较图标的选项
+// Options of Diff
+type DiffOptions struct {
+	Image bool // 为像素不同的条目生成高亮差异图 render a highlighted image of the changed pixels
+}
+
+// EntryDiff 相同尺寸的两个条目之间的差异
+// 只在一个图标中存在的条目，另一边的索引为-1
+// EntryDiff describes how the entries of one size differ. The index
+// on the side that lacks the entry is -1.
+type EntryDiff struct {
+	Width         int         `json:"width"`           // 宽度
+	Height        int         `json:"height"`          // 高度
+	Change        string      `json:"change"`          // DiffSame, DiffChanged, DiffAdded 或 DiffRemoved
+	IndexA        int         `json:"indexA"`          // 旧图标中的索引
+	IndexB        int         `json:"indexB"`          // 新图标中的索引
+	BitsA         int         `json:"bitsA"`           // 旧条目的位深度
+	BitsB         int         `json:"bitsB"`           // 新条目的位深度
+	FormatA       string      `json:"formatA"`         // 旧条目的存储格式
+	FormatB       string      `json:"formatB"`         // 新条目的存储格式
+	ChangedPixels int         `json:"changedPixels"`   // 不同的像素数
+	MaxDelta      int         `json:"maxDelta"`        // 单个颜色通道的最大差值(0-255)
+	PSNR          float64     `json:"psnr,omitempty"`  // 峰值信噪比(dB)，像素相同或没有比较时为0
+	Identical     bool        `json:"identical"`       // 解码后的像素完全相同(PSNR为无穷大)
+	Image         image.Image `json:"-"`               // 高亮差异图，见 DiffOptions
+	Error         string      `json:"error,omitempty"` // 解码失败时的错误信息
+}
+
+// DiffReport 两个图标的比较结果，条目按尺寸从大到小排序
+// Result of Diff, with the entries ordered from the largest size
+type DiffReport struct {
+	Changed bool        `json:"changed"` // 存在任何差异
+	Entries []EntryDiff `json:"entries"` // 每个尺寸的差异
+}
+
+// Diff 比较图标 a(旧) 和 b(新)。条目先按宽、高和位深度配对，
+// 剩下的再按宽和高配对，因此位深度的变化报告为同一尺寸的改变。
+// 配对的条目解码后逐像素比较；完全透明的像素忽略其颜色。
+// 图标为 nil 时视为空图标，opt 为 nil 时使用默认选项
+// Diff compares the icons a (old) and b (new). Entries are paired by
+// width, height and bit depth first and the rest by width and height,
+// so a depth change is reported as a change of that size. Paired
+// entries are decoded and compared pixel by pixel; the color of fully
+// transparent pixels is ignored. A nil icon counts as empty and
+// a nil opt uses the defaults.
+func Diff(a, b *WinIcon, opt *DiffOptions) *DiffReport {
+	if opt == nil {
+		opt = new(DiffOptions)
+	}
+	if a == nil {
+		a = new(WinIcon)
+	}
+	if b == nil {
+		b = new(WinIcon)
+	}
+	pairs := pairEntries(a, b)
+	r := &DiffReport{Entries: make([]EntryDiff, 0, len(pairs))}
+	for _, p := range pairs {
+		d := EntryDiff{IndexA: p[0], IndexB: p[1]}
+		if p[0] >= 0 {
+			e := a.icos[p[0]].probe().entryInfo(p[0], 0)
+			d.Width, d.Height, d.BitsA, d.FormatA = e.Width, e.Height, e.BitsPerPixel, e.Format
+		}
+		if p[1] >= 0 {
+			e := b.icos[p[1]].probe().entryInfo(p[1], 0)
+			d.Width, d.Height, d.BitsB, d.FormatB = e.Width, e.Height, e.BitsPerPixel, e.Format
+		}
+		switch {
+		case p[0] < 0:
+			d.Change = DiffAdded
+		case p[1] < 0:
+			d.Change = DiffRemoved
+		default:
+			d.comparePixels(a, b, opt)
+			d.Change = DiffSame
+			if d.BitsA != d.BitsB || d.FormatA != d.FormatB || d.ChangedPixels > 0 || d.Error != "" {
+				d.Change = DiffChanged
+			}
+		}
+		if d.Change != DiffSame {
+			r.Changed = true
+		}
+		r.Entries = append(r.Entries, d)
+	}
+	return r
+}
+
+// pairEntries 将两个图标的条目配对，缺少的一边为-1
+// Pair the entries of a and b, -1 standing for a missing side
+func pairEntries(a, b *WinIcon) [][2]int {
+	var pairs [][2]int
+	usedB := make([]bool, len(b.icos))
+	usedA := make([]bool, len(a.icos))
+	match := func(depth bool) {
+		for i, va := range a.icos {
+			if usedA[i] {
+				continue
+			}
+			for j, vb := range b.icos {
+				if usedB[j] || va.getIconWidth() != vb.getIconWidth() || va.getIconHeight() != vb.getIconHeight() {
+					continue
+				}
+				if depth && va.getIconBitsPerPixel() != vb.getIconBitsPerPixel() {
+					continue
+				}
+				usedA[i], usedB[j] = true, true
+				pairs = append(pairs, [2]int{i, j})
+				break
+			}
+		}
+	}
+	match(true)
+	match(false)
+	for i := range a.icos {
+		if !usedA[i] {
+			pairs = append(pairs, [2]int{i, -1})
+		}
+	}
+	for j := range b.icos {
+		if !usedB[j] {
+			pairs = append(pairs, [2]int{-1, j})
+		}
+	}
+	entry := func(p [2]int) winIconStruct {
+		if p[0] >= 0 {
+			return a.icos[p[0]]
+		}
+		return b.icos[p[1]]
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		x, y := entry(pairs[i]), entry(pairs[j])
+		if x.getIconWidth() != y.getIconWidth() {
+			return x.getIconWidth() > y.getIconWidth()
+		}
+		return x.getIconHeight() > y.getIconHeight()
+	})
+	return pairs
+}
+
+// comparePixels 解码两个条目并统计像素差异，解码失败记录在 Error 中
+// Decode both entries and measure their pixel difference.
+// A decode failure is recorded in Error.
+func (d *EntryDiff) comparePixels(a, b *WinIcon, opt *DiffOptions) {
+	ia, e := a.Image(d.IndexA)
+	if e != nil {
+		d.Error = fmt.Sprintf("old entry: %v", e)
+		return
+	}
+	ib, e := b.Image(d.IndexB)
+	if e != nil {
+		d.Error = fmt.Sprintf("new entry: %v", e)
+		return
+	}
+	ba, bb := ia.Bounds(), ib.Bounds()
+	if ba.Size() != bb.Size() {
+		d.Error = fmt.Sprintf("image sizes %v and %v differ", ba.Size(), bb.Size())
+		return
+	}
+	var hl *image.NRGBA
+	if opt.Image {
+		hl = image.NewNRGBA(image.Rect(0, 0, bb.Dx(), bb.Dy()))
+	}
+	var sum float64
+	for y := 0; y < bb.Dy(); y++ {
+		for x := 0; x < bb.Dx(); x++ {
+			ca := diffColor(ia.At(ba.Min.X+x, ba.Min.Y+y))
+			cb := diffColor(ib.At(bb.Min.X+x, bb.Min.Y+y))
+			delta := 0
+			for _, c := range [][2]uint8{{ca.R, cb.R}, {ca.G, cb.G}, {ca.B, cb.B}, {ca.A, cb.A}} {
+				v := int(c[0]) - int(c[1])
+				if v < 0 {
+					v = -v
+				}
+				sum += float64(v * v)
+				delta = imax(delta, v)
+			}
+			if delta > 0 {
+				d.ChangedPixels++
+				d.MaxDelta = imax(d.MaxDelta, delta)
+			}
+			if hl != nil {
+				hl.SetNRGBA(x, y, highlightColor(cb, delta))
+			}
+		}
+	}
+	d.Identical = d.ChangedPixels == 0
+	if d.ChangedPixels > 0 {
+		mse := sum / float64(bb.Dx()*bb.Dy()*4)
+		d.PSNR = 10 * math.Log10(255*255/mse)
+		if hl != nil {
+			d.Image = hl
+		}
+	}
+}
+
+// diffColor 转换为非预乘的颜色，完全透明的像素统一为0
+// Non-premultiplied color, with every fully transparent pixel as zero
+func diffColor(c color.Color) color.NRGBA {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0 {
+		return color.NRGBA{}
+	}
+	return n
+}
+
+// highlightColor 差异图中的颜色：不同的像素为红色(差异越大越不透明)，
+// 相同的像素为新图像的淡灰色
+// Color in the highlighted image: changed pixels are red, more opaque
+// for larger deltas, unchanged ones a faint gray of the new image
+func highlightColor(c color.NRGBA, delta int) color.NRGBA {
+	if delta > 0 {
+		return color.NRGBA{0xff, 0, 0, uint8(0x80 + delta/2)}
+	}
+	y := uint8((299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000)
+	return color.NRGBA{y, y, y, c.A / 4}
+}
@@ -0,0 +1,93 @@
+package ico
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := loadTestIcon(t, "icon.ico")
+	if r := Diff(a, loadTestIcon(t, "icon.ico"), nil); r.Changed || len(r.Entries) != 6 {
+		t.Fatalf("Diff(same) = %+v", r)
+	}
+
+	b := loadTestIcon(t, "icon.ico")
+	// 24x24: 修改一个像素
+	img, err := b.Image(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := scaleImage(img, 24, 24)
+	c := m.NRGBAAt(3, 3)
+	c.R ^= 0x40
+	c.A = 0xff
+	m.SetNRGBA(3, 3, c)
+	if err := b.ReplaceEntry(4, m); err != nil {
+		t.Fatal(err)
+	}
+	// 48x48: 转换为PNG，像素不变
+	n, err := b.icos[2].decodeIcon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.icos[2], err = imageToIcon(n, true); err != nil {
+		t.Fatal(err)
+	}
+	// 32x32: 只改变位深度
+	b.icos[3].BitsPerPixel = 24
+	// 删除16x16，添加20x20
+	if err := b.RemoveEntry(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddEntry(newTestImage(20, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Diff(a, b, &DiffOptions{Image: true})
+	if !r.Changed {
+		t.Fatalf("Diff() reports no change")
+	}
+	want := map[int]string{256: DiffSame, 64: DiffSame, 48: DiffChanged, 32: DiffChanged, 24: DiffChanged, 20: DiffAdded, 16: DiffRemoved}
+	if len(r.Entries) != len(want) {
+		t.Fatalf("Diff() = %d entries, want %d", len(r.Entries), len(want))
+	}
+	for i, e := range r.Entries {
+		if i > 0 && e.Width > r.Entries[i-1].Width {
+			t.Errorf("entries are not ordered by size")
+		}
+		if e.Change != want[e.Width] {
+			t.Errorf("%dx%d change = %s, want %s", e.Width, e.Height, e.Change, want[e.Width])
+		}
+		if e.Identical != (e.Change == DiffSame || e.Width == 48 || e.Width == 32) {
+			t.Errorf("%dx%d identical = %v", e.Width, e.Height, e.Identical)
+		}
+		switch e.Width {
+		case 48:
+			if e.FormatA != FormatDIB || e.FormatB != FormatPNG || e.ChangedPixels != 0 || e.Image != nil {
+				t.Errorf("48x48 = %+v, want a format change only", e)
+			}
+		case 32:
+			if e.BitsA != 32 || e.BitsB != 24 || e.ChangedPixels != 0 {
+				t.Errorf("32x32 = %+v, want a depth change only", e)
+			}
+		case 24:
+			if e.ChangedPixels != 1 || e.MaxDelta == 0 || e.PSNR <= 0 || e.Image == nil {
+				t.Fatalf("24x24 = %+v, want one changed pixel", e)
+			}
+			if got := color.NRGBAModel.Convert(e.Image.At(3, 3)).(color.NRGBA); got.R != 0xff || got.G != 0 {
+				t.Errorf("highlighted pixel = %v", got)
+			}
+		case 20:
+			if e.IndexA != -1 || e.BitsB != 32 {
+				t.Errorf("20x20 = %+v", e)
+			}
+		case 16:
+			if e.IndexB != -1 {
+				t.Errorf("16x16 = %+v", e)
+			}
+		}
+	}
+	if r := Diff(nil, a, nil); len(r.Entries) != 6 || r.Entries[0].Change != DiffAdded {
+		t.Errorf("Diff(nil, a) = %+v", r)
+	}
+}
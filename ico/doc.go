@@ -0,0 +1,24 @@
+// Package ico 读取、创建、编辑及导出 Windows 的 ico 图标。
+//
+//   - 载入：LoadIconFile、LoadIconReaderAt、OpenIconFile(按需载入)
+//   - 创建：CreateWinIcon、CreateWinIconWithOptions、NewWriter、Merge
+//   - 编辑：AddEntry、RemoveEntry、ReplaceEntry、Move、Sort、ConvertEntries、Optimize
+//   - 查看：Entries、Image、Best、ContactSheet、Diff、Validate
+//   - 导出：Write、WriteFile、WritePNG、WriteBMP、ExtractIconToFile
+//
+// PNG 条目的头信息及一致性检查使用同一模块中的 png 包。
+// 导出的名字保持兼容；被替代的名字标记为 Deprecated 并继续可用。
+//
+// Package ico reads, creates, edits and exports Windows icons.
+//
+//   - Loading: LoadIconFile, LoadIconReaderAt, OpenIconFile (on demand).
+//   - Creating: CreateWinIcon, CreateWinIconWithOptions, NewWriter, Merge.
+//   - Editing: AddEntry, RemoveEntry, ReplaceEntry, Move, Sort,
+//     ConvertEntries, Optimize.
+//   - Inspecting: Entries, Image, Best, ContactSheet, Diff, Validate.
+//   - Exporting: Write, WriteFile, WritePNG, WriteBMP, ExtractIconToFile.
+//
+// The headers of PNG entries are read and checked with the png package
+// of the same module. Exported names stay compatible; replaced names
+// are marked Deprecated and keep working.
+package ico
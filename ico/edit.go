@@ -0,0 +1,86 @@
+// 编辑已载入的ico图标(添加、删除、替换及移动图标条目)
+
+package ico
+
+import "image"
+
+// AddEntry 添加一个图标条目到末尾
+// 宽或高达到256时使用PNG存储，否则使用32位DIB
+// AddEntry appends a new entry built from img. Images of 256
+// pixels or more are stored as PNG, smaller ones as 32-bit DIB.
+func (wi *WinIcon) AddEntry(img image.Image) error {
+	wis, e := imageToIcon(img, false)
+	if e != nil {
+		return e
+	}
+	wi.icos = append(wi.icos, wis)
+	wi.updateHeader()
+	return nil
+}
+
+// RemoveEntry 删除指定索引的图标条目，不能删除最后一个图标
+// RemoveEntry removes the entry at index i.
+// The last remaining entry cannot be removed.
+func (wi *WinIcon) RemoveEntry(i int) error {
+	if i < 0 || i >= len(wi.icos) {
+		return ErrIconsIndex
+	}
+	if len(wi.icos) == 1 {
+		return ErrIconsEmpty
+	}
+	wi.icos = append(wi.icos[:i], wi.icos[i+1:]...)
+	wi.updateHeader()
+	return nil
+}
+
+// ReplaceEntry 使用图像替换指定索引的图标条目
+// 原条目为PNG时，仍然使用PNG存储
+// ReplaceEntry replaces the entry at index i with img.
+// An entry that was stored as PNG stays PNG.
+func (wi *WinIcon) ReplaceEntry(i int, img image.Image) error {
+	if i < 0 || i >= len(wi.icos) {
+		return ErrIconsIndex
+	}
+	wis, e := imageToIcon(img, GetIconType(wi.icos[i].probe().data) == typePNG)
+	if e != nil {
+		return e
+	}
+	wi.icos[i] = wis
+	wi.updateHeader()
+	return nil
+}
+
+// Move 将索引i的图标条目移动到索引j
+// Move moves the entry at index i to index j
+func (wi *WinIcon) Move(i, j int) error {
+	n := len(wi.icos)
+	if i < 0 || i >= n || j < 0 || j >= n {
+		return ErrIconsIndex
+	}
+	wis := wi.icos[i]
+	if i < j {
+		copy(wi.icos[i:j], wi.icos[i+1:j+1])
+	} else {
+		copy(wi.icos[j+1:i+1], wi.icos[j:i])
+	}
+	wi.icos[j] = wis
+	wi.updateHeader()
+	return nil
+}
+
+// updateHeader 更新文件头中的图标数量并重新计算数据偏移量
+// Update the image count of the file header and recompute the data offsets
+func (wi *WinIcon) updateHeader() {
+	if wi.fileHeader == nil {
+		wi.fileHeader = &winIconFileHeader{FileType: 1}
+	}
+	wi.fileHeader.ImageCount = uint16(len(wi.icos))
+	for i := range wi.icos {
+		// 未载入的条目保留目录中的长度
+		// Unloaded entries keep the length of the directory
+		if wi.icos[i].loaded() {
+			wi.icos[i].setIconLength(len(wi.icos[i].data))
+		}
+	}
+	wi.generateOffset()
+}
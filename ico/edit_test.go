@@ -0,0 +1,95 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newTestImage 创建一个带透明像素的测试图像
+func newTestImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0x80, uint8(x + y)})
+		}
+	}
+	return img
+}
+
+// reloadIcon 将图标写入临时文件后重新载入
+func reloadIcon(t *testing.T, wi *WinIcon) *WinIcon {
+	t.Helper()
+	f, err := ioutil.TempFile("", "winicon*.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := wi.Write(f); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadIconFile(f)
+	if err != nil {
+		t.Fatalf("LoadIconFile() = %v", err)
+	}
+	return r
+}
+
+func TestWinIcon_Edit(t *testing.T) {
+	wi := loadTestIcon(t, "ICON16_1.ico")
+	if err := wi.AddEntry(newTestImage(128, 128)); err != nil {
+		t.Fatalf("AddEntry() = %v", err)
+	}
+	if err := wi.Move(8, 0); err != nil {
+		t.Fatalf("Move() = %v", err)
+	}
+	if err := wi.RemoveEntry(8); err != nil {
+		t.Fatalf("RemoveEntry() = %v", err)
+	}
+	if err := wi.ReplaceEntry(1, newTestImage(256, 256)); err != nil {
+		t.Fatalf("ReplaceEntry() = %v", err)
+	}
+	es := reloadIcon(t, wi).Entries()
+	if len(es) != 8 {
+		t.Fatalf("Entries() len = %d, want 8", len(es))
+	}
+	want := []struct {
+		w      int
+		format string
+	}{
+		{128, FormatDIB}, {256, FormatPNG}, {64, FormatDIB}, {48, FormatDIB},
+		{40, FormatDIB}, {32, FormatDIB}, {24, FormatDIB}, {20, FormatDIB},
+	}
+	offset := fileHeaderSize + headerSize*len(es)
+	for i, e := range es {
+		if e.Width != want[i].w || e.Format != want[i].format {
+			t.Errorf("entry %d = %dx%d %s, want %d %s", i, e.Width, e.Height, e.Format, want[i].w, want[i].format)
+		}
+		if e.Offset != offset {
+			t.Errorf("entry %d offset = %d, want %d", i, e.Offset, offset)
+		}
+		offset += e.Size
+	}
+	if !es[0].HasMask {
+		t.Errorf("added DIB entry has no AND mask")
+	}
+}
+
+func TestWinIcon_EditErrors(t *testing.T) {
+	wi := loadTestIcon(t, "favicon.ico")
+	if err := wi.RemoveEntry(0); err != ErrIconsEmpty {
+		t.Errorf("RemoveEntry(0) = %v, want %v", err, ErrIconsEmpty)
+	}
+	if err := wi.Move(0, 1); err != ErrIconsIndex {
+		t.Errorf("Move(0, 1) = %v, want %v", err, ErrIconsIndex)
+	}
+	if err := wi.ReplaceEntry(2, newTestImage(16, 16)); err != ErrIconsIndex {
+		t.Errorf("ReplaceEntry(2) = %v, want %v", err, ErrIconsIndex)
+	}
+}
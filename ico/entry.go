@@ -0,0 +1,169 @@
+// ico图标条目的元数据
+
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	pngtool "github.com/gemark/WinIconTools/png"
+)
+
+// 图标条目的存储格式
+// Storage format of an icon entry
+const (
+	FormatDIB = "dib" // 位图(DIB)数据
+	FormatPNG = "png" // PNG数据
+)
+
+// EntryInfo 图标条目的元数据
+// 目录中的值为0时，从图像数据本身读取
+// EntryInfo describes a single entry of the icon directory.
+// Values that are 0 in the directory are taken from the image data itself.
+type EntryInfo struct {
+	Index        int    `json:"index"`        // 条目的索引
+	Width        int    `json:"width"`        // 宽度(像素)
+	Height       int    `json:"height"`       // 高度(像素)
+	BitsPerPixel int    `json:"bitsPerPixel"` // 颜色位深度
+	PaletteSize  int    `json:"paletteSize"`  // 调色板颜色数，没有调色板为0
+	Format       string `json:"format"`       // "dib" 或 "png"
+	Offset       int    `json:"offset"`       // 图像数据在文件中的偏移量
+	Size         int    `json:"size"`         // 图像数据的大小
+	ColorType    int    `json:"pngColorType"` // PNG的颜色类型，DIB为-1
+	Compression  int    `json:"compression"`  // DIB的biCompression或PNG的压缩方法
+	HasMask      bool   `json:"hasMask"`      // 是否包含AND掩码
+}
+
+// Entries 获取所有图标条目的元数据
+// Entries returns the metadata of every entry in the icon directory
+func (wi *WinIcon) Entries() []EntryInfo {
+	es := make([]EntryInfo, len(wi.icos))
+	for i, v := range wi.icos {
+		// 未载入的条目只读取开头的少量数据
+		// Only the start of an unloaded entry is read
+		size := len(v.data)
+		if !v.loaded() {
+			size = v.getIconLength()
+		}
+		es[i] = v.probe().entryInfo(i, size)
+	}
+	return es
+}
+
+// entryInfo 根据目录结构和图像数据生成条目元数据，size 为图像数据的大小
+// Build the entry metadata from the directory structure and image data
+// of the given size
+func (wis winIconStruct) entryInfo(index, size int) EntryInfo {
+	e := EntryInfo{
+		Index:        index,
+		Width:        wis.getIconWidth(),
+		Height:       wis.getIconHeight(),
+		BitsPerPixel: wis.getIconBitsPerPixel(),
+		PaletteSize:  int(wis.Palette),
+		Offset:       wis.getIconOffset(),
+		Size:         size,
+		ColorType:    -1,
+	}
+	switch GetIconType(wis.data) {
+	case typePNG:
+		e.Format = FormatPNG
+		if hdr, err := pngtool.ParseHeader(wis.data); err == nil {
+			e.ColorType = hdr.GetColorType()
+			e.Compression = hdr.GetCompressionMethod()
+			if e.BitsPerPixel == 0 {
+				e.BitsPerPixel = hdr.BitsPerPixel()
+			}
+		}
+		if e.PaletteSize == 0 && e.ColorType == 3 {
+			e.PaletteSize = pngPaletteSize(wis.data)
+		}
+	case typeBMP:
+		e.Format = FormatDIB
+		di := getDIBInfo(wis.data)
+		e.Compression = di.compression
+		if e.BitsPerPixel == 0 {
+			e.BitsPerPixel = di.bits
+		}
+		if e.PaletteSize == 0 {
+			e.PaletteSize = di.colors
+		}
+		e.HasMask = di.hasMask(size)
+	}
+	return e
+}
+
+// dibInfo 从DIB头中读取的图像信息
+// Image information read from a DIB header
+type dibInfo struct {
+	headerSize  int // DIB头的大小
+	width       int // 宽度
+	height      int // 高度(ico中为XOR和AND两部分的高度之和)
+	bits        int // 颜色位深度
+	compression int // 压缩方式(BI_RGB为0)
+	colors      int // 调色板颜色数
+}
+
+// getDIBInfo 解析DIB头
+// Parse the DIB header of the icon data
+func getDIBInfo(d []byte) dibInfo {
+	if len(d) < dibHeaderSize {
+		return dibInfo{}
+	}
+	di := dibInfo{
+		headerSize:  int(binary.LittleEndian.Uint32(d[0:4])),
+		width:       int(int32(binary.LittleEndian.Uint32(d[4:8]))),
+		height:      int(int32(binary.LittleEndian.Uint32(d[8:12]))),
+		bits:        int(binary.LittleEndian.Uint16(d[14:16])),
+		compression: int(binary.LittleEndian.Uint32(d[16:20])),
+		colors:      int(binary.LittleEndian.Uint32(d[32:36])),
+	}
+	if di.colors == 0 && di.bits <= 8 {
+		di.colors = 1 << uint(di.bits)
+	}
+	return di
+}
+
+// xorStride XOR(颜色)数据每行的字节数，按4字节对齐
+// Bytes per row of the XOR (color) bitmap, 4-byte aligned
+func (di dibInfo) xorStride() int {
+	return (di.width*di.bits + 31) / 32 * 4
+}
+
+// andStride AND(掩码)数据每行的字节数，按4字节对齐
+// Bytes per row of the AND (mask) bitmap, 4-byte aligned
+func (di dibInfo) andStride() int {
+	return (di.width + 31) / 32 * 4
+}
+
+// paletteBytes 调色板的字节数
+// Size of the color table in bytes
+func (di dibInfo) paletteBytes() int {
+	return di.colors * 4
+}
+
+// hasMask 数据长度足够容纳AND掩码时返回true
+// Reports whether the data is long enough to hold the AND mask
+func (di dibInfo) hasMask(size int) bool {
+	if di.compression != 0 || di.width <= 0 {
+		return false
+	}
+	h := di.height / 2
+	xor := di.headerSize + di.paletteBytes() + di.xorStride()*h
+	return size >= xor+di.andStride()*h
+}
+
+// pngPaletteSize 获取PNG中PLTE块的颜色数
+// Number of colors in the PLTE chunk of a PNG
+func pngPaletteSize(d []byte) int {
+	for o := pngFileHeaderSize; o+8 <= len(d); {
+		l := int(binary.BigEndian.Uint32(d[o : o+4]))
+		if bytes.Equal(d[o+4:o+8], []byte("PLTE")) {
+			return l / 3
+		}
+		if bytes.Equal(d[o+4:o+8], []byte("IDAT")) {
+			break
+		}
+		o += l + 12
+	}
+	return 0
+}
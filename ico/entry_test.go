@@ -0,0 +1,56 @@
+package ico
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFile testico目录中测试文件的路径，与操作系统无关；name 为空时是目录本身
+func testFile(name string) string {
+	return filepath.Join("..", "testico", name)
+}
+
+// loadTestIcon 载入testico目录中的ico文件
+func loadTestIcon(t *testing.T, name string) *WinIcon {
+	t.Helper()
+	fs, err := os.Open(testFile(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	wi, err := LoadIconFile(fs)
+	if err != nil {
+		t.Fatalf("LoadIconFile(%s) = %v", name, err)
+	}
+	return wi
+}
+
+func TestWinIcon_Entries(t *testing.T) {
+	wi := loadTestIcon(t, "ICON16_1.ico")
+	es := wi.Entries()
+	if len(es) != 8 {
+		t.Fatalf("Entries() len = %d, want 8", len(es))
+	}
+	tests := []struct {
+		name string
+		got  EntryInfo
+		want EntryInfo
+	}{
+		{"PNG entry", es[0], EntryInfo{
+			Index: 0, Width: 256, Height: 256, BitsPerPixel: 32,
+			Format: FormatPNG, Offset: 134, Size: 9508, ColorType: 6,
+		}},
+		{"DIB entry", es[1], EntryInfo{
+			Index: 1, Width: 64, Height: 64, BitsPerPixel: 32,
+			Format: FormatDIB, Offset: 9642, Size: 16936, ColorType: -1, HasMask: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("Entries() = %+v, want %+v", tt.got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// 提取图标条目时转换图像格式
+
+package ico
+
+import (
+	"image"
+	"io"
+)
+
+// ExtractFormat 提取图标条目时写入的文件格式
+// ExtractFormat is the file format entries are extracted to
+type ExtractFormat int
+
+// 提取的文件格式
+// Extract formats
+const (
+	ExtractOriginal ExtractFormat = iota // 按存储格式(DIB为bmp，PNG为png) as stored, DIB to bmp and PNG to png
+	ExtractPNG                           // 全部转换为png all entries as png
+	ExtractBMP                           // 全部转换为bmp all entries as bmp
+)
+
+// ExtractOptions 提取图标条目时的选项
+// Options used when extracting entries
+type ExtractOptions struct {
+	Format ExtractFormat // 文件格式 file format
+	BMP    *BMPOptions   // 写入bmp文件时的选项 options for bmp files
+
+	// NameTemplate 文件名模板，可用的占位符：
+	// {prefix} 前缀，{name} 源文件名，{index} 索引，{w} 宽，{h} 高，
+	// {bpp} 位深度，{type} 存储格式(dib或png)，{ext} 扩展名，
+	// {dup} 重名时的计数器。为空时使用默认模板。
+	// NameTemplate is the file name template. Placeholders are
+	// {prefix}, {name} (source file name), {index}, {w}, {h}, {bpp},
+	// {type} (dib or png storage), {ext} and {dup}, a counter that
+	// avoids name collisions. Empty selects the default template.
+	NameTemplate string
+	Name         string // {name} 的值，为空时使用载入的文件名 value of {name}, the loaded file name by default
+	Overwrite    bool   // 覆盖已存在的文件，条目之间重名仍然是错误 replace existing files; entries sharing a name still fail
+}
+
+// ExtractIconToFileWithOptions 与 ExtractIconToFile 相同，但可以指定选项
+// opt 为 nil 时按存储格式及默认文件名提取，不覆盖已存在的文件
+// Same as ExtractIconToFile with the given options. A nil opt
+// extracts every entry in its stored format with the default
+// names and never replaces existing files.
+func (wi *WinIcon) ExtractIconToFileWithOptions(filePrefix, filePath string, opt *ExtractOptions) error {
+	if opt == nil {
+		opt = new(ExtractOptions)
+	}
+	tmpl := opt.NameTemplate
+	if tmpl == "" {
+		tmpl = DefaultNameTemplate
+		if filePrefix == "" {
+			tmpl = DefaultNoPrefixTemplate
+		}
+	}
+	name := opt.Name
+	if name == "" {
+		name = wi.name
+	}
+	used := make(map[string]bool)
+	for i := range wi.icos {
+		if e := wi.load(i); e != nil {
+			return e
+		}
+		v := wi.icos[i]
+		ext := opt.Format.ext(v)
+		var (
+			d []byte
+			e error
+		)
+		if ext == "png" {
+			d, e = v.encodePNG()
+		} else {
+			d, e = v.encodeBMP(opt.BMP)
+		}
+		if e != nil {
+			return e
+		}
+		f := nameFields{
+			prefix: filePrefix,
+			name:   name,
+			index:  i,
+			width:  v.getIconWidth(),
+			height: v.getIconHeight(),
+			bits:   v.getIconBitsPerPixel(),
+			format: FormatDIB,
+			ext:    ext,
+		}
+		if GetIconType(v.data) == typePNG {
+			f.format = FormatPNG
+		}
+		if _, e := createNamedFile(filePath, tmpl, f, d, opt.Overwrite, used); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ext 图标条目提取后的文件扩展名
+// File extension of an extracted entry
+func (f ExtractFormat) ext(wis winIconStruct) string {
+	switch f {
+	case ExtractPNG:
+		return "png"
+	case ExtractBMP:
+		return "bmp"
+	}
+	if GetIconType(wis.data) == typePNG {
+		return "png"
+	}
+	return "bmp"
+}
+
+// Image 将指定的图标条目解码为图像
+// DIB条目的AND掩码转换为透明像素
+// Image decodes the entry at index. The AND mask of
+// DIB entries becomes transparent pixels.
+func (wi *WinIcon) Image(index int) (image.Image, error) {
+	if index < 0 || index >= len(wi.icos) {
+		return nil, ErrIconsIndex
+	}
+	if e := wi.load(index); e != nil {
+		return nil, e
+	}
+	return wi.icos[index].decodeIcon()
+}
+
+// ImageSize 返回条目图像数据中记录的像素尺寸(PNG的IHDR或DIB头)，
+// 不解码图像，可以在 Image 之前限制解码的大小。
+// 目录中的宽高可能与图像数据不一致
+// ImageSize returns the pixel size recorded in the image data of the
+// entry at index, the IHDR of a PNG or the DIB header, without
+// decoding it, so that callers can bound the size before Image.
+// The directory may disagree with the image data.
+func (wi *WinIcon) ImageSize(index int) (w, h int, err error) {
+	if index < 0 || index >= len(wi.icos) {
+		return 0, 0, ErrIconsIndex
+	}
+	if e := wi.load(index); e != nil {
+		return 0, 0, e
+	}
+	w, h = wi.icos[index].imageSize()
+	if w <= 0 || h <= 0 {
+		return 0, 0, ErrIcoInvalid
+	}
+	return w, h, nil
+}
+
+// WritePNG 将指定的图标条目以PNG格式写入 io.Writer
+// PNG条目原样写入，DIB条目转换为带alpha通道的PNG
+// WritePNG writes the entry at index to w as PNG. PNG entries are
+// written as stored, DIB entries are converted with their alpha.
+func (wi *WinIcon) WritePNG(w io.Writer, index int) error {
+	if index < 0 || index >= len(wi.icos) {
+		return ErrIconsIndex
+	}
+	if e := wi.load(index); e != nil {
+		return e
+	}
+	d, e := wi.icos[index].encodePNG()
+	if e != nil {
+		return e
+	}
+	_, e = w.Write(d)
+	return e
+}
+
+// encodePNG 将图标条目编码为PNG数据
+// Encode the entry as PNG data
+func (wis winIconStruct) encodePNG() ([]byte, error) {
+	if GetIconType(wis.data) == typePNG {
+		return wis.data, nil
+	}
+	img, e := wis.decodeIcon()
+	if e != nil {
+		return nil, e
+	}
+	return encodePNG(img)
+}
@@ -0,0 +1,120 @@
+package ico
+
+import (
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func TestWinIcon_ExtractIconToFileWithOptions(t *testing.T) {
+	wi := loadTestIcon(t, "icon.ico")
+	tests := []struct {
+		name   string
+		format ExtractFormat
+		want   []string
+	}{
+		{"PNG", ExtractPNG, []string{
+			"t_icon16x16@32bit.png", "t_icon24x24@32bit.png", "t_icon256x256@32bit.png",
+			"t_icon32x32@32bit.png", "t_icon48x48@32bit.png", "t_icon64x64@32bit.png",
+		}},
+		{"BMP", ExtractBMP, []string{
+			"t_icon16x16@32bit.bmp", "t_icon24x24@32bit.bmp", "t_icon256x256@32bit.bmp",
+			"t_icon32x32@32bit.bmp", "t_icon48x48@32bit.bmp", "t_icon64x64@32bit.bmp",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "winicon")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := wi.ExtractIconToFileWithOptions("t", dir, &ExtractOptions{Format: tt.format}); err != nil {
+				t.Fatalf("ExtractIconToFileWithOptions() = %v", err)
+			}
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, fi := range fis {
+				got = append(got, fi.Name())
+				f, err := os.Open(filepath.Join(dir, fi.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.format == ExtractPNG {
+					_, err = png.Decode(f)
+				} else {
+					_, err = bmp.Decode(f)
+				}
+				f.Close()
+				if err != nil {
+					t.Errorf("%s: %v", fi.Name(), err)
+				}
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("extracted %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("extracted %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestWinIcon_Image(t *testing.T) {
+	wi := loadTestIcon(t, "icon.ico")
+	img, err := wi.Image(5)
+	if err != nil {
+		t.Fatalf("Image() = %v", err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 16 {
+		t.Errorf("Image() bounds = %v", img.Bounds())
+	}
+	if _, err := wi.Image(6); err != ErrIconsIndex {
+		t.Errorf("Image(6) = %v, want %v", err, ErrIconsIndex)
+	}
+}
+
+func TestWinIcon_ImageSize(t *testing.T) {
+	// 测试图标中有PNG和DIB条目
+	for _, n := range []string{"icon.ico", "favicon.ico"} {
+		wi := loadTestIcon(t, n)
+		for _, e := range wi.Entries() {
+			img, err := wi.Image(e.Index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, h, err := wi.ImageSize(e.Index)
+			if err != nil || w != img.Bounds().Dx() || h != img.Bounds().Dy() {
+				t.Errorf("%s: ImageSize(%d) = %d, %d, %v, image %v", n, e.Index, w, h, err, img.Bounds())
+			}
+		}
+	}
+	// 自上而下的DIB的高度为负数
+	d := encodeDIB(image.NewNRGBA(image.Rect(0, 0, 3, 5)))
+	binary.LittleEndian.PutUint32(d[8:12], uint32(0xfffffff6)) // -10
+	wi := &WinIcon{icos: WinIconStruct{{Width: 3, Height: 5, BitsPerPixel: 32, data: d}}}
+	if w, h, err := wi.ImageSize(0); err != nil || w != 3 || h != 5 {
+		t.Errorf("ImageSize(top-down) = %d, %d, %v", w, h, err)
+	}
+	if _, _, err := wi.ImageSize(1); err != ErrIconsIndex {
+		t.Errorf("ImageSize(1) = %v, want %v", err, ErrIconsIndex)
+	}
+	binary.LittleEndian.PutUint32(d[4:8], 0)
+	if _, _, err := wi.ImageSize(0); err != ErrIcoInvalid {
+		t.Errorf("ImageSize(zero width) = %v, want %v", err, ErrIcoInvalid)
+	}
+}
@@ -16,7 +16,6 @@
 package ico
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
@@ -27,7 +26,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"strings"
+
+	pngtool "github.com/gemark/WinIconTools/png"
 )
 
 // 定义常量
@@ -47,15 +48,23 @@ const (
 // Variable definitions
 var (
 	// 错误信息
-	ErrIcoInvalid  = errors.New("ico: Invalid icon file")                   // 无效的ico文件
-	ErrIcoReaders  = errors.New("ico: Reader type is not os.File pointer")  // LoadIconFile的io.Reader参数不是文件指针
-	ErrIcoFileType = errors.New("ico: Reader is directory, not file")       // io.Reader的文件指针是目录，不是文件
-	ErrIconsIndex  = errors.New("ico: Slice out of bounds")                 // 读取ico文件时，可能出现的切片越界错误
-	PNGHEADER      = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A} // PNG 文件头
-	DIBHEADER      = []byte{0x28, 0, 0, 0}                                  // DIB 头
-	BMPHEADERID    = []byte{0x42, 0x4d}
+	ErrIcoInvalid   = errors.New("ico: Invalid icon file")                  // 无效的ico文件
+	ErrIcoReaders   = errors.New("ico: Reader type is not os.File pointer") // io.Reader参数不是文件指针
+	ErrIcoFileType  = errors.New("ico: Reader is directory, not file")      // io.Reader的文件指针是目录，不是文件
+	ErrIconsIndex   = errors.New("ico: Slice out of bounds")                // 读取ico文件时，可能出现的切片越界错误
+	ErrIconsEmpty   = errors.New("ico: Icon must have an image")            // 删除最后一个图标时的错误
+	ErrIcoOversize  = errors.New("ico: Image larger than 256 pixels")       // 创建ico时图像超过256像素
+	ErrWriterClosed = errors.New("ico: Writer is closed")                   // 写入已关闭的 Writer
+	ErrIcoTooLarge  = errors.New("ico: Image data larger than 4 GiB")       // 图像数据超出ico文件中32位偏移量的范围
+	DIBHEADER       = []byte{0x28, 0, 0, 0}                                 // DIB 头
+	BMPHEADERID     = []byte{0x42, 0x4d}
 )
 
+// PNGHEADER PNG 文件头，png.PNGHEAD 的副本
+//
+// Deprecated: 使用 png.PNGHEAD。Use png.PNGHEAD.
+var PNGHEADER = append([]byte(nil), pngtool.PNGHEAD...)
+
 // 类型定义 type definition
 // 定义icon图标数据的类型
 // Define the type of icon data
@@ -70,6 +79,8 @@ type (
 type WinIcon struct {
 	fileHeader *winIconFileHeader // 文件头
 	icos       WinIconStruct      // icon 头结构
+	name       string             // 载入的文件名(不含扩展名)
+	closer     io.Closer          // OpenIconFile 打开的数据源
 }
 
 // ico文件头结构
@@ -94,6 +105,8 @@ type winIconStruct struct {
 	ImageDataSize uint32      // 图像数据的大小，单位字节
 	ImageOffset   uint32      // 图像数据的偏移量
 	data          WinIconData // 该图标的图像数据
+	src           io.ReaderAt // 延迟载入的数据源，已载入为nil
+	srcOffset     int64       // 图像数据在数据源中的偏移量
 }
 
 // bitmap 的 DIB 头结构
@@ -127,14 +140,18 @@ type bitmapHeader struct {
 }
 
 // createBitmapHeader 创建位图文件头结构
-// Create a bitmap file header structure
-func createBitmapHeader(datasize int) *bitmapHeader {
+// datasize 为DIB头、调色板及像素数据的总大小
+// offset 为像素数据相对于DIB头的偏移量(DIB头及调色板的大小)
+// Create a bitmap file header structure. datasize is the size of
+// the DIB header, color table and pixels; offset is the size of
+// the DIB header and color table in front of the pixels.
+func createBitmapHeader(datasize, offset int) *bitmapHeader {
 	return &bitmapHeader{
 		bitmapID:         binary.LittleEndian.Uint16([]byte{0x42, 0x4d}),
 		fileSize:         uint32(datasize + bitmapHeaderSize),
 		unusedA:          0,
 		unusedB:          0,
-		bitmapDataOffset: uint32(bitmapHeaderSize + dibHeaderSize),
+		bitmapDataOffset: uint32(bitmapHeaderSize + offset),
 	}
 }
 
@@ -154,10 +171,10 @@ func GetIconType(d []byte) ICONTYPE {
 // checkPNGHeader 检测是否是png ico数据
 // Check if it is png ico data
 func checkPNGHeader(d []byte) bool {
-	if len(d) < len(PNGHEADER) {
+	if len(d) < len(pngtool.PNGHEAD) {
 		return false
 	}
-	if bytes.Compare(d[0:8], PNGHEADER) != 0 {
+	if bytes.Compare(d[0:8], pngtool.PNGHEAD) != 0 {
 		return false
 	}
 	return true
@@ -206,47 +223,45 @@ func getPerm() os.FileMode {
 }
 
 // 将ico文件的数据载入到内存
+// rd 为文件时从当前位置读到结尾，图标的名字取自文件名；
+// 其他 io.Reader 读取到 EOF，名字为空
 // Load data from ico file into memory
-// rd io.Reader must *os.File object pointer.
+// A file is read from its current position to its end and names the
+// icon; any other io.Reader is read to EOF and leaves the name empty.
 // Successfully return WinIcon pointer.
 // Failed to return error object
 func LoadIconFile(rd io.Reader) (icon *WinIcon, err error) {
-	// 类型断言
-	v, t := rd.(*os.File)
-	if !t {
-		return nil, ErrIcoReaders
-	}
 	// 声明与定义变量
 	var (
-		fileSize int64
-		ico      *WinIcon
+		data []byte
+		name string
+		ico  *WinIcon
 	)
 
-	// 获取文件信息及判断是否是文件，而不是目录
-	fi, err := v.Stat()
-	if err != nil {
+	// 类型断言
+	if v, t := rd.(*os.File); t {
+		// 获取文件信息及判断是否是文件，而不是目录
+		fi, err := v.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return nil, ErrIcoFileType
+		}
+		// 一次读取ico文件的所有数据
+		if data, err = getFileAll(v, remainingSize(v, fi.Size())); err != nil {
+			return nil, err
+		}
+		name = strings.TrimSuffix(filepath.Base(v.Name()), filepath.Ext(v.Name()))
+	} else if data, err = ioutil.ReadAll(rd); err != nil {
 		return nil, err
 	}
-	if fi.IsDir() {
-		return nil, ErrIcoFileType
-	}
-	fileSize = fi.Size()
-
-	// 创建缓冲IO的Reader对象窥视6个字节的文件头
-	reader := bufio.NewReader(rd)
-	p, err := reader.Peek(fileHeaderSize)
-	if err != nil {
-		return nil, err
+	if len(data) < fileHeaderSize {
+		return nil, ErrIcoInvalid
 	}
 
 	// 检测文件头及获取头结构
-	icoHeader, err := getIconFileHeader(p)
-	if err != nil {
-		return nil, err
-	}
-
-	// 获取ico文件的所有数据
-	data, err := getFileAll(reader, fileSize)
+	icoHeader, err := getIconFileHeader(data[:fileHeaderSize])
 	if err != nil {
 		return nil, err
 	}
@@ -255,8 +270,14 @@ func LoadIconFile(rd io.Reader) (icon *WinIcon, err error) {
 	icos := make(WinIconStruct, int(icoHeader.ImageCount))
 	// 根据文件头中表示的icon图标文件的数量进行循环
 	structOffset := fileHeaderSize
+	if structOffset+int(icoHeader.ImageCount)*headerSize > len(data) {
+		return nil, ErrIcoInvalid
+	}
 	for i := 0; i < int(icoHeader.ImageCount); i++ {
 		wis := getIconStruct(data, structOffset, headerSize)
+		if wis.getIconOffset()+wis.getIconLength() > len(data) {
+			return nil, ErrIcoInvalid
+		}
 		icodata := wis.getImageData(data, wis.getIconOffset(), wis.getIconLength())
 		structOffset += headerSize
 		icos[i] = *wis
@@ -267,29 +288,37 @@ func LoadIconFile(rd io.Reader) (icon *WinIcon, err error) {
 	ico = &WinIcon{
 		fileHeader: icoHeader,
 		icos:       icos,
+		name:       name,
 	}
 	return ico, nil
 }
 
-// getFileAll 获取ico文件所有数据(不包括文件头的6个字节)
-// rd *bufio.Reader: 对象
-// size int64: 文件大小（我们需要读取的总数量）
+// getFileAll 一次读取ico或其他图像文件的所有数据
+// rd io.Reader: 对象
+// size int64: 需要读取的总数量
 // fb []byte: 文件的所有数据，如果成功读取的话
-// err error: 如果读取出现错误，返回错误
-// Get all data of ico or other image file(exclude first 6bytes of file)
-// first 6 bytes its ico file header.
-func getFileAll(rd *bufio.Reader, size int64) (fb []byte, err error) {
+// err error: 数据不足 size 时返回 io.ErrUnexpectedEOF
+// Read all data of ico or other image file in one go.
+func getFileAll(rd io.Reader, size int64) (fb []byte, err error) {
 	data := make([]byte, size)
-	for i := int64(0); i < size; i++ {
-		b, err := rd.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		data[i] = b
+	if _, err := io.ReadFull(rd, data); err != nil {
+		return nil, err
 	}
 	return data, nil
 }
 
+// remainingSize 文件从当前读取位置到结尾的大小
+// 无法获取读取位置时返回整个文件的大小
+// Size of the file from the current read position to its end,
+// or the whole size when the position is unknown
+func remainingSize(f *os.File, size int64) int64 {
+	p, e := f.Seek(0, io.SeekCurrent)
+	if e != nil || p > size {
+		return size
+	}
+	return size - p
+}
+
 // getIconFileHeader 获取文件头结构
 // b []byte: 读取的数据来自这个字节切片
 // wih *winIconFileHeader: 如果获取成功返回 winIconFileHeader对象指针
@@ -319,13 +348,7 @@ func getIconFileHeader(b []byte) (wih *winIconFileHeader, err error) {
 // length int: 数据长度
 // Get icon image structure according to offset, length arguments
 func getIconStruct(b []byte, o, l int) (wis *winIconStruct) {
-	var s []byte
-	s = make([]byte, headerSize)
-	j := 0
-	for i := o; i < o+l; i++ {
-		s[j] = b[i]
-		j++
-	}
+	s := b[o : o+l]
 	is := &winIconStruct{
 		Width:         s[0],
 		Height:        s[1],
@@ -351,15 +374,13 @@ func (wi *WinIcon) getImageData(index int) []byte {
 // data []byte: 图像数据的字节切片
 // offset int: 图像数据的偏移量
 // length int: 图像数据的长度
-// return []byte: 返回获取的数据字节切片
-// Get icon image data according to offset, length arguments
+// return []byte: 返回获取的数据字节切片，与 b 共享内存
+// 容量限制为 s，append 不会覆盖后面的数据
+// Get icon image data according to offset, length arguments.
+// The result shares memory with b; its capacity is capped at s
+// so that an append never overwrites the data that follows.
 func (wis winIconStruct) getImageData(b []byte, o, s int) []byte {
-	var d = make([]byte, s)
-	for i, j := o, 0; i < o+s; i++ {
-		d[j] = b[i]
-		j++
-	}
-	return d
+	return b[o : o+s : o+s]
 }
 
 // ExtractIconToFile 提取 ico 数据到文件
@@ -374,28 +395,9 @@ func (wis winIconStruct) getImageData(b []byte, o, s int) []byte {
 // This function does not detect the validity of the path.
 // the user controls it. if there is a problem with the
 // path, it will return an error object.
+// 已存在的文件会被覆盖 Existing files are overwritten.
 func (wi *WinIcon) ExtractIconToFile(filePrefix, filePath string) error {
-	var ext string
-	for i, v := range wi.icos {
-		w := v.getIconWidth()
-		h := v.getIconHeight()
-		b := v.getIconBitsPerPixel()
-		d, _ := wi.GetImageData(i)
-		if GetIconType(d) == typeBMP {
-			ext = "bmp"
-		} else {
-			ext = "png"
-			if w == 0 && h == 0 {
-				w = 256
-				h = 256
-			}
-		}
-		fn := v.generateFileNameFormat(filePrefix, ext, w, h, b)
-		if err := wi.IconToFile(filePath, fn, i); err != nil {
-			return err
-		}
-	}
-	return nil
+	return wi.ExtractIconToFileWithOptions(filePrefix, filePath, &ExtractOptions{Overwrite: true})
 }
 
 // GetImageData 获取ico图标的图像数据
@@ -407,6 +409,9 @@ func (wi *WinIcon) GetImageData(index int) (d []byte, err error) {
 	if index >= wi.getIconsHeaderCount() || index < 0 {
 		return nil, ErrIconsIndex
 	}
+	if e := wi.load(index); e != nil {
+		return nil, e
+	}
 	return wi.getImageData(index), nil
 }
 
@@ -421,25 +426,19 @@ func (wi *WinIcon) IconToFile(path, name string, index int) error {
 	if index >= wi.getIconsHeaderCount() || index < 0 {
 		return ErrIconsIndex
 	}
-	wis := wi.icos[index]
 	p := filepath.Join(path, name)
 	d, e := wi.GetImageData(index)
 	if e != nil {
 		return e
 	}
+	wis := wi.icos[index]
 	// 处理bitmap头结构
 	if GetIconType(d) == typeBMP {
-		w := wis.getIconWidth()
-		h := wis.getIconHeight()
-		b := wis.getIconBitsPerPixel()
-		s := len(d) - dibHeaderSize
-		dib := createDIBHeader(w, h, b, s, 0, 0)
-		err := dib.EditDIBHeader(d)
+		b, err := wis.encodeBMP(nil)
 		if err != nil {
 			return err
 		}
-		bmh := createBitmapHeader(len(d))
-		d = bmh.JoinHeader(d)
+		d = b
 	}
 	if e := wis.IconToFile(p, d); e != nil {
 		return e
@@ -458,6 +457,9 @@ func (wi *WinIcon) IconToIcoFile(path string, index int) error {
 	if index < 0 || index >= len(wi.icos) {
 		return ErrIconsIndex
 	}
+	if e := wi.load(index); e != nil {
+		return e
+	}
 	d := wi.icos[index].data
 	wis := wi.icos[index]
 	wis.ImageOffset = fileHeaderSize + headerSize
@@ -478,12 +480,6 @@ func (wi *WinIcon) getIconsHeaderCount() int {
 	return len(wi.icos)
 }
 
-// generateFileNameFormat 产生文件名
-// Generate a formatted file name (customPrefix_icon64x64@24bit.extname)
-func (wis winIconStruct) generateFileNameFormat(prefix, ext string, width, height, bit int) string {
-	return fmt.Sprintf("%s_icon%dx%d@%dbit.%s", prefix, width, height, bit, ext)
-}
-
 // iconToFile 将ico图像数据写入磁盘文件
 // Write ico image data to disk file
 func (wis winIconStruct) IconToFile(path string, data []byte) error {
@@ -502,8 +498,8 @@ func (wis winIconStruct) headerToBytes(both bool) []byte {
 		binary.LittleEndian.PutUint16(d[0:2], 0)
 		binary.LittleEndian.PutUint16(d[2:4], 1)
 		binary.LittleEndian.PutUint16(d[4:6], 1)
-		d[6] = uint8(wis.getIconWidth())
-		d[7] = uint8(wis.getIconHeight())
+		d[6] = wis.Width
+		d[7] = wis.Height
 		d[8] = wis.Palette
 		d[9] = wis.ReservedB
 		binary.LittleEndian.PutUint16(d[10:12], wis.ColorPlanes)
@@ -512,8 +508,8 @@ func (wis winIconStruct) headerToBytes(both bool) []byte {
 		binary.LittleEndian.PutUint32(d[18:22], wis.ImageOffset)
 	} else {
 		d = make([]byte, headerSize)
-		d[0] = uint8(wis.getIconWidth())
-		d[1] = uint8(wis.getIconHeight())
+		d[0] = wis.Width
+		d[1] = wis.Height
 		d[2] = wis.Palette
 		d[3] = wis.ReservedB
 		binary.LittleEndian.PutUint16(d[4:6], wis.ColorPlanes)
@@ -543,7 +539,7 @@ func (wis winIconStruct) getIconOffset() int {
 
 // setIconOffset 设置icon图像数据的偏移量
 // set offset of icon image structure
-func (wis winIconStruct) setIconOffset(o int) {
+func (wis *winIconStruct) setIconOffset(o int) {
 	wis.ImageOffset = uint32(o)
 }
 
@@ -556,47 +552,88 @@ func (wis winIconStruct) getIconLength() int {
 
 // setIconLength 设置icon图标数据的大小
 // set size of icon image data
-func (wis winIconStruct) setIconLength(l int) {
+func (wis *winIconStruct) setIconLength(l int) {
 	wis.ImageDataSize = uint32(l)
 }
 
 // getIconWidth 获取icon图像数据的宽度
-// return width of icon image
+// 目录中为0时(256及以上)，从图像数据中读取实际宽度
+// return width of icon image. When the directory holds 0
+// (256 or more), the real width is read from the image data.
 func (wis winIconStruct) getIconWidth() int {
 	if wis.Width == 0 {
+		if w, _ := wis.imageSize(); w > 0 {
+			return w
+		}
 		return 256
 	}
 	return int(wis.Width)
 }
 
-// setIconWidth 设置icon图标数据的高度
-// set width of icon image data
-func (wis winIconStruct) setIconWidth(w int) {
+// setIconWidth 设置icon图标数据的宽度，256及以上存储为0
+// set width of icon image data, 256 or more is stored as 0
+func (wis *winIconStruct) setIconWidth(w int) {
+	if w >= 256 {
+		w = 0
+	}
 	wis.Width = uint8(w)
 }
 
 // getIconHeight 获取icon图像数据的高度
-// return height of icon image
+// 目录中为0时(256及以上)，从图像数据中读取实际高度
+// return height of icon image. When the directory holds 0
+// (256 or more), the real height is read from the image data.
 func (wis winIconStruct) getIconHeight() int {
 	if wis.Height == 0 {
+		if _, h := wis.imageSize(); h > 0 {
+			return h
+		}
 		return 256
 	}
 	return int(wis.Height)
 }
 
-// setIconHeight 设置icon图标的高度
-// set height of icon image data
-func (wis winIconStruct) setIconHeight(h int) {
+// setIconHeight 设置icon图标的高度，256及以上存储为0
+// set height of icon image data, 256 or more is stored as 0
+func (wis *winIconStruct) setIconHeight(h int) {
+	if h >= 256 {
+		h = 0
+	}
 	wis.Height = uint8(h)
 }
 
+// imageSize 从PNG的IHDR或DIB头中读取图像的实际宽和高，
+// 未载入的条目读取其开头的数据，与载入后的结果相同
+// Read the real width and height from the PNG IHDR or the DIB header.
+// An unloaded entry reads the start of its payload, so the result
+// matches the loaded entry.
+func (wis winIconStruct) imageSize() (w, h int) {
+	d := wis.data
+	if !wis.loaded() {
+		d = wis.probe().data
+	}
+	switch GetIconType(d) {
+	case typePNG:
+		if hdr, e := pngtool.ParseHeader(d); e == nil {
+			w, h = hdr.GetWidth(), hdr.GetHeight()
+		}
+	case typeBMP:
+		di := getDIBInfo(d)
+		w, h = di.width, di.height/2
+		if h < 0 {
+			h = -h
+		}
+	}
+	return w, h
+}
+
 // getIconBitsPerPixel 获取icon图像数据的颜色位数
 // return image pixel color bits (8bit, 24bit, 32bit)
 func (wis winIconStruct) getIconBitsPerPixel() int {
 	return int(wis.BitsPerPixel)
 }
 
-func (wis winIconStruct) setIconBitsPerPixel(b int) {
+func (wis *winIconStruct) setIconBitsPerPixel(b int) {
 	wis.BitsPerPixel = uint16(b)
 }
 
@@ -670,39 +707,27 @@ func loadImageData(rd io.Reader) (data []byte, format ICONTYPE, err error) {
 	if f.IsDir() {
 		return nil, typeUKN, ErrIcoFileType
 	}
-	r := bufio.NewReader(rd)
-	p, err := r.Peek(pngFileHeaderSize)
-	if err != nil {
-		return nil, typeUKN, err
+	// 读取一次所有数据，再根据数据开头判断类型
+	size := remainingSize(v, f.Size())
+	d, e := getFileAll(v, size)
+	if e != nil {
+		return nil, typeUKN, e
 	}
-	if bytes.Compare(p[0:], PNGHEADER) == 0 {
-		d, e := getFileAll(r, f.Size())
-		if e != nil {
-			return nil, typeUKN, e
-		}
+	if len(d) >= pngFileHeaderSize && bytes.Equal(d[:pngFileHeaderSize], pngtool.PNGHEAD) {
 		return d, typePNG, nil
-	} else {
-		b, e := r.Peek(bitmapHeaderSize + dibHeaderSize)
-		if e != nil {
-			return nil, typeUKN, e
-		}
-		if bytes.Compare(b[0:2], BMPHEADERID) != 0 {
-			return nil, typeUKN, ErrIcoInvalid
-		}
-		bs := int64(binary.LittleEndian.Uint32(b[2:6]))
-		ds := int32(binary.LittleEndian.Uint32(b[14:18]))
-		if bs != f.Size() {
-			return nil, typeUKN, ErrIcoInvalid
-		}
-		if ds != dibHeaderSize {
-			return nil, typeUKN, ErrIcoInvalid
-		}
-		d, e := getFileAll(r, f.Size())
-		if e != nil {
-			return nil, typeUKN, e
-		}
-		return d, typeBMP, nil
 	}
+	if len(d) < bitmapHeaderSize+dibHeaderSize || !bytes.Equal(d[0:2], BMPHEADERID) {
+		return nil, typeUKN, ErrIcoInvalid
+	}
+	bs := int64(binary.LittleEndian.Uint32(d[2:6]))
+	ds := int32(binary.LittleEndian.Uint32(d[14:18]))
+	if bs != size {
+		return nil, typeUKN, ErrIcoInvalid
+	}
+	if ds != dibHeaderSize {
+		return nil, typeUKN, ErrIcoInvalid
+	}
+	return d, typeBMP, nil
 }
 
 // CreateWinIcon 可以将N个BMP和PNG图像打包为一
@@ -717,6 +742,24 @@ func loadImageData(rd io.Reader) (data []byte, format ICONTYPE, err error) {
 // a pointer to a WinIcon object that successfully returns.
 // Failed to return error object
 func CreateWinIcon(filePath []string) (*WinIcon, error) {
+	return CreateWinIconWithOptions(filePath, nil)
+}
+
+// CreateOptions 创建ico图标时的选项
+// Options used when creating an icon
+type CreateOptions struct {
+	Order    SortOrder      // 图标条目的顺序，默认最大的在前 entry order, largest first by default
+	Oversize OversizePolicy // 超过256像素的处理方式，默认拒绝 images over 256 pixels, rejected by default
+}
+
+// CreateWinIconWithOptions 与 CreateWinIcon 相同，但可以指定选项
+// opt 为 nil 时使用默认选项
+// Same as CreateWinIcon with the given options.
+// A nil opt uses the defaults.
+func CreateWinIconWithOptions(filePath []string, opt *CreateOptions) (*WinIcon, error) {
+	if opt == nil {
+		opt = new(CreateOptions)
+	}
 	var (
 		fs  []*os.File
 		err error
@@ -745,27 +788,41 @@ func CreateWinIcon(filePath []string) (*WinIcon, error) {
 		}
 		switch t {
 		case typeBMP:
+			if len(d) < bitmapHeaderSize+dibHeaderSize {
+				return nil, ErrIcoInvalid
+			}
+			data, e := bmpToIconData(d[bitmapHeaderSize:])
+			if e != nil {
+				return nil, fmt.Errorf("ico: %s: %v", filePath[i], e)
+			}
 			icos[i] = bmpToIcon(d)
-			icos[i].data = d[bitmapHeaderSize:]
+			icos[i].data = data
+			icos[i].setIconLength(len(icos[i].data))
 		case typePNG:
+			if e := checkPNG(d); e != nil {
+				return nil, fmt.Errorf("ico: %s: %v", filePath[i], e)
+			}
 			d := pngToIconPNG(d)
-			if d != nil {
-				icos[i] = pngToIcon(d)
-				icos[i].data = d
-			} else {
+			if d == nil {
 				return nil, ErrIcoInvalid
 			}
+			if icos[i], e = pngToIcon(d); e != nil {
+				return nil, e
+			}
+			icos[i].data = d
 		default:
 			return nil, ErrIcoInvalid
 		}
+		if e := icos[i].fitOversize(opt.Oversize); e != nil {
+			return nil, e
+		}
 	}
-	// 根据icon图标的width排个序（升序）
-	// Ascending of sort with icon image width
-	sort.Sort(icos)
+	// 根据选项对icon图标排序
+	// Sort the icon images according to the options
 	wi := &WinIcon{
 		icos: icos,
 	}
-	wi.generateOffset()
+	wi.Sort(opt.Order)
 	return wi, nil
 }
 
@@ -780,38 +837,62 @@ func (wi *WinIcon) generateOffset() {
 	}
 }
 
-// WriteIcoFile 将icon图标打包数据写入磁盘文件
-func (wi *WinIcon) WriteIcoFile(filePath, fileName string) {
-	var (
-		fs  *os.File
-		err error
-	)
-	fp := filepath.Join(filePath, fileName)
-	fs, err = os.OpenFile(fp, os.O_CREATE|os.O_RDWR, getPerm())
+// WriteFile 将icon图标打包数据写入磁盘文件 path
+// 打开(截断)文件前先读取所有条目，path 可以是 OpenIconFile 打开的文件
+// Write the icon to the file at path. Every entry is read before the
+// file is opened and truncated, so path may be the file the icon was
+// opened from with OpenIconFile.
+func (wi *WinIcon) WriteFile(path string) error {
+	if e := wi.loadAll(); e != nil {
+		return e
+	}
+	fs, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, getPerm())
 	if err != nil {
+		return err
+	}
+	if err := wi.Write(fs); err != nil {
+		fs.Close()
+		return err
+	}
+	return fs.Close()
+}
+
+// WriteIcoFile 将icon图标打包数据写入磁盘文件，出错时 panic
+//
+// Deprecated: 使用 WriteFile。Use WriteFile, which returns the error.
+func (wi *WinIcon) WriteIcoFile(filePath, fileName string) {
+	if err := wi.WriteFile(filepath.Join(filePath, fileName)); err != nil {
 		panic(err)
 	}
-	defer fs.Close()
+}
+
+// Write 将ico文件的数据(文件头、目录及图像数据)写入 io.Writer
+// 写入前根据图像数据重新计算偏移量
+// Write the ico file (header, directory and image data) to w.
+// The offsets are recomputed from the image data before writing.
+func (wi *WinIcon) Write(w io.Writer) error {
+	if e := wi.loadAll(); e != nil {
+		return e
+	}
+	wi.updateHeader()
 	ih := make([]byte, fileHeaderSize)
 	binary.LittleEndian.PutUint16(ih[0:2], 0)
 	binary.LittleEndian.PutUint16(ih[2:4], 1)
 	binary.LittleEndian.PutUint16(ih[4:6], uint16(wi.getIconsHeaderCount()))
-	if _, err := fs.Write(ih); err != nil {
-		panic(err)
+	if _, err := w.Write(ih); err != nil {
+		return err
 	}
 	for _, v := range wi.icos {
-		b := v.headerToBytes(false)
-		if _, err := fs.Write(b); err != nil {
-			panic(err)
+		if _, err := w.Write(v.headerToBytes(false)); err != nil {
+			return err
 		}
 	}
 	for _, v := range wi.icos {
-		w := binary.LittleEndian.Uint32(v.data[8:12])
-		binary.LittleEndian.PutUint32(v.data[8:12], w*2)
-		if _, err := fs.Write(v.data); err != nil {
-			panic(err)
+		if _, err := w.Write(v.data); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 // bmpToIcon bmp图像转换到 winIconStruct 对象
@@ -822,8 +903,6 @@ func (wi *WinIcon) WriteIcoFile(filePath, fileName string) {
 func bmpToIcon(b []byte) winIconStruct {
 	b = b[bitmapHeaderSize:]
 	wis := winIconStruct{
-		Width:         uint8(binary.LittleEndian.Uint32(b[4:8])),
-		Height:        uint8(binary.LittleEndian.Uint32(b[8:12])),
 		Palette:       uint8(0),
 		ReservedB:     uint8(0),
 		ColorPlanes:   uint16(1),
@@ -831,38 +910,81 @@ func bmpToIcon(b []byte) winIconStruct {
 		ImageDataSize: uint32(len(b)),
 		ImageOffset:   uint32(0),
 	}
+	h := int(int32(binary.LittleEndian.Uint32(b[8:12])))
+	if h < 0 {
+		h = -h
+	}
+	wis.setIconWidth(int(int32(binary.LittleEndian.Uint32(b[4:8]))))
+	wis.setIconHeight(h)
 	return wis
 }
 
 // pngToIcon png图像转换到 winIconStruct 对象
-// 目前仅支持非压缩的图像数据，和不含有索引色的图像数据
-// 没有调色版，暂时统一设置palette调色版为0，不使用它
-// PNG image converted to winIconStruct object
-// Currently only supports uncompressed image data,
-// and image data without index color has no color
-// palette, temporarily set the palette to 0, dont fix it.
-// this feature may be improved in the future.
-func pngToIcon(b []byte) winIconStruct {
+// 宽、高及每像素的位数由 png 包从IHDR中读取(检查CRC)，
+// 8位、灰度及索引色的PNG也能得到正确的位数。
+// 调色板的颜色数不写入目录，palette 统一设置为0
+// PNG image converted to winIconStruct object.
+// The width, height and bits per pixel come from IHDR through
+// the png package, CRC checked, so 8-bit, grayscale and paletted
+// PNGs get the right depth. The palette size is left 0.
+func pngToIcon(b []byte) (winIconStruct, error) {
+	hdr, e := pngtool.ParseHeader(b)
+	if e != nil {
+		return winIconStruct{}, e
+	}
 	wis := winIconStruct{
-		Width:         uint8(binary.BigEndian.Uint32(b[16:20])),
-		Height:        uint8(binary.BigEndian.Uint32(b[20:24])),
 		Palette:       uint8(0),
 		ReservedB:     uint8(0),
 		ColorPlanes:   uint16(1),
-		BitsPerPixel:  uint16(32), //b[24]
+		BitsPerPixel:  uint16(hdr.BitsPerPixel()),
 		ImageDataSize: uint32(len(b)),
 		ImageOffset:   uint32(0),
 	}
-	return wis
+	wis.setIconWidth(hdr.GetWidth())
+	wis.setIconHeight(hdr.GetHeight())
+	return wis, nil
+}
+
+// checkPNG 使用 png 包检查PNG数据的一致性，返回第一个错误级别的问题
+// Check the conformance of PNG data with the png package and
+// return the first problem of error severity
+func checkPNG(b []byte) error {
+	for _, p := range pngtool.Check(bytes.NewReader(b)) {
+		if p.Severity == pngtool.SeverityError {
+			return errors.New(p.String())
+		}
+	}
+	return nil
+}
+
+// repairPNG 重新计算CRC错误的块的CRC。checkPNG 之后只剩下辅助块的
+// CRC错误(警告)，标准库的解码器仍然会拒绝它们
+// Recompute the CRCs that do not match. After checkPNG only ancillary
+// chunks can be affected, which are warnings, but the standard library
+// decoder would still reject them.
+func repairPNG(b []byte) []byte {
+	img := pngtool.New()
+	opt := &pngtool.ParseOptions{CRC: pngtool.CRCRepair}
+	if e := img.LoadPNGFileOptions(bytes.NewReader(b), opt); e != nil || len(img.Warnings) == 0 {
+		return b
+	}
+	buf := new(bytes.Buffer)
+	if _, e := img.WriteTo(buf); e != nil {
+		return b
+	}
+	return buf.Bytes()
 }
 
 func pngToIconPNG(b []byte) []byte {
-	rd := bytes.NewReader(b)
-	i, _ := png.Decode(rd)
+	rd := bytes.NewReader(repairPNG(b))
+	i, e := png.Decode(rd)
+	if e != nil {
+		return nil
+	}
 	buf := new(bytes.Buffer)
 	enc := new(png.Encoder)
 	enc.CompressionLevel = png.BestCompression
-	e := enc.Encode(buf, i)
+	e = enc.Encode(buf, i)
 	if e != nil {
 		return nil
 	} else {
@@ -878,10 +1000,19 @@ func (w WinIconStruct) Len() int {
 }
 
 // Less 实现go语言的排序算法接口中Less方法
+// 按宽度、高度及位深度降序(最大的图标在前)
 // Implementing the go language sorting
-// algorithm interface in the Less method
+// algorithm interface in the Less method.
+// Orders by width, height and bit depth, largest first.
 func (w WinIconStruct) Less(i, j int) bool {
-	return w[j].getIconWidth() < w[i].getIconWidth()
+	a, b := w[i], w[j]
+	if a.getIconWidth() != b.getIconWidth() {
+		return b.getIconWidth() < a.getIconWidth()
+	}
+	if a.getIconHeight() != b.getIconHeight() {
+		return b.getIconHeight() < a.getIconHeight()
+	}
+	return b.getIconBitsPerPixel() < a.getIconBitsPerPixel()
 }
 
 // Swap 实现go语言的排序算法接口中的Swap方法
@@ -5,6 +5,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
@@ -14,7 +17,6 @@ import (
 	"runtime"
 	"strings"
 	"testing"
-	"time"
 )
 
 func TestLoadIconFile(t *testing.T) {
@@ -29,7 +31,7 @@ func TestLoadIconFile(t *testing.T) {
 			os.Exit(1)
 		}
 	}()
-	path := "../testico/"
+	path := testFile("")
 	file := "ICON16_1.ico"
 	filePath := filepath.Join(path, file)
 	fs, err := os.Open(filePath)
@@ -40,7 +42,12 @@ func TestLoadIconFile(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	if err := wi.ExtractIconToFile("test", "../testico/"); err != nil {
+	dir, err := ioutil.TempDir("", "ico")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := wi.ExtractIconToFile("test", dir); err != nil {
 		panic(err)
 	}
 }
@@ -58,7 +65,7 @@ func TestWinIcon_IconToIcoFile(t *testing.T) {
 			os.Exit(1)
 		}
 	}()
-	path := "../testico/"
+	path := testFile("")
 	file := "ICON16_1.ico"
 	filePath := filepath.Join(path, file)
 	fs, err := os.Open(filePath)
@@ -69,7 +76,12 @@ func TestWinIcon_IconToIcoFile(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	if err := wi.IconToIcoFile("../testico/vk.ico", 1); err != nil {
+	dir, err := ioutil.TempDir("", "ico")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := wi.IconToIcoFile(filepath.Join(dir, "vk.ico"), 1); err != nil {
 		panic(err)
 	}
 }
@@ -116,7 +128,7 @@ func Test_loadImageData(t *testing.T) {
 			os.Exit(1)
 		}
 	}()
-	path := "../testico/"
+	path := testFile("")
 	file := "vkico64x64@32bit.bmp"
 	filePath := filepath.Join(path, file)
 	fs, err := os.Open(filePath)
@@ -144,18 +156,23 @@ func TestCreateWinIcon(t *testing.T) {
 		{
 			"Test Create Win Icon",
 			args{[]string{
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico16x16@32bit.bmp",
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico20x20@32bit.bmp",
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico24x24@32bit.bmp",
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico32x32@32bit.bmp",
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico40x40@32bit.bmp",
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico64x64@32bit.bmp",
-				"D:\\go_project\\src\\WinIconTools\\testico\\vkico256x256@32bit.bmp",
+				testFile("vkico16x16@32bit.bmp"),
+				testFile("vkico20x20@32bit.bmp"),
+				testFile("vkico24x24@32bit.bmp"),
+				testFile("vkico32x32@32bit.bmp"),
+				testFile("vkico40x40@32bit.bmp"),
+				testFile("vkico64x64@32bit.bmp"),
+				testFile("vkico256x256@32bit.bmp"),
 			}},
 			nil,
 			false,
 		},
 	}
+	dir, err := ioutil.TempDir("", "ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got, err := CreateWinIcon(tt.args.filePath)
@@ -171,20 +188,121 @@ func TestCreateWinIcon(t *testing.T) {
 					got.icos[i].getIconLength(),
 				)
 			}
-			Time := func() string {
-				tb, _ := time.Now().MarshalText()
-				return fmt.Sprintf("%s%s",
-					strings.Join(strings.Split(string(tb[0:10]), "-"), ""),
-					strings.Join(strings.Split(string(tb[11:19]), ":"), ""),
-				)
-			}()
-			got.WriteIcoFile("../", Time+".ico")
+			if err := got.WriteFile(filepath.Join(dir, "created.ico")); err != nil {
+				t.Error(err)
+			}
 		})
 	}
 }
 
+func TestPNGToIcon(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 3, 2))
+	rgb := image.NewRGBA(image.Rect(0, 0, 300, 2))
+	for i := range rgb.Pix {
+		rgb.Pix[i] = 0xff
+	}
+	rgba := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	pal := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White, color.Gray{0x80}})
+	tests := []struct {
+		name string
+		img  image.Image
+		w, h int
+		bits int
+	}{
+		{"gray", gray, 3, 2, 8},
+		{"rgb", rgb, 256, 2, 24},
+		{"rgba", rgba, 5, 5, 32},
+		{"paletted", pal, 4, 4, 2},
+		{"gray16", image.NewGray16(image.Rect(0, 0, 1, 1)), 1, 1, 16},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := png.Encode(&b, tt.img); err != nil {
+			t.Fatal(err)
+		}
+		wis, err := pngToIcon(b.Bytes())
+		if err != nil {
+			t.Errorf("%s: pngToIcon() = %v", tt.name, err)
+			continue
+		}
+		if wis.getIconWidth() != tt.w || wis.getIconHeight() != tt.h || wis.getIconBitsPerPixel() != tt.bits {
+			t.Errorf("%s: %dx%d@%d, want %dx%d@%d", tt.name, wis.getIconWidth(), wis.getIconHeight(),
+				wis.getIconBitsPerPixel(), tt.w, tt.h, tt.bits)
+		}
+		// AddImage 存储为PNG的条目同样使用IHDR中的位数
+		n, err := imageToIcon(tt.img, true)
+		if err != nil || n.getIconBitsPerPixel() != tt.bits {
+			t.Errorf("%s: imageToIcon() = %d bits, %v", tt.name, n.getIconBitsPerPixel(), err)
+		}
+		// IHDR 的CRC错误
+		d := append([]byte{}, b.Bytes()...)
+		d[30] ^= 0xff
+		if _, err := pngToIcon(d); err == nil {
+			t.Errorf("%s: pngToIcon(bad crc) = nil error", tt.name)
+		}
+	}
+}
+
+func TestCreateWinIcon_PNG(t *testing.T) {
+	wi, err := CreateWinIcon([]string{testFile("vkico256x256@8bit.png"), testFile("vkico16x16@32bit.bmp")})
+	if err != nil {
+		t.Fatalf("CreateWinIcon() = %v", err)
+	}
+	f, err := os.Open(testFile("vkico256x256@8bit.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := wi.Entries()
+	if es[0].Width != 256 || es[0].Format != FormatPNG {
+		t.Fatalf("Entries()[0] = %+v", es[0])
+	}
+	// 重新编码后的PNG的位数与目录一致
+	hdrBits := es[0].BitsPerPixel
+	if _, ok := cfg.ColorModel.(color.Palette); ok && hdrBits > 8 {
+		t.Errorf("paletted png stored with %d bits", hdrBits)
+	}
+	wis, err := pngToIcon(wi.icos[0].data)
+	if err != nil || wis.getIconBitsPerPixel() != hdrBits {
+		t.Errorf("directory says %d bits, IHDR %d (%v)", hdrBits, wis.getIconBitsPerPixel(), err)
+	}
+	if err := wi.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	// IDAT 的CRC错误的PNG在打包前被拒绝
+	_, err = CreateWinIcon([]string{testFile("vkico128x128@32bit.png")})
+	if err == nil || !strings.Contains(err.Error(), "crc") {
+		t.Errorf("CreateWinIcon(corrupt png) = %v", err)
+	}
+
+	// 只有辅助块的CRC错误的PNG可以打包
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewNRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+	text := []byte{0, 0, 0, 3, 't', 'E', 'X', 't', 'a', 0, 'b', 0, 0, 0, 0}
+	d := append(append(append([]byte{}, b.Bytes()[:33]...), text...), b.Bytes()[33:]...)
+	dir, err := ioutil.TempDir("", "ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "text.png")
+	if err := ioutil.WriteFile(p, d, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateWinIcon([]string{p}); err != nil {
+		t.Errorf("CreateWinIcon(bad tEXt crc) = %v", err)
+	}
+}
+
 func TestCRC(t *testing.T) {
-	b, e := ioutil.ReadFile("../testico/vkico256x256@32bit.png")
+	b, e := ioutil.ReadFile(testFile("vkico256x256@32bit.png"))
 	if e != nil {
 		fmt.Println(e)
 		return
@@ -200,3 +318,120 @@ func TestCRC(t *testing.T) {
 	o := int(l) + i + 4
 	fmt.Printf("[%0x %0x %0x %0x]\r\n", b[o], b[o+1], b[o+2], b[o+3])
 }
+
+func TestLoadIconFile_Position(t *testing.T) {
+	b, err := ioutil.ReadFile(testFile("icon.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "winicon*.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	// 数据从文件的第4个字节开始
+	if _, err := f.Write(append([]byte("junk"), b...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	wi, err := LoadIconFile(f)
+	if err != nil {
+		t.Fatalf("LoadIconFile() = %v", err)
+	}
+	if err := wi.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	for i, v := range wi.icos {
+		if cap(v.data) != len(v.data) {
+			t.Errorf("entry %d cap = %d, len = %d", i, cap(v.data), len(v.data))
+		}
+	}
+	if err := f.Truncate(100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIconFile(f); err != ErrIcoInvalid {
+		t.Errorf("LoadIconFile(truncated) = %v, want ErrIcoInvalid", err)
+	}
+}
+
+func TestLoadIconFile_Reader(t *testing.T) {
+	b, err := ioutil.ReadFile(testFile("icon.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wi, err := LoadIconFile(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("LoadIconFile(reader) = %v", err)
+	}
+	var out bytes.Buffer
+	if err := wi.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Error("icon loaded from a reader differs from the file")
+	}
+	if wi.name != "" {
+		t.Errorf("name = %q", wi.name)
+	}
+	if _, err := LoadIconFile(bytes.NewReader(b[:100])); err != ErrIcoInvalid {
+		t.Errorf("LoadIconFile(truncated reader) = %v, want ErrIcoInvalid", err)
+	}
+}
+
+func BenchmarkLoadIconFile(b *testing.B) {
+	for _, n := range []string{"ICON16_1.ico", "favicon.ico", "icon.ico"} {
+		b.Run(n, func(b *testing.B) {
+			fs, err := os.Open(testFile(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer fs.Close()
+			fi, err := fs.Stat()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(fi.Size())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := fs.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := LoadIconFile(fs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoadImageData(b *testing.B) {
+	for _, n := range []string{"vkico256x256@32bit.bmp", "vkico256x256@32bit.png"} {
+		b.Run(n, func(b *testing.B) {
+			fs, err := os.Open(testFile(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer fs.Close()
+			fi, err := fs.Stat()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(fi.Size())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := fs.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := loadImageData(fs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
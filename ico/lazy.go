@@ -0,0 +1,192 @@
+// 延迟载入ico图标，只读取目录，图像数据在需要时读取
+
+package ico
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// entryProbeSize Entries 读取未载入条目开头的字节数
+// 足以包含PNG的IHDR及PLTE块头或DIB头
+// Bytes read from the start of an unloaded entry by Entries,
+// enough for the PNG IHDR and PLTE chunk header or the DIB header
+const entryProbeSize = 256
+
+// OpenOptions 打开ico文件的选项
+// Options of OpenIconFile
+type OpenOptions struct {
+	Mmap bool // 在Linux上使用内存映射，其他系统忽略 memory-map the file on Linux, ignored elsewhere
+}
+
+// LoadIconReaderAt 从 r 中只读取文件头和目录，
+// 图像数据在 GetImageData 等方法需要时才读取。
+// size 为数据的总大小，用于检查目录中的偏移量。
+// 使用期间 r 必须保持可读
+// LoadIconReaderAt reads only the header and the directory from r.
+// The payload of an entry is read on demand, by GetImageData and
+// the other methods that need it. size is the total size of the
+// data and bounds the directory offsets. r must stay readable for
+// as long as the icon is used.
+func LoadIconReaderAt(r io.ReaderAt, size int64) (*WinIcon, error) {
+	h := make([]byte, fileHeaderSize)
+	if e := readFull(r, h, 0); e != nil {
+		return nil, ErrIcoInvalid
+	}
+	icoHeader, e := getIconFileHeader(h)
+	if e != nil {
+		return nil, e
+	}
+	n := int(icoHeader.ImageCount)
+	dir := make([]byte, n*headerSize)
+	if e := readFull(r, dir, fileHeaderSize); e != nil {
+		return nil, ErrIcoInvalid
+	}
+	icos := make(WinIconStruct, n)
+	for i := range icos {
+		wis := getIconStruct(dir, i*headerSize, headerSize)
+		if int64(wis.getIconOffset())+int64(wis.getIconLength()) > size {
+			return nil, ErrIcoInvalid
+		}
+		wis.src = r
+		wis.srcOffset = int64(wis.getIconOffset())
+		icos[i] = *wis
+	}
+	return &WinIcon{fileHeader: icoHeader, icos: icos}, nil
+}
+
+// OpenIconFile 打开ico文件并延迟载入图像数据，见 LoadIconReaderAt
+// 使用完毕后需要调用 Close。opt 为 nil 时使用默认选项
+// OpenIconFile opens the file at path and loads its entries lazily,
+// see LoadIconReaderAt. The icon must be closed with Close.
+// A nil opt uses the defaults.
+func OpenIconFile(path string, opt *OpenOptions) (*WinIcon, error) {
+	if opt == nil {
+		opt = new(OpenOptions)
+	}
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	fi, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, ErrIcoFileType
+	}
+	var src readerAtCloser = f
+	if opt.Mmap && fi.Size() > 0 {
+		m, e := mmapFile(f, fi.Size())
+		f.Close()
+		if e != nil {
+			return nil, e
+		}
+		src = m
+	}
+	wi, e := LoadIconReaderAt(src, fi.Size())
+	if e != nil {
+		src.Close()
+		return nil, e
+	}
+	wi.closer = src
+	wi.name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return wi, nil
+}
+
+// readerAtCloser 延迟载入的数据源
+// Source of a lazily loaded icon
+type readerAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Close 关闭 OpenIconFile 打开的文件，其他情况下不做任何事
+// 关闭后未载入的条目无法再读取
+// Close releases the file opened by OpenIconFile and does nothing
+// otherwise. Entries that were not loaded cannot be read afterwards.
+func (wi *WinIcon) Close() error {
+	if wi.closer == nil {
+		return nil
+	}
+	c := wi.closer
+	wi.closer = nil
+	return c.Close()
+}
+
+// load 读取指定条目的图像数据(如果还未读取)
+// Read the payload of the entry at index unless it is loaded
+func (wi *WinIcon) load(index int) error {
+	return wi.icos[index].load()
+}
+
+// loadAll 读取所有条目的图像数据
+// Read the payload of every entry
+func (wi *WinIcon) loadAll() error {
+	for i := range wi.icos {
+		if e := wi.load(i); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// load 从数据源读取条目的图像数据
+// Read the payload of the entry from its source
+func (wis *winIconStruct) load() error {
+	if wis.src == nil {
+		return nil
+	}
+	d := make([]byte, wis.getIconLength())
+	if e := readFull(wis.src, d, wis.srcOffset); e != nil {
+		return e
+	}
+	wis.data, wis.src = d, nil
+	return nil
+}
+
+// readFull 从 off 读取 len(p) 字节。io.ReaderAt 读满 p 时也可能
+// 返回 io.EOF(数据恰好在末尾结束)，这种情况不是错误
+// Read len(p) bytes at off. An io.ReaderAt may return io.EOF along
+// with a full p when the data ends right there, which is no error.
+func readFull(r io.ReaderAt, p []byte, off int64) error {
+	n, e := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if e == nil || e == io.EOF {
+		e = io.ErrUnexpectedEOF
+	}
+	return e
+}
+
+// loaded 条目的图像数据是否已经读取
+// Reports whether the payload of the entry is in memory
+func (wis winIconStruct) loaded() bool {
+	return wis.src == nil
+}
+
+// probe 返回条目开头最多 entryProbeSize 字节的副本，用于读取元数据
+// Copy of the entry holding at most entryProbeSize bytes of its
+// payload, enough to read its metadata
+func (wis winIconStruct) probe() winIconStruct {
+	if wis.loaded() {
+		return wis
+	}
+	n := wis.getIconLength()
+	if n > entryProbeSize {
+		n = entryProbeSize
+	}
+	d := make([]byte, n)
+	if m, _ := wis.src.ReadAt(d, wis.srcOffset); m < n {
+		d = d[:m]
+	}
+	// 副本不再引用数据源，读取元数据时不会再次读取
+	// the copy drops the source so reading its metadata reads nothing more
+	wis.data, wis.src = d, nil
+	return wis
+}
@@ -0,0 +1,220 @@
+package ico
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// countingReaderAt 记录读取的字节数
+type countingReaderAt struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += n
+	return n, err
+}
+
+func TestLoadIconReaderAt(t *testing.T) {
+	b, err := ioutil.ReadFile(testFile("icon.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eager := loadTestIcon(t, "icon.ico")
+	cr := &countingReaderAt{r: bytes.NewReader(b)}
+	wi, err := LoadIconReaderAt(cr, int64(len(b)))
+	if err != nil {
+		t.Fatalf("LoadIconReaderAt() = %v", err)
+	}
+	dir := fileHeaderSize + headerSize*len(eager.icos)
+	if cr.n != dir {
+		t.Errorf("directory read %d bytes, want %d", cr.n, dir)
+	}
+	if got, want := wi.Entries(), eager.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() = %+v, want %+v", got, want)
+	}
+	if cr.n > dir+entryProbeSize*len(eager.icos) {
+		t.Errorf("Entries() read %d bytes", cr.n-dir)
+	}
+
+	before := cr.n
+	d, err := wi.GetImageData(1)
+	if err != nil {
+		t.Fatalf("GetImageData() = %v", err)
+	}
+	if !bytes.Equal(d, eager.icos[1].data) || cr.n-before != len(d) {
+		t.Errorf("GetImageData() read %d bytes for %d", cr.n-before, len(d))
+	}
+	if wi.icos[0].loaded() || !wi.icos[1].loaded() {
+		t.Errorf("only entry 1 should be loaded")
+	}
+
+	// 排序会改变偏移量，但不会影响延迟载入
+	wi.Sort(SortAscending)
+	eager.Sort(SortAscending)
+	var got, want bytes.Buffer
+	if err := wi.Write(&got); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := eager.Write(&want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("lazy and eager icons write different data")
+	}
+
+	if _, err := LoadIconReaderAt(bytes.NewReader(b[:100]), 100); err != ErrIcoInvalid {
+		t.Errorf("LoadIconReaderAt(truncated) = %v, want ErrIcoInvalid", err)
+	}
+}
+
+func TestOpenIconFile(t *testing.T) {
+	eager := loadTestIcon(t, "ICON16_1.ico")
+	for _, mmap := range []bool{false, true} {
+		wi, err := OpenIconFile(testFile("ICON16_1.ico"), &OpenOptions{Mmap: mmap})
+		if err != nil {
+			t.Fatalf("OpenIconFile(mmap=%v) = %v", mmap, err)
+		}
+		if wi.name != "ICON16_1" {
+			t.Errorf("name = %q", wi.name)
+		}
+		img, err := wi.Image(2)
+		if err != nil {
+			t.Fatalf("Image() = %v", err)
+		}
+		want, err := eager.Image(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameNRGBA(img, want) {
+			t.Errorf("Image(2) differs from the eager icon (mmap=%v)", mmap)
+		}
+		if err := wi.Close(); err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+		if _, err := wi.Image(2); err != nil {
+			t.Errorf("loaded entry after Close = %v", err)
+		}
+		if _, err := wi.GetImageData(3); err == nil {
+			t.Errorf("unloaded entry after Close = nil error (mmap=%v)", mmap)
+		}
+		if err := wi.Close(); err != nil {
+			t.Errorf("second Close() = %v", err)
+		}
+	}
+	if _, err := OpenIconFile(testFile(""), nil); err != ErrIcoFileType {
+		t.Errorf("OpenIconFile(dir) = %v, want ErrIcoFileType", err)
+	}
+}
+
+func TestOpenIconFile_WriteFile(t *testing.T) {
+	want, err := ioutil.ReadFile(testFile("ICON16_1.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, mmap := range []bool{false, true} {
+		// 写回打开的文件
+		p := filepath.Join(dir, "icon.ico")
+		if err := ioutil.WriteFile(p, want, 0644); err != nil {
+			t.Fatal(err)
+		}
+		wi, err := OpenIconFile(p, &OpenOptions{Mmap: mmap})
+		if err != nil {
+			t.Fatalf("OpenIconFile(mmap=%v) = %v", mmap, err)
+		}
+		if err := wi.WriteFile(p); err != nil {
+			t.Errorf("WriteFile(mmap=%v) = %v", mmap, err)
+		}
+		if err := wi.Close(); err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+		got, err := ioutil.ReadFile(p)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("file after WriteFile(mmap=%v) = %d bytes, %v, want %d bytes", mmap, len(got), err, len(want))
+		}
+	}
+}
+
+// eofReaderAt 读到数据末尾时与数据一起返回 io.EOF，io.ReaderAt 允许这样做
+type eofReaderAt struct{ b []byte }
+
+func (r eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(r.b).ReadAt(p, off)
+	if err == nil && off+int64(n) == int64(len(r.b)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestLoadIconReaderAt_EOF(t *testing.T) {
+	b, err := ioutil.ReadFile(testFile("icon.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wi, err := LoadIconReaderAt(eofReaderAt{b}, int64(len(b)))
+	if err != nil {
+		t.Fatalf("LoadIconReaderAt() = %v", err)
+	}
+	// 最后一个条目恰好在文件末尾结束
+	if err := wi.loadAll(); err != nil {
+		t.Errorf("loadAll() = %v", err)
+	}
+	if _, err := LoadIconReaderAt(eofReaderAt{b[:fileHeaderSize]}, fileHeaderSize); err != ErrIcoInvalid {
+		t.Errorf("LoadIconReaderAt(no directory) = %v, want ErrIcoInvalid", err)
+	}
+}
+
+func TestLoadIconReaderAt_Best(t *testing.T) {
+	// 目录中宽高为0的条目：512、256及300像素
+	var b bytes.Buffer
+	w := NewWriter(&b, new(bytes.Buffer))
+	for _, s := range []int{512, 16, 256, 300} {
+		if err := w.AddImage(image.NewNRGBA(image.Rect(0, 0, s, s))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	eager, err := LoadIconFile(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy := func() *WinIcon {
+		wi, err := LoadIconReaderAt(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wi
+	}
+	wi := lazy()
+	for _, s := range []int{16, 200, 256, 280, 300, 400, 512, 1024} {
+		if got, want := wi.BestIndex(s, 1), eager.BestIndex(s, 1); got != want {
+			t.Errorf("BestIndex(%d) = %d lazy, %d eager", s, got, want)
+		}
+	}
+	if wi.icos[0].loaded() {
+		t.Errorf("BestIndex loaded an entry")
+	}
+	for _, order := range []SortOrder{SortAscending, SortDescending} {
+		wi, eager := lazy(), eager
+		wi.Sort(order)
+		eager.Sort(order)
+		if got, want := wi.Entries(), eager.Entries(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Sort(%v): Entries() = %+v, want %+v", order, got, want)
+		}
+	}
+}
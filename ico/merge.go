@@ -0,0 +1,80 @@
+// 合并多个ico图标并去除重复的尺寸
+
+package ico
+
+// MergePolicy 合并时图标条目冲突的处理策略
+// MergePolicy decides which entry is kept when merged icons conflict
+type MergePolicy int
+
+// 合并策略
+// Merge policies
+const (
+	PreferFirst       MergePolicy = iota // 保留先出现的条目 keep the entry seen first
+	PreferLast                           // 保留后出现的条目 keep the entry seen last
+	PreferPNG                            // 优先保留PNG条目 keep the PNG entry, otherwise the first
+	PreferHigherDepth                    // 同一尺寸只保留位深度最高的条目 keep only the deepest entry of each size
+)
+
+// mergeKey 判断条目冲突的键
+// Key used to detect conflicting entries
+type mergeKey struct {
+	width, height, bits int
+}
+
+// Merge 合并多个ico图标的所有条目
+// 宽、高及位深度相同的条目视为冲突，根据 policy 保留其中一个；
+// PreferHigherDepth 则只比较宽和高，保留位深度最高的条目。
+// 合并的结果重新排序并重新计算偏移量。
+// Merge returns a new icon holding the union of the entries of icons.
+// Entries with the same width, height and bit depth conflict and
+// policy decides which one is kept; PreferHigherDepth compares only
+// width and height and keeps the deepest entry. The result is sorted
+// and its offsets are recomputed.
+func Merge(policy MergePolicy, icons ...*WinIcon) (*WinIcon, error) {
+	var icos WinIconStruct
+	seen := make(map[mergeKey]int)
+	for _, wi := range icons {
+		if wi == nil {
+			continue
+		}
+		if e := wi.loadAll(); e != nil {
+			return nil, e
+		}
+		for _, v := range wi.icos {
+			k := mergeKey{v.getIconWidth(), v.getIconHeight(), v.getIconBitsPerPixel()}
+			if policy == PreferHigherDepth {
+				k.bits = 0
+			}
+			i, ok := seen[k]
+			if !ok {
+				seen[k] = len(icos)
+				icos = append(icos, v)
+				continue
+			}
+			if policy.replace(icos[i], v) {
+				icos[i] = v
+			}
+		}
+	}
+	if len(icos) == 0 {
+		return nil, ErrIconsEmpty
+	}
+	wi := &WinIcon{icos: icos}
+	wi.Sort(SortDescending)
+	return wi, nil
+}
+
+// replace 判断新条目 n 是否替换已有条目 o
+// Reports whether the new entry n replaces the kept entry o
+func (p MergePolicy) replace(o, n winIconStruct) bool {
+	switch p {
+	case PreferLast:
+		return true
+	case PreferPNG:
+		return GetIconType(o.data) != typePNG && GetIconType(n.data) == typePNG
+	case PreferHigherDepth:
+		return n.getIconBitsPerPixel() > o.getIconBitsPerPixel()
+	default:
+		return false
+	}
+}
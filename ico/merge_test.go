@@ -0,0 +1,79 @@
+package ico
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	a := loadTestIcon(t, "ICON16_1.ico")
+	b := loadTestIcon(t, "icon.ico")
+	tests := []struct {
+		name   string
+		policy MergePolicy
+		from   *WinIcon
+	}{
+		{"PreferFirst", PreferFirst, a},
+		{"PreferLast", PreferLast, b},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Merge(tt.policy, a, b)
+			if err != nil {
+				t.Fatalf("Merge() = %v", err)
+			}
+			if len(got.icos) != 8 {
+				t.Fatalf("Merge() len = %d, want 8", len(got.icos))
+			}
+			for _, v := range got.icos {
+				var want []byte
+				for _, wi := range []*WinIcon{a, b, tt.from} {
+					for _, w := range wi.icos {
+						if w.getIconWidth() == v.getIconWidth() {
+							want = w.data
+						}
+					}
+				}
+				if !bytes.Equal(v.data, want) {
+					t.Errorf("entry %dx%d not taken from the preferred icon", v.getIconWidth(), v.getIconHeight())
+				}
+			}
+			for i := 1; i < len(got.icos); i++ {
+				if got.icos[i].getIconOffset() != got.icos[i-1].getIconOffset()+got.icos[i-1].getIconLength() {
+					t.Errorf("entry %d offset = %d", i, got.icos[i].getIconOffset())
+				}
+			}
+		})
+	}
+}
+
+func TestMerge_Policies(t *testing.T) {
+	dib := winIconStruct{Width: 32, Height: 32, BitsPerPixel: 8, data: encodeDIB(newTestImage(32, 32))}
+	dib32 := dib
+	dib32.BitsPerPixel = 32
+	pngData, err := encodePNG(newTestImage(32, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png := winIconStruct{Width: 32, Height: 32, BitsPerPixel: 8, data: pngData}
+	a := &WinIcon{icos: WinIconStruct{dib}}
+	b := &WinIcon{icos: WinIconStruct{png, dib32}}
+
+	got, err := Merge(PreferPNG, a, b)
+	if err != nil {
+		t.Fatalf("Merge(PreferPNG) = %v", err)
+	}
+	if len(got.icos) != 2 || (GetIconType(got.icos[0].data) != typePNG && GetIconType(got.icos[1].data) != typePNG) {
+		t.Errorf("Merge(PreferPNG) did not keep the PNG entry")
+	}
+	got, err = Merge(PreferHigherDepth, a, b)
+	if err != nil {
+		t.Fatalf("Merge(PreferHigherDepth) = %v", err)
+	}
+	if len(got.icos) != 1 || got.icos[0].getIconBitsPerPixel() != 32 {
+		t.Errorf("Merge(PreferHigherDepth) = %d entries, want one 32bit entry", len(got.icos))
+	}
+	if _, err := Merge(PreferFirst); err != ErrIconsEmpty {
+		t.Errorf("Merge() = %v, want %v", err, ErrIconsEmpty)
+	}
+}
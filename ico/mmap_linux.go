@@ -0,0 +1,52 @@
+// Linux上使用内存映射读取ico文件
+
+package ico
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// mmapReader 内存映射的只读文件
+// Read-only memory mapping of a file
+type mmapReader struct {
+	b []byte
+}
+
+// mmapFile 将文件 f 的 size 个字节映射到内存，映射不依赖 f 保持打开
+// Map size bytes of f into memory. The mapping outlives f.
+func mmapFile(f *os.File, size int64) (readerAtCloser, error) {
+	b, e := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if e != nil {
+		return nil, e
+	}
+	return &mmapReader{b: b}, nil
+}
+
+// ReadAt 实现 io.ReaderAt
+// ReadAt implements io.ReaderAt
+func (m *mmapReader) ReadAt(p []byte, off int64) (int, error) {
+	if m.b == nil {
+		return 0, os.ErrClosed
+	}
+	if off < 0 || off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close 解除内存映射
+// Close unmaps the memory
+func (m *mmapReader) Close() error {
+	if m.b == nil {
+		return nil
+	}
+	b := m.b
+	m.b = nil
+	return syscall.Munmap(b)
+}
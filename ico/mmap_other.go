@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+// 不支持内存映射的系统直接读取文件
+
+package ico
+
+import "os"
+
+// mmapFile 不支持内存映射，重新打开文件并使用普通读取
+// Memory mapping is not supported, reopen the file and read it normally
+func mmapFile(f *os.File, size int64) (readerAtCloser, error) {
+	return os.Open(f.Name())
+}
@@ -0,0 +1,95 @@
+// 提取图标条目时的文件名模板
+
+package ico
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 默认的文件名模板
+// Default file name templates
+const (
+	DefaultNameTemplate      = "{prefix}_icon{w}x{h}@{bpp}bit{dup}.{ext}" // 有前缀时 with a prefix
+	DefaultNoPrefixTemplate  = "icon{w}x{h}@{bpp}bit{dup}.{ext}"          // 没有前缀时 without a prefix
+	maxDuplicateNameAttempts = 10000                                      // {dup} 的最大尝试次数
+)
+
+// nameFields 文件名模板中占位符的值
+// Values of the placeholders of a file name template
+type nameFields struct {
+	prefix string // {prefix} 文件名前缀
+	name   string // {name} 源文件名(不含扩展名)
+	index  int    // {index} 条目的索引
+	width  int    // {w} 宽度
+	height int    // {h} 高度
+	bits   int    // {bpp} 位深度
+	format string // {type} 存储格式 dib 或 png
+	ext    string // {ext} 扩展名
+}
+
+// expand 展开文件名模板
+// {dup} 为0时展开为空字符串，否则展开为 "-N"
+// Expand a file name template. {dup} expands to nothing
+// when dup is 0 and to "-N" otherwise.
+func (f nameFields) expand(tmpl string, dup int) string {
+	d := ""
+	if dup > 0 {
+		d = "-" + strconv.Itoa(dup)
+	}
+	return strings.NewReplacer(
+		"{prefix}", f.prefix,
+		"{name}", f.name,
+		"{index}", strconv.Itoa(f.index),
+		"{w}", strconv.Itoa(f.width),
+		"{h}", strconv.Itoa(f.height),
+		"{bpp}", strconv.Itoa(f.bits),
+		"{type}", f.format,
+		"{ext}", f.ext,
+		"{dup}", d,
+	).Replace(tmpl)
+}
+
+// createNamedFile 根据模板创建文件并写入数据
+// 模板含有 {dup} 时，递增计数器避开本次已使用(used)的文件名；
+// overwrite 为假时也避开已存在的文件。模板没有 {dup} 时，
+// 本次已使用的文件名(即使 overwrite 为真)及已存在的文件
+// (overwrite 为假时)返回 os.ErrExist 错误
+// Create a file named after the template and write data to it.
+// With a {dup} placeholder the counter is increased to avoid the
+// names in used. Unless overwrite is set, existing files are never
+// replaced either. Without {dup}, a name in used (even with
+// overwrite) or an existing file (without it) fails with an
+// os.ErrExist error, so entries never overwrite each other.
+func createNamedFile(dir, tmpl string, f nameFields, data []byte, overwrite bool, used map[string]bool) (string, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	dups := strings.Contains(tmpl, "{dup}")
+	for dup := 0; dup < maxDuplicateNameAttempts; dup++ {
+		p := filepath.Join(dir, f.expand(tmpl, dup))
+		if used[p] {
+			if dups {
+				continue
+			}
+			return "", &os.PathError{Op: "create", Path: p, Err: os.ErrExist}
+		}
+		fs, e := os.OpenFile(p, flag, getPerm())
+		if e != nil {
+			if os.IsExist(e) && dups {
+				continue
+			}
+			return "", e
+		}
+		used[p] = true
+		_, e = fs.Write(data)
+		if ce := fs.Close(); e == nil {
+			e = ce
+		}
+		return p, e
+	}
+	return "", os.ErrExist
+}
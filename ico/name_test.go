@@ -0,0 +1,85 @@
+package ico
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_nameFields_expand(t *testing.T) {
+	f := nameFields{prefix: "p", name: "app", index: 3, width: 48, height: 32, bits: 8, format: FormatDIB, ext: "bmp"}
+	tests := []struct {
+		tmpl string
+		dup  int
+		want string
+	}{
+		{DefaultNameTemplate, 0, "p_icon48x32@8bit.bmp"},
+		{DefaultNoPrefixTemplate, 2, "icon48x32@8bit-2.bmp"},
+		{"{name}-{w}x{h}-{bpp}{dup}.{ext}", 1, "app-48x32-8-1.bmp"},
+		{"{index}_{type}_{unknown}.{ext}", 0, "3_dib_{unknown}.bmp"},
+	}
+	for _, tt := range tests {
+		if got := f.expand(tt.tmpl, tt.dup); got != tt.want {
+			t.Errorf("expand(%q, %d) = %q, want %q", tt.tmpl, tt.dup, got, tt.want)
+		}
+	}
+}
+
+func TestWinIcon_ExtractNameTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wi := loadTestIcon(t, "favicon.ico")
+	for i := 0; i < 2; i++ {
+		if err := wi.AddEntry(newTestImage(32, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opt := &ExtractOptions{NameTemplate: "{name}-{w}x{h}-{bpp}{dup}.{ext}"}
+	if err := wi.ExtractIconToFileWithOptions("", dir, opt); err != nil {
+		t.Fatalf("ExtractIconToFileWithOptions() = %v", err)
+	}
+	if err := wi.ExtractIconToFileWithOptions("", dir, nil); err != nil {
+		t.Fatalf("ExtractIconToFileWithOptions(nil) = %v", err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		got = append(got, fi.Name())
+	}
+	sort.Strings(got)
+	want := []string{
+		"favicon-256x256-32.png", "favicon-32x32-32-1.bmp", "favicon-32x32-32.bmp",
+		"icon256x256@32bit.png", "icon32x32@32bit-1.bmp", "icon32x32@32bit.bmp",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("extracted %v, want %v", got, want)
+	}
+
+	opt.NameTemplate = "{name}-{w}x{h}.{ext}"
+	if err := wi.ExtractIconToFileWithOptions("", dir, opt); !os.IsExist(err) {
+		t.Errorf("ExtractIconToFileWithOptions() = %v, want a file exists error", err)
+	}
+	// 覆盖已存在的文件，但两个32x32的条目不会互相覆盖
+	opt.Overwrite = true
+	if err := wi.ExtractIconToFileWithOptions("", dir, opt); !os.IsExist(err) {
+		t.Errorf("ExtractIconToFileWithOptions(Overwrite, same names) = %v, want a file exists error", err)
+	}
+	opt.NameTemplate = "{name}-{index}.{ext}"
+	for i := 0; i < 2; i++ {
+		if err := wi.ExtractIconToFileWithOptions("", dir, opt); err != nil {
+			t.Errorf("ExtractIconToFileWithOptions(Overwrite) = %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "favicon-2.bmp")); err != nil {
+		t.Error(err)
+	}
+}
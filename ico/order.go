@@ -0,0 +1,46 @@
+// ico图标条目的排序
+
+package ico
+
+import (
+	"io"
+	"sort"
+)
+
+// SortOrder 图标条目的排列顺序
+// SortOrder is the order of the entries in the icon directory
+type SortOrder int
+
+// 排列顺序
+// Sort orders
+const (
+	SortDescending SortOrder = iota // 最大的在前(默认) largest first, the default
+	SortAscending                   // 最小的在前 smallest first
+	SortAsGiven                     // 保持原有顺序 keep the current order
+)
+
+// Sort 根据 order 对图标条目排序并重新计算偏移量
+// 依次比较宽度、高度及位深度，相同的条目保持原有顺序
+// Sort orders the entries and recomputes the offsets. Entries are
+// compared by width, height and then bit depth; equal entries keep
+// their relative order.
+func (wi *WinIcon) Sort(order SortOrder) {
+	switch order {
+	case SortDescending:
+		sort.Stable(wi.icos)
+	case SortAscending:
+		sort.Stable(sort.Reverse(wi.icos))
+	}
+	wi.updateHeader()
+}
+
+// WriteOrdered 按照 order 的顺序将ico文件写入 io.Writer
+// 不改变 WinIcon 本身的顺序
+// WriteOrdered writes the icon to w with its entries in the given
+// order, leaving the order of wi itself untouched.
+func (wi *WinIcon) WriteOrdered(w io.Writer, order SortOrder) error {
+	c := &WinIcon{icos: make(WinIconStruct, len(wi.icos))}
+	copy(c.icos, wi.icos)
+	c.Sort(order)
+	return c.Write(w)
+}
@@ -0,0 +1,69 @@
+package ico
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWinIcon_Sort(t *testing.T) {
+	icos := WinIconStruct{
+		{Width: 16, Height: 16, BitsPerPixel: 32},
+		{Width: 32, Height: 32, BitsPerPixel: 8},
+		{Width: 0, Height: 0, BitsPerPixel: 32},
+		{Width: 32, Height: 32, BitsPerPixel: 32},
+		{Width: 16, Height: 16, BitsPerPixel: 4},
+	}
+	tests := []struct {
+		name  string
+		order SortOrder
+		want  [][2]int
+	}{
+		{"Descending", SortDescending, [][2]int{{256, 32}, {32, 32}, {32, 8}, {16, 32}, {16, 4}}},
+		{"Ascending", SortAscending, [][2]int{{16, 4}, {16, 32}, {32, 8}, {32, 32}, {256, 32}}},
+		{"AsGiven", SortAsGiven, [][2]int{{16, 32}, {32, 8}, {256, 32}, {32, 32}, {16, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wi := &WinIcon{icos: make(WinIconStruct, len(icos))}
+			copy(wi.icos, icos)
+			wi.Sort(tt.order)
+			for i, v := range wi.icos {
+				got := [2]int{v.getIconWidth(), v.getIconBitsPerPixel()}
+				if got != tt.want[i] {
+					t.Errorf("Sort(%v)[%d] = %v, want %v", tt.order, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateWinIconWithOptions(t *testing.T) {
+	files := []string{
+		testFile("vkico64x64@32bit.bmp"),
+		testFile("vkico16x16@32bit.bmp"),
+		testFile("vkico32x32@32bit.bmp"),
+	}
+	wi, err := CreateWinIconWithOptions(files, &CreateOptions{Order: SortAscending})
+	if err != nil {
+		t.Fatalf("CreateWinIconWithOptions() = %v", err)
+	}
+	for i, w := range []int{16, 32, 64} {
+		if got := wi.icos[i].getIconWidth(); got != w {
+			t.Errorf("entry %d width = %d, want %d", i, got, w)
+		}
+	}
+	var a, b bytes.Buffer
+	if err := wi.WriteOrdered(&a, SortDescending); err != nil {
+		t.Fatalf("WriteOrdered() = %v", err)
+	}
+	if wi.icos[0].getIconWidth() != 16 {
+		t.Errorf("WriteOrdered() changed the order of the icon")
+	}
+	wi.Sort(SortDescending)
+	if err := wi.Write(&b); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Errorf("WriteOrdered() differs from Sort() and Write()")
+	}
+}
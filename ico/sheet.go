@@ -0,0 +1,179 @@
+// 将所有图标条目绘制到一张预览图中
+
+package ico
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Background 预览图中图标下面的背景
+// Background drawn under the entries of a contact sheet
+type Background int
+
+// 预览图的背景
+// Contact sheet backgrounds
+const (
+	BackgroundChecker Background = iota // 棋盘格，显示透明区域 checkerboard, shows transparency
+	BackgroundLight                     // 浅色 light
+	BackgroundDark                      // 深色 dark
+)
+
+// 预览图的默认值及颜色
+// Contact sheet defaults and colors
+const (
+	defaultSheetPadding = 8 // 条目之间的间距
+	checkerSize         = 8 // 棋盘格方块的大小
+)
+
+var (
+	checkerLight = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	checkerDark  = color.NRGBA{0xcc, 0xcc, 0xcc, 0xff}
+	sheetLight   = color.NRGBA{0xf4, 0xf4, 0xf4, 0xff}
+	sheetDark    = color.NRGBA{0x20, 0x20, 0x20, 0xff}
+	sheetError   = color.NRGBA{0xe0, 0x20, 0x20, 0xff}
+)
+
+// SheetOptions 预览图的选项
+// Options of ContactSheet
+type SheetOptions struct {
+	Backgrounds []Background // 每种背景一行，默认为棋盘格、浅色、深色 one row per background, all three by default
+	Padding     int          // 条目之间的间距，<=0 时为8 space between entries, 8 when <= 0
+	NoLabels    bool         // 不显示尺寸和位深度 omit the size and depth labels
+}
+
+// ContactSheet 将所有条目按顺序排成一行绘制到一张图像中，
+// 每种背景一行，每个条目下标注尺寸和位深度。
+// 无法解码的条目绘制为红色的叉。opt 为 nil 时使用默认选项
+// ContactSheet draws every entry side by side in one image, with
+// one row per background and the size and depth written under each
+// entry. Entries that fail to decode are drawn as a red cross.
+// A nil opt uses the defaults.
+func (wi *WinIcon) ContactSheet(opt *SheetOptions) image.Image {
+	if opt == nil {
+		opt = new(SheetOptions)
+	}
+	bgs := opt.Backgrounds
+	if len(bgs) == 0 {
+		bgs = []Background{BackgroundChecker, BackgroundLight, BackgroundDark}
+	}
+	pad := opt.Padding
+	if pad <= 0 {
+		pad = defaultSheetPadding
+	}
+	face := basicfont.Face7x13
+	labelH := 0
+	if !opt.NoLabels {
+		labelH = 2*face.Height + pad/2
+	}
+
+	// 每列的宽度取图像和标签中较宽的一个，行高取最高的图像
+	// A column is as wide as its image or label, a row as tall as the tallest image
+	type tile struct {
+		img    image.Image
+		labels [2]string
+		size   image.Point // 图像的大小
+		width  int         // 列宽
+	}
+	tiles := make([]tile, len(wi.icos))
+	rowH := 0
+	for i, v := range wi.icos {
+		t := &tiles[i]
+		t.labels = [2]string{
+			fmt.Sprintf("%dx%d", v.getIconWidth(), v.getIconHeight()),
+			fmt.Sprintf("%dbit", v.getIconBitsPerPixel()),
+		}
+		t.size = image.Pt(v.getIconWidth(), v.getIconHeight())
+		img, e := wi.Image(i)
+		if e != nil {
+			t.labels[1] = "error"
+		} else {
+			t.img = img
+			t.size = img.Bounds().Size()
+		}
+		w, h := t.size.X, t.size.Y
+		if !opt.NoLabels {
+			for _, s := range t.labels {
+				w = imax(w, len(s)*face.Advance)
+			}
+		}
+		t.width = w
+		rowH = imax(rowH, h)
+	}
+	width := pad
+	for _, t := range tiles {
+		width += t.width + pad
+	}
+	bandH := pad + rowH + labelH + pad
+	sheet := image.NewNRGBA(image.Rect(0, 0, width, bandH*len(bgs)))
+
+	for r, bg := range bgs {
+		band := image.Rect(0, r*bandH, width, (r+1)*bandH)
+		fillBackground(sheet, band, bg)
+		x := band.Min.X + pad
+		for _, t := range tiles {
+			// 图像在列中水平居中，底部对齐
+			// Centred in its column and aligned to the bottom of the row
+			at := image.Pt(x+(t.width-t.size.X)/2, band.Min.Y+pad+rowH-t.size.Y)
+			cell := image.Rectangle{at, at.Add(t.size)}
+			if t.img != nil {
+				draw.Draw(sheet, cell, t.img, t.img.Bounds().Min, draw.Over)
+			} else {
+				drawCross(sheet, cell)
+			}
+			if !opt.NoLabels {
+				ink := sheetDark
+				if bg == BackgroundDark {
+					ink = sheetLight
+				}
+				d := &font.Drawer{Dst: sheet, Src: image.NewUniform(ink), Face: face}
+				for l, s := range t.labels {
+					d.Dot = fixed.P(x+(t.width-len(s)*face.Advance)/2,
+						band.Min.Y+pad+rowH+pad/2+(l+1)*face.Height-face.Descent)
+					d.DrawString(s)
+				}
+			}
+			x += t.width + pad
+		}
+	}
+	return sheet
+}
+
+// fillBackground 使用指定的背景填充矩形区域
+// Fill r with the background bg
+func fillBackground(dst *image.NRGBA, r image.Rectangle, bg Background) {
+	switch bg {
+	case BackgroundLight:
+		draw.Draw(dst, r, image.NewUniform(sheetLight), image.Point{}, draw.Src)
+	case BackgroundDark:
+		draw.Draw(dst, r, image.NewUniform(sheetDark), image.Point{}, draw.Src)
+	default:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := checkerLight
+				if ((x-r.Min.X)/checkerSize+(y-r.Min.Y)/checkerSize)%2 == 1 {
+					c = checkerDark
+				}
+				dst.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// drawCross 在无法解码的条目的位置绘制红色的叉
+// Draw a red cross where an entry failed to decode
+func drawCross(dst *image.NRGBA, r image.Rectangle) {
+	w, h := r.Dx(), r.Dy()
+	for i := 0; i < imax(w, h); i++ {
+		x := i * w / imax(w, h)
+		y := i * h / imax(w, h)
+		dst.SetNRGBA(r.Min.X+x, r.Min.Y+y, sheetError)
+		dst.SetNRGBA(r.Max.X-1-x, r.Min.Y+y, sheetError)
+	}
+}
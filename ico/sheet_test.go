@@ -0,0 +1,53 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestWinIcon_ContactSheet(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	img.SetNRGBA(0, 15, color.NRGBA{0xff, 0, 0, 0xff})
+	wi := new(WinIcon)
+	if err := wi.AddEntry(img); err != nil {
+		t.Fatal(err)
+	}
+	wi.icos = append(wi.icos, winIconStruct{Width: 32, Height: 32, BitsPerPixel: 32, data: []byte{1, 2, 3}})
+
+	opt := &SheetOptions{Backgrounds: []Background{BackgroundLight, BackgroundDark}, Padding: 4, NoLabels: true}
+	sheet := wi.ContactSheet(opt).(*image.NRGBA)
+	// 两列：16 和 32，两行高度为 4+32+4
+	if b := sheet.Bounds(); b.Dx() != 4+16+4+32+4 || b.Dy() != 2*(4+32+4) {
+		t.Fatalf("sheet is %dx%d", b.Dx(), b.Dy())
+	}
+	// 第一个条目底部对齐，透明像素显示背景
+	tests := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{4, 4 + 32 - 1, color.NRGBA{0xff, 0, 0, 0xff}},
+		{5, 4 + 32 - 1, sheetLight},
+		{4, 40 + 4 + 32 - 1, color.NRGBA{0xff, 0, 0, 0xff}},
+		{5, 40 + 4 + 32 - 1, sheetDark},
+		{24, 4, sheetError},
+		{24 + 31, 40 + 4, sheetError},
+	}
+	for _, tt := range tests {
+		if got := sheet.NRGBAAt(tt.x, tt.y); got != tt.want {
+			t.Errorf("pixel (%d,%d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	// 棋盘格及标签
+	sheet = loadTestIcon(t, "favicon.ico").ContactSheet(nil).(*image.NRGBA)
+	if got := sheet.NRGBAAt(0, 0); got != checkerLight {
+		t.Errorf("checker pixel = %v, want %v", got, checkerLight)
+	}
+	if got := sheet.NRGBAAt(checkerSize, 0); got != checkerDark {
+		t.Errorf("checker pixel = %v, want %v", got, checkerDark)
+	}
+	if b := sheet.Bounds(); b.Dy() != 3*(8+256+26+4+8) {
+		t.Errorf("sheet height = %d", b.Dy())
+	}
+}
@@ -0,0 +1,82 @@
+// 超过256像素的图像的处理及图像缩放
+
+package ico
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// maxIconSize 目录中可以表示的最大尺寸
+// The largest size the icon directory can describe
+const maxIconSize = 256
+
+// OversizePolicy 创建ico图标时，宽或高超过256像素的图像的处理方式
+// OversizePolicy decides what happens to images wider or
+// taller than 256 pixels when an icon is created
+type OversizePolicy int
+
+// 超过256像素的处理方式
+// Oversize policies
+const (
+	OversizeReject    OversizePolicy = iota // 返回 ErrIcoOversize(默认) fail with ErrIcoOversize, the default
+	OversizeDownscale                       // 按比例缩小到256像素以内 scale down to fit in 256 pixels
+	OversizeKeep                            // 原样保存，目录中的宽高为0 keep as is, stored as 0 in the directory
+)
+
+// fitOversize 根据 policy 处理超过256像素的图标条目
+// Apply policy to an entry wider or taller than 256 pixels
+func (wis *winIconStruct) fitOversize(policy OversizePolicy) error {
+	w, h := wis.getIconWidth(), wis.getIconHeight()
+	if w <= maxIconSize && h <= maxIconSize {
+		return nil
+	}
+	switch policy {
+	case OversizeKeep:
+		return nil
+	case OversizeDownscale:
+		img, e := wis.decodeIcon()
+		if e != nil {
+			return e
+		}
+		sw, sh := fitSize(w, h, maxIconSize)
+		n, e := imageToIcon(scaleImage(img, sw, sh), true)
+		if e != nil {
+			return e
+		}
+		*wis = n
+		return nil
+	default:
+		return ErrIcoOversize
+	}
+}
+
+// fitSize 按比例缩小宽和高，使其不超过 max
+// Scale width and height down proportionally to fit in max
+func fitSize(w, h, max int) (int, int) {
+	if w <= max && h <= max {
+		return w, h
+	}
+	if w >= h {
+		return max, imax(1, h*max/w)
+	}
+	return imax(1, w*max/h), max
+}
+
+// scaleImage 将图像缩放到指定的宽和高
+// Resample an image to the given width and height
+func scaleImage(img image.Image, w, h int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// imax 返回较大的整数
+// The larger of two ints
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,92 @@
+package ico
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestPNG 在临时目录中写入一个PNG文件
+func writeTestPNG(t *testing.T, dir string, img image.Image) string {
+	t.Helper()
+	d, err := encodePNG(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "test.png")
+	if err := ioutil.WriteFile(p, d, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCreateWinIcon_Oversize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "winicon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := writeTestPNG(t, dir, newTestImage(512, 300))
+
+	if _, err := CreateWinIcon([]string{p}); err != ErrIcoOversize {
+		t.Errorf("CreateWinIcon() = %v, want %v", err, ErrIcoOversize)
+	}
+	tests := []struct {
+		name         string
+		policy       OversizePolicy
+		w, h         int
+		dirW, dirH   uint8
+		wantFileName string
+	}{
+		{"Downscale", OversizeDownscale, 256, 150, 0, 150, "x_icon256x150@32bit.png"},
+		{"Keep", OversizeKeep, 512, 300, 0, 0, "x_icon512x300@32bit.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wi, err := CreateWinIconWithOptions([]string{p}, &CreateOptions{Oversize: tt.policy})
+			if err != nil {
+				t.Fatalf("CreateWinIconWithOptions() = %v", err)
+			}
+			e := reloadIcon(t, wi).Entries()[0]
+			if e.Width != tt.w || e.Height != tt.h {
+				t.Errorf("entry size = %dx%d, want %dx%d", e.Width, e.Height, tt.w, tt.h)
+			}
+			if wi.icos[0].Width != tt.dirW || wi.icos[0].Height != tt.dirH {
+				t.Errorf("directory size = %dx%d, want %dx%d", wi.icos[0].Width, wi.icos[0].Height, tt.dirW, tt.dirH)
+			}
+			out := filepath.Join(dir, tt.name)
+			if err := os.Mkdir(out, 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := wi.ExtractIconToFile("x", out); err != nil {
+				t.Fatalf("ExtractIconToFile() = %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(out, tt.wantFileName)); err != nil {
+				t.Errorf("ExtractIconToFile() = %v", err)
+			}
+		})
+	}
+}
+
+func TestWinIcon_NonSquare(t *testing.T) {
+	wi := loadTestIcon(t, "favicon.ico")
+	src := newTestImage(48, 32)
+	if err := wi.AddEntry(src); err != nil {
+		t.Fatalf("AddEntry() = %v", err)
+	}
+	r := reloadIcon(t, wi)
+	e := r.Entries()[1]
+	if e.Width != 48 || e.Height != 32 {
+		t.Errorf("entry size = %dx%d, want 48x32", e.Width, e.Height)
+	}
+	img, err := r.icos[1].decodeIcon()
+	if err != nil {
+		t.Fatalf("decodeIcon() = %v", err)
+	}
+	if !reflect.DeepEqual(img, image.Image(src)) {
+		t.Errorf("decoded entry differs from the source image")
+	}
+}
@@ -0,0 +1,121 @@
+// 检查、优化及转换ico图标的条目
+
+package ico
+
+import (
+	"fmt"
+
+	pngtool "github.com/gemark/WinIconTools/png"
+)
+
+// Validate 检查图标的每个条目：PNG条目符合规范，图像数据可以解码，
+// 且宽高与目录中的值一致
+// Validate checks that every PNG entry conforms to the specification,
+// that every entry decodes and that its size matches the icon directory.
+func (wi *WinIcon) Validate() error {
+	if len(wi.icos) == 0 {
+		return ErrIconsEmpty
+	}
+	if e := wi.loadAll(); e != nil {
+		return e
+	}
+	for i, v := range wi.icos {
+		if e := v.validate(); e != nil {
+			return fmt.Errorf("ico: entry %d: %v", i, e)
+		}
+	}
+	return nil
+}
+
+// validate 检查单个图标条目
+// Check a single entry
+func (wis winIconStruct) validate() error {
+	if int(wis.ImageDataSize) != len(wis.data) {
+		return fmt.Errorf("data size %d, directory says %d", len(wis.data), wis.ImageDataSize)
+	}
+	if GetIconType(wis.data) == typePNG {
+		if e := checkPNG(wis.data); e != nil {
+			return e
+		}
+	}
+	img, e := wis.decodeIcon()
+	if e != nil {
+		return e
+	}
+	b := img.Bounds()
+	if b.Dx() != wis.getIconWidth() || b.Dy() != wis.getIconHeight() {
+		return fmt.Errorf("image is %dx%d, directory says %dx%d",
+			b.Dx(), b.Dy(), wis.getIconWidth(), wis.getIconHeight())
+	}
+	return nil
+}
+
+// Optimize 使用最高压缩率重新压缩PNG条目，只保留变小的结果
+// 返回节省的字节数
+// Optimize recompresses the PNG entries with the best compression,
+// keeping only the results that are smaller. It returns the number
+// of bytes saved.
+func (wi *WinIcon) Optimize() (int, error) {
+	saved := 0
+	if e := wi.loadAll(); e != nil {
+		return saved, e
+	}
+	for i := range wi.icos {
+		v := &wi.icos[i]
+		if GetIconType(v.data) != typePNG {
+			continue
+		}
+		img, e := v.decodeIcon()
+		if e != nil {
+			return saved, e
+		}
+		d, e := encodePNG(img)
+		if e != nil {
+			return saved, e
+		}
+		if len(d) < len(v.data) {
+			// 编码器可能选择不同的颜色类型，位深度以新的IHDR为准
+			// the encoder may pick another color type, take the
+			// depth from the new IHDR
+			hdr, e := pngtool.ParseHeader(d)
+			if e != nil {
+				return saved, e
+			}
+			saved += len(v.data) - len(d)
+			v.data = d
+			v.BitsPerPixel = uint16(hdr.BitsPerPixel())
+		}
+	}
+	wi.updateHeader()
+	return saved, nil
+}
+
+// ConvertEntries 将所有条目转换为指定的存储格式(FormatPNG 或 FormatDIB)
+// 256像素及以上的条目总是使用PNG存储
+// ConvertEntries re-stores every entry in format, FormatPNG or
+// FormatDIB. Entries of 256 pixels or more always stay PNG.
+func (wi *WinIcon) ConvertEntries(format string) error {
+	if format != FormatPNG && format != FormatDIB {
+		return fmt.Errorf("ico: unknown entry format %q", format)
+	}
+	if e := wi.loadAll(); e != nil {
+		return e
+	}
+	for i, v := range wi.icos {
+		png := GetIconType(v.data) == typePNG
+		if png == (format == FormatPNG) {
+			continue
+		}
+		img, e := v.decodeIcon()
+		if e != nil {
+			return e
+		}
+		n, e := imageToIcon(img, format == FormatPNG)
+		if e != nil {
+			return e
+		}
+		wi.icos[i] = n
+	}
+	wi.updateHeader()
+	return nil
+}
@@ -0,0 +1,126 @@
+package ico
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestWinIcon_Validate(t *testing.T) {
+	for _, n := range []string{"ICON16_1.ico", "favicon.ico", "icon.ico"} {
+		if err := loadTestIcon(t, n).Validate(); err != nil {
+			t.Errorf("Validate(%s) = %v", n, err)
+		}
+	}
+	wi := loadTestIcon(t, "icon.ico")
+	wi.icos[2].Width = 20
+	if err := wi.Validate(); err == nil {
+		t.Errorf("Validate() = nil, want a size error")
+	}
+}
+
+func TestWinIcon_ConvertEntries(t *testing.T) {
+	wi := loadTestIcon(t, "icon.ico")
+	before := wi.Entries()
+	if err := wi.ConvertEntries(FormatPNG); err != nil {
+		t.Fatalf("ConvertEntries(png) = %v", err)
+	}
+	for _, e := range reloadIcon(t, wi).Entries() {
+		if e.Format != FormatPNG || e.Width != before[e.Index].Width {
+			t.Errorf("entry %d = %s %dx%d", e.Index, e.Format, e.Width, e.Height)
+		}
+	}
+	if err := wi.ConvertEntries(FormatDIB); err != nil {
+		t.Fatalf("ConvertEntries(dib) = %v", err)
+	}
+	for _, e := range wi.Entries() {
+		want := FormatDIB
+		if e.Width >= 256 {
+			want = FormatPNG
+		}
+		if e.Format != want {
+			t.Errorf("entry %d format = %s, want %s", e.Index, e.Format, want)
+		}
+	}
+	if err := wi.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	if err := wi.ConvertEntries("gif"); err == nil {
+		t.Errorf("ConvertEntries(gif) = nil error")
+	}
+}
+
+func TestWinIcon_Optimize(t *testing.T) {
+	wi := loadTestIcon(t, "favicon.ico")
+	size := wi.icos[0].getIconLength()
+	saved, err := wi.Optimize()
+	if err != nil {
+		t.Fatalf("Optimize() = %v", err)
+	}
+	if got := wi.icos[0].getIconLength(); got != size-saved || saved < 0 {
+		t.Errorf("Optimize() saved %d, length %d -> %d", saved, size, got)
+	}
+	if err := reloadIcon(t, wi).Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+// opaqueRGBAPNG 未压缩的 size*size 不透明PNG，颜色类型为RGBA
+func opaqueRGBAPNG(t *testing.T, size int) []byte {
+	t.Helper()
+	chunk := func(b *bytes.Buffer, name string, data []byte) {
+		binary.Write(b, binary.BigEndian, uint32(len(data)))
+		c := append([]byte(name), data...)
+		b.Write(c)
+		binary.Write(b, binary.BigEndian, crc32.ChecksumIEEE(c))
+	}
+	var idat bytes.Buffer
+	z, _ := zlib.NewWriterLevel(&idat, zlib.NoCompression)
+	row := bytes.Repeat([]byte{0x20, 0x40, 0x80, 0xff}, size)
+	for y := 0; y < size; y++ {
+		z.Write(append([]byte{0}, row...))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(size))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(size))
+	ihdr[8], ihdr[9] = 8, 6
+	chunk(&b, "IHDR", ihdr)
+	chunk(&b, "IDAT", idat.Bytes())
+	chunk(&b, "IEND", nil)
+	return b.Bytes()
+}
+
+func TestWinIcon_OptimizeDepth(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b, new(bytes.Buffer))
+	if err := w.AddPNG(bytes.NewReader(opaqueRGBAPNG(t, 32))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wi, err := LoadIconFile(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bpp := wi.Entries()[0].BitsPerPixel; bpp != 32 {
+		t.Fatalf("BitsPerPixel = %d before Optimize, want 32", bpp)
+	}
+	// 不透明的图像重新编码为RGB，目录中的位深度随之更新
+	if saved, err := wi.Optimize(); err != nil || saved <= 0 {
+		t.Fatalf("Optimize() = %d, %v", saved, err)
+	}
+	if bpp := wi.Entries()[0].BitsPerPixel; bpp != 24 {
+		t.Errorf("BitsPerPixel = %d after Optimize, want 24", bpp)
+	}
+	if err := reloadIcon(t, wi).Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
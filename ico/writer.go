@@ -0,0 +1,186 @@
+// 流式写入ico文件，图像数据先写入临时文件
+
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+)
+
+// Writer 流式写入ico文件
+// 每个条目的数据立即写入暂存区(spool)，只在内存中保留目录，
+// Close 时先写入文件头和目录，再从暂存区复制图像数据。
+// 内存占用的峰值只与最大的条目有关。
+// Writer writes an ico file one entry at a time. The payload of every
+// entry goes straight to a spool and only the directory is kept in
+// memory; Close emits the header and the directory followed by the
+// spooled data. Peak memory is bounded by the largest entry.
+type Writer struct {
+	Order SortOrder // 目录的顺序，默认最大的在前 directory order, largest first by default
+
+	w       io.Writer     // 输出
+	spool   io.ReadWriter // 图像数据的暂存区
+	temp    *os.File      // 自动创建的临时文件
+	entries WinIconStruct // 目录(不含图像数据)
+	size    int64         // 暂存区中数据的大小
+	err     error         // 写入暂存区失败后的错误，之后的调用都返回它
+	closed  bool
+}
+
+// NewWriter 创建一个写入 w 的 Writer
+// spool 为暂存图像数据的空的存储，为 nil 时使用临时文件并在 Close 时删除。
+// spool 实现 io.Seeker 时，Close 前会回到起始位置再读取。
+// NewWriter returns a Writer writing to w. Payloads are spooled to
+// the empty spool, or to a temporary file removed on Close when spool
+// is nil. A spool that implements io.Seeker is rewound before it is read.
+func NewWriter(w io.Writer, spool io.ReadWriter) *Writer {
+	return &Writer{w: w, spool: spool}
+}
+
+// AddImage 添加一个图像条目
+// 宽或高达到256时使用PNG存储，否则使用32位DIB
+// AddImage adds an entry built from img. Images of 256 pixels
+// or more are stored as PNG, smaller ones as 32-bit DIB.
+func (iw *Writer) AddImage(img image.Image) error {
+	wis, e := imageToIcon(img, false)
+	if e != nil {
+		return e
+	}
+	return iw.add(wis, bytes.NewReader(wis.data))
+}
+
+// AddPNG 从 r 中读取PNG数据并添加为一个条目
+// 数据直接复制到暂存区，不会全部载入内存，因此只检查文件头及IHDR块；
+// 与 CreateWinIcon 不同，其余的块不做一致性检查，需要时在载入后使用
+// WinIcon.Validate 检查
+// AddPNG adds an entry with the PNG data read from r. The data
+// is copied to the spool without being held in memory, so only the
+// signature and the IHDR chunk are checked; unlike CreateWinIcon the
+// other chunks are not checked for conformance. Use WinIcon.Validate
+// on the loaded icon when that matters.
+func (iw *Writer) AddPNG(r io.Reader) error {
+	// 文件头及IHDR块共33字节
+	// the signature and the IHDR chunk take 33 bytes
+	h := make([]byte, 33)
+	n, e := io.ReadFull(r, h)
+	if !checkPNGHeader(h[:n]) {
+		return ErrIcoInvalid
+	}
+	if e != nil {
+		return e
+	}
+	wis, e := pngToIcon(h)
+	if e != nil {
+		return e
+	}
+	return iw.add(wis, io.MultiReader(bytes.NewReader(h), r))
+}
+
+// add 将条目的数据写入暂存区并记录目录。复制失败时暂存区中已有
+// 部分数据，之后条目的偏移量都会错误，因此 Writer 从此失败，
+// 之后的 add 及 Close 都返回同一个错误。暂存的数据超过32位的
+// 偏移量能表示的大小时返回 ErrIcoTooLarge，同样不可恢复
+// Spool the payload of an entry and record it in the directory. A
+// failed copy leaves part of the payload in the spool and would shift
+// every later offset, so the Writer fails for good: later calls to add
+// and Close return the same error. Spooling more data than a 32-bit
+// offset can address fails the same way with ErrIcoTooLarge.
+func (iw *Writer) add(wis winIconStruct, r io.Reader) error {
+	if iw.closed {
+		return ErrWriterClosed
+	}
+	if iw.err != nil {
+		return iw.err
+	}
+	if len(iw.entries) >= 0xffff {
+		return ErrIcoInvalid
+	}
+	if iw.spool == nil {
+		f, e := ioutil.TempFile("", "winicon")
+		if e != nil {
+			return e
+		}
+		iw.temp, iw.spool = f, f
+	}
+	max := math.MaxUint32 - iw.size
+	n, e := io.Copy(iw.spool, io.LimitReader(r, max+1))
+	if e == nil && n > max {
+		e = ErrIcoTooLarge
+	}
+	if e != nil {
+		iw.err = e
+		return e
+	}
+	wis.data = nil
+	wis.setIconLength(int(n))
+	wis.setIconOffset(int(iw.size))
+	iw.size += n
+	iw.entries = append(iw.entries, wis)
+	return nil
+}
+
+// Close 写入文件头、目录及所有图像数据
+// 不会关闭 NewWriter 的 w 和 spool 参数
+// Close writes the header, the directory and all the spooled data.
+// It does not close the w or spool given to NewWriter.
+func (iw *Writer) Close() error {
+	if iw.closed {
+		return ErrWriterClosed
+	}
+	iw.closed = true
+	if iw.temp != nil {
+		defer os.Remove(iw.temp.Name())
+		defer iw.temp.Close()
+	}
+	if iw.err != nil {
+		return iw.err
+	}
+	if len(iw.entries) == 0 {
+		return ErrIconsEmpty
+	}
+	// 数据按写入的顺序存放，目录可以任意排序
+	// The data stays in spool order, only the directory is sorted
+	base := fileHeaderSize + headerSize*len(iw.entries)
+	if int64(base)+iw.size > math.MaxUint32 {
+		return ErrIcoTooLarge
+	}
+	for i := range iw.entries {
+		iw.entries[i].ImageOffset += uint32(base)
+	}
+	switch iw.Order {
+	case SortDescending:
+		sort.Stable(iw.entries)
+	case SortAscending:
+		sort.Stable(sort.Reverse(iw.entries))
+	}
+	ih := make([]byte, fileHeaderSize)
+	binary.LittleEndian.PutUint16(ih[2:4], 1)
+	binary.LittleEndian.PutUint16(ih[4:6], uint16(len(iw.entries)))
+	if _, e := iw.w.Write(ih); e != nil {
+		return e
+	}
+	for _, v := range iw.entries {
+		if _, e := iw.w.Write(v.headerToBytes(false)); e != nil {
+			return e
+		}
+	}
+	if s, ok := iw.spool.(io.Seeker); ok {
+		if _, e := s.Seek(0, io.SeekStart); e != nil {
+			return e
+		}
+	}
+	n, e := io.Copy(iw.w, io.LimitReader(iw.spool, iw.size))
+	if e != nil {
+		return e
+	}
+	if n != iw.size {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package ico
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+// loadIconBytes 将数据写入临时文件后载入
+func loadIconBytes(t *testing.T, b []byte) *WinIcon {
+	t.Helper()
+	f, err := ioutil.TempFile("", "winicon*.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	wi, err := LoadIconFile(f)
+	if err != nil {
+		t.Fatalf("LoadIconFile() = %v", err)
+	}
+	return wi
+}
+
+func TestWriter(t *testing.T) {
+	p, err := ioutil.ReadFile(testFile("vkico256x256@32bit.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[int]winIconStruct)
+	for _, n := range []int{16, 48} {
+		if want[n], err = imageToIcon(newTestImage(n, n), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want[256], err = pngToIcon(p); err != nil {
+		t.Fatal(err)
+	}
+	w256 := want[256]
+	w256.data = p
+	want[256] = w256
+	for _, tt := range []struct {
+		name  string
+		spool io.ReadWriter
+		order SortOrder
+		want  []int
+	}{
+		{"temp", nil, SortDescending, []int{256, 48, 16}},
+		{"buffer", new(bytes.Buffer), SortAscending, []int{16, 48, 256}},
+		{"given", nil, SortAsGiven, []int{16, 256, 48}},
+	} {
+		var out bytes.Buffer
+		w := NewWriter(&out, tt.spool)
+		w.Order = tt.order
+		if err := w.AddImage(newTestImage(16, 16)); err != nil {
+			t.Fatalf("%s: AddImage() = %v", tt.name, err)
+		}
+		if err := w.AddPNG(bytes.NewReader(p)); err != nil {
+			t.Fatalf("%s: AddPNG() = %v", tt.name, err)
+		}
+		if err := w.AddImage(newTestImage(48, 48)); err != nil {
+			t.Fatalf("%s: AddImage() = %v", tt.name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Close() = %v", tt.name, err)
+		}
+		if err := w.AddImage(newTestImage(16, 16)); err != ErrWriterClosed {
+			t.Errorf("%s: AddImage() after Close = %v, want ErrWriterClosed", tt.name, err)
+		}
+
+		wi := loadIconBytes(t, out.Bytes())
+		if err := wi.Validate(); err != nil {
+			t.Errorf("%s: Validate() = %v", tt.name, err)
+		}
+		es := wi.Entries()
+		if len(es) != len(tt.want) {
+			t.Fatalf("%s: %d entries, want %d", tt.name, len(es), len(tt.want))
+		}
+		// 每个条目的目录字段及数据与一次性创建的条目一致
+		for i, e := range es {
+			if e.Width != tt.want[i] {
+				t.Errorf("%s: entry %d width = %d, want %d", tt.name, i, e.Width, tt.want[i])
+				continue
+			}
+			got, w := wi.icos[i], want[e.Width]
+			if got.Width != w.Width || got.Height != w.Height || got.Palette != w.Palette ||
+				got.ColorPlanes != w.ColorPlanes || got.BitsPerPixel != w.BitsPerPixel ||
+				got.ImageDataSize != w.ImageDataSize {
+				t.Errorf("%s: entry %d directory = %+v, want %+v", tt.name, i, got, w)
+			}
+			if !bytes.Equal(got.data, w.data) {
+				t.Errorf("%s: entry %d data differs", tt.name, i)
+			}
+		}
+	}
+}
+
+func TestWriter_TooLarge(t *testing.T) {
+	p, err := ioutil.ReadFile(testFile("vkico256x256@32bit.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 暂存的数据超过4GiB
+	w := NewWriter(ioutil.Discard, new(bytes.Buffer))
+	w.size = math.MaxUint32 - int64(len(p)) + 1
+	if err := w.AddPNG(bytes.NewReader(p)); err != ErrIcoTooLarge {
+		t.Errorf("AddPNG() = %v, want ErrIcoTooLarge", err)
+	}
+	if err := w.Close(); err != ErrIcoTooLarge {
+		t.Errorf("Close() = %v, want ErrIcoTooLarge", err)
+	}
+	// 数据本身不超过4GiB，加上文件头和目录后超过
+	w = NewWriter(ioutil.Discard, new(bytes.Buffer))
+	w.size = math.MaxUint32 - int64(len(p)) - 3
+	if err := w.AddPNG(bytes.NewReader(p)); err != nil {
+		t.Fatalf("AddPNG() = %v", err)
+	}
+	if err := w.Close(); err != ErrIcoTooLarge {
+		t.Errorf("Close() = %v, want ErrIcoTooLarge", err)
+	}
+}
+
+func TestWriter_Errors(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, new(bytes.Buffer))
+	if err := w.AddPNG(bytes.NewReader(make([]byte, 32))); err != ErrIcoInvalid {
+		t.Errorf("AddPNG(garbage) = %v, want ErrIcoInvalid", err)
+	}
+	if err := w.AddPNG(bytes.NewReader(PNGHEADER)); err == nil {
+		t.Errorf("AddPNG(short) = nil error")
+	}
+	if err := w.Close(); err != ErrIconsEmpty {
+		t.Errorf("Close() = %v, want ErrIconsEmpty", err)
+	}
+	if err := w.Close(); err != ErrWriterClosed {
+		t.Errorf("second Close() = %v, want ErrWriterClosed", err)
+	}
+}
+
+// failingReader 读完 r 之后返回 err 而不是 io.EOF
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestWriter_SpoolFailure(t *testing.T) {
+	b, err := ioutil.ReadFile(testFile("vkico256x256@32bit.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := io.ErrClosedPipe
+	var out bytes.Buffer
+	w := NewWriter(&out, new(bytes.Buffer))
+	if err := w.AddPNG(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	// 复制到一半失败，暂存区中留下部分数据
+	if err := w.AddPNG(&failingReader{bytes.NewReader(b[:500]), broken}); err != broken {
+		t.Fatalf("AddPNG(failing) = %v", err)
+	}
+	if err := w.AddPNG(bytes.NewReader(b)); err != broken {
+		t.Errorf("AddPNG after failure = %v, want %v", err, broken)
+	}
+	if err := w.Close(); err != broken {
+		t.Errorf("Close() = %v, want %v", err, broken)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Close() wrote %d bytes", out.Len())
+	}
+}
@@ -0,0 +1,67 @@
+// 按ETag缓存生成的响应(LRU)
+
+package icohttp
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache 以ETag为键的LRU缓存，保存生成的响应数据；并发安全
+// An LRU cache of rendered responses keyed by ETag, safe for
+// concurrent use
+type cache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List // 最近使用的在前 most recently used first
+	items map[string]*list.Element
+}
+
+type cacheItem struct {
+	key  string
+	body []byte
+}
+
+// newCache 创建最多保存 max 个响应的缓存，max <= 0 时不缓存
+// Create a cache of at most max responses; max <= 0 caches nothing
+func newCache(max int) *cache {
+	return &cache{max: max, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *cache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheItem).body, true
+}
+
+func (c *cache) add(key string, body []byte) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*cacheItem).body = body
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheItem{key, body})
+	for c.ll.Len() > c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*cacheItem).key)
+	}
+}
+
+// len 缓存的响应数
+// Number of cached responses
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,482 @@
+// Package icohttp 提供将ico图标的条目输出为PNG或ico的 http.Handler。
+//
+// Package icohttp provides an http.Handler serving an entry of an icon
+// as PNG or as a single entry icon.
+package icohttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+// 默认的限制 Default limits
+const (
+	DefaultMaxUpload    = 1 << 20 // 上传的最大字节数 largest upload in bytes
+	DefaultMaxFile      = 4 << 20 // 引用文件的最大字节数 largest referenced file in bytes
+	DefaultMaxSize      = 512     // 请求的最大像素数 largest size that can be requested
+	DefaultMaxPixels    = 1 << 20 // 解码的条目的最大像素数 most pixels of a decoded entry
+	DefaultCacheEntries = 64      // 缓存的响应数 number of cached responses
+)
+
+// 输出格式 Output formats
+const (
+	FormatPNG = "png" // image/png
+	FormatICO = "ico" // image/x-icon，只含一个条目 with a single entry
+)
+
+// contentTypes 每种输出格式接受的媒体类型，第一个用于响应
+// Media types of every format, the first one is sent
+var contentTypes = map[string][]string{
+	FormatPNG: {"image/png"},
+	FormatICO: {"image/x-icon", "image/vnd.microsoft.icon"},
+}
+
+// Options Handler 的选项，nil 或零值使用默认值
+// Options of a Handler; nil or zero fields use the defaults
+type Options struct {
+	Root         http.FileSystem // 引用的ico文件(参数path)的根目录，nil 时只接受上传
+	MaxUpload    int64           // 上传的最大字节数
+	MaxFile      int64           // 引用文件的最大字节数
+	MaxSize      int             // size*scale 的最大值
+	MaxPixels    int64           // 解码的条目的最大像素数(宽*高)，按图像数据的头在解码前检查
+	MaxAge       time.Duration   // 引用文件的响应的 Cache-Control max-age，0 时每次都要验证
+	CacheEntries int             // 缓存的响应数，小于0时不缓存
+}
+
+// Handler 将ico图标的一个条目输出为PNG或ico。
+// GET/HEAD 使用参数 path 引用 Root 中的文件；POST 上传ico文件，
+// 请求体为文件本身或 multipart/form-data 的 file 字段。
+// 参数 size(及 scale)按 ico.WinIcon.Best 选择条目并缩放，index 直接
+// 选择条目，都没有时使用最大的条目。参数 format 为 png 或 ico，
+// 没有时根据 Accept 协商。ETag 由条目的数据及参数计算。
+// Handler serves an entry of an icon as PNG or icon. GET and HEAD
+// reference a file of Root with the path parameter; POST uploads the
+// icon, either as the request body or as the file field of a
+// multipart/form-data body. The size (and scale) parameter chooses and
+// resamples an entry with ico.WinIcon.Best, index picks an entry as is,
+// and without either the largest entry is served. The format parameter
+// is png or ico; without it the format is negotiated from Accept.
+// The ETag is derived from the entry bytes and the parameters.
+type Handler struct {
+	opt   Options
+	cache *cache
+}
+
+// NewHandler 创建一个 Handler，opt 为 nil 时使用默认选项
+// Create a Handler; a nil opt uses the defaults
+func NewHandler(opt *Options) *Handler {
+	h := &Handler{}
+	if opt != nil {
+		h.opt = *opt
+	}
+	if h.opt.MaxUpload <= 0 {
+		h.opt.MaxUpload = DefaultMaxUpload
+	}
+	if h.opt.MaxFile <= 0 {
+		h.opt.MaxFile = DefaultMaxFile
+	}
+	if h.opt.MaxSize <= 0 {
+		h.opt.MaxSize = DefaultMaxSize
+	}
+	if h.opt.MaxPixels <= 0 {
+		h.opt.MaxPixels = DefaultMaxPixels
+	}
+	if h.opt.CacheEntries == 0 {
+		h.opt.CacheEntries = DefaultCacheEntries
+	}
+	h.cache = newCache(h.opt.CacheEntries)
+	return h
+}
+
+// httpError 带有状态码的错误
+// An error with its HTTP status code
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func errorf(code int, format string, args ...interface{}) error {
+	return &httpError{code, fmt.Sprintf(format, args...)}
+}
+
+// request 解析后的请求参数
+// Parsed parameters of a request
+type request struct {
+	format string
+	size   int     // 0 为没有指定
+	scale  float64 // 缩放比例
+	index  int     // -1 为没有指定
+}
+
+// key 参与ETag计算的参数
+// Parameters that go into the ETag
+func (q request) key() string {
+	return fmt.Sprintf("%s|%d|%g|%d", q.format, q.size, q.scale, q.index)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e := h.serve(w, r); e != nil {
+		code := http.StatusInternalServerError
+		if he, ok := e.(*httpError); ok {
+			code = he.code
+		}
+		http.Error(w, e.Error(), code)
+	}
+}
+
+// serve 处理请求，返回的错误由 ServeHTTP 写入响应
+// Handle a request; ServeHTTP writes a returned error
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		return errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+	w.Header().Set("Vary", "Accept")
+	q, e := h.parseRequest(r)
+	if e != nil {
+		return e
+	}
+	data, e := h.loadIcon(r)
+	if e != nil {
+		return e
+	}
+	wi, e := ico.LoadIconReaderAt(bytes.NewReader(data), int64(len(data)))
+	if e != nil {
+		return errorf(http.StatusUnsupportedMediaType, "not an icon: %v", e)
+	}
+	index := q.index
+	if index < 0 {
+		index = largest(wi)
+		if q.size > 0 {
+			index = wi.BestIndex(q.size, q.scale)
+		}
+	}
+	if index < 0 || index >= len(wi.Entries()) {
+		return errorf(http.StatusNotFound, "entry %d not found", q.index)
+	}
+	entry, e := wi.GetImageData(index)
+	if e != nil {
+		return e
+	}
+
+	// 像素检查在304之前，超过限制的条目不会因为缓存而被当作有效
+	// check the pixels before answering 304 so an entry over the limit
+	// is never confirmed as cached
+	if e := h.checkPixels(wi, index, q); e != nil {
+		return e
+	}
+	sum := sha256.Sum256(append(append([]byte{}, entry...), q.key()...))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		h.setCacheHeaders(w, r, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	body, ok := h.cache.get(etag)
+	if !ok {
+		if body, e = render(wi, index, entry, q); e != nil {
+			return e
+		}
+		h.cache.add(etag, body)
+	}
+	// 只有成功的响应带有 ETag 和 Cache-Control
+	// only successful responses carry ETag and Cache-Control
+	h.setCacheHeaders(w, r, etag)
+	w.Header().Set("Content-Type", contentTypes[q.format][0])
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	// 响应已经开始，写入错误无法再报告给客户端
+	// the response has started, a write error cannot be reported
+	w.Write(body)
+	return nil
+}
+
+// setCacheHeaders 设置 ETag 及 Cache-Control(POST的响应不设置)
+// Set ETag and Cache-Control, the latter not for POST responses
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, r *http.Request, etag string) {
+	w.Header().Set("ETag", etag)
+	if r.Method == http.MethodPost {
+		return
+	}
+	if h.opt.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.opt.MaxAge/time.Second)))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+}
+
+// parseRequest 解析参数 size、scale、index 及 format，并协商输出格式
+// Parse the size, scale, index and format parameters and negotiate
+// the output format
+func (h *Handler) parseRequest(r *http.Request) (request, error) {
+	v := r.URL.Query()
+	q := request{scale: 1, index: -1}
+	var e error
+	if s := v.Get("size"); s != "" {
+		if q.size, e = strconv.Atoi(s); e != nil || q.size <= 0 {
+			return q, errorf(http.StatusBadRequest, "invalid size %q", s)
+		}
+	}
+	if s := v.Get("scale"); s != "" {
+		if q.scale, e = strconv.ParseFloat(s, 64); e != nil || q.scale <= 0 || q.scale > 16 {
+			return q, errorf(http.StatusBadRequest, "invalid scale %q", s)
+		}
+	}
+	if s := v.Get("index"); s != "" {
+		if q.index, e = strconv.Atoi(s); e != nil || q.index < 0 {
+			return q, errorf(http.StatusBadRequest, "invalid index %q", s)
+		}
+		if q.size > 0 {
+			return q, errorf(http.StatusBadRequest, "size and index cannot be combined")
+		}
+	}
+	if q.size == 0 {
+		// 没有 size 时 scale 不起作用，不应产生不同的ETag
+		// scale means nothing without size and must not change the ETag
+		q.scale = 1
+	}
+	if float64(q.size)*q.scale > float64(h.opt.MaxSize) {
+		return q, errorf(http.StatusBadRequest, "size larger than %d", h.opt.MaxSize)
+	}
+	switch f := v.Get("format"); f {
+	case FormatPNG, FormatICO:
+		q.format = f
+	case "":
+		if q.format = negotiate(r.Header.Get("Accept")); q.format == "" {
+			return q, errorf(http.StatusNotAcceptable, "png or ico only")
+		}
+	default:
+		return q, errorf(http.StatusBadRequest, "invalid format %q", f)
+	}
+	return q, nil
+}
+
+// negotiate 根据 Accept 选择输出格式，q值相同时优先PNG；
+// 没有可接受的格式时返回空字符串
+// Choose the output format from Accept, preferring PNG on equal
+// q-values. It returns "" when no format is acceptable.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return FormatPNG
+	}
+	quality := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, e := mime.ParseMediaType(strings.TrimSpace(part))
+		if e != nil {
+			continue
+		}
+		qv := 1.0
+		if s, ok := params["q"]; ok {
+			if qv, e = strconv.ParseFloat(s, 64); e != nil {
+				continue
+			}
+		}
+		for _, f := range []string{FormatPNG, FormatICO} {
+			for _, ct := range contentTypes[f] {
+				if mt == ct || mt == "image/*" || mt == "*/*" {
+					// 具体的类型优先于通配符
+					// a specific type overrides a wildcard
+					if _, set := quality[f]; !set || mt == ct {
+						quality[f] = qv
+					}
+				}
+			}
+		}
+	}
+	best, bq := "", 0.0
+	for _, f := range []string{FormatPNG, FormatICO} {
+		if qv := quality[f]; qv > bq {
+			best, bq = f, qv
+		}
+	}
+	return best
+}
+
+// loadIcon 读取上传的或引用的ico文件的数据
+// Read the uploaded or the referenced icon
+func (h *Handler) loadIcon(r *http.Request) ([]byte, error) {
+	if r.Method == http.MethodPost {
+		body := io.Reader(r.Body)
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if ct == "multipart/form-data" {
+			mr, e := r.MultipartReader()
+			if e != nil {
+				return nil, errorf(http.StatusBadRequest, "%v", e)
+			}
+			for {
+				p, e := mr.NextPart()
+				if e == io.EOF {
+					return nil, errorf(http.StatusBadRequest, "missing file field")
+				}
+				if e != nil {
+					return nil, errorf(http.StatusBadRequest, "%v", e)
+				}
+				if p.FormName() == "file" {
+					body = p
+					break
+				}
+			}
+		}
+		return readLimited(body, h.opt.MaxUpload)
+	}
+	name := r.URL.Query().Get("path")
+	if name == "" || h.opt.Root == nil {
+		return nil, errorf(http.StatusBadRequest, "missing path parameter or upload")
+	}
+	f, e := h.opt.Root.Open(name)
+	if e != nil {
+		return nil, errorf(http.StatusNotFound, "%s not found", name)
+	}
+	defer f.Close()
+	fi, e := f.Stat()
+	if e != nil || fi.IsDir() {
+		return nil, errorf(http.StatusNotFound, "%s not found", name)
+	}
+	if fi.Size() > h.opt.MaxFile {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "%s is larger than %d bytes", name, h.opt.MaxFile)
+	}
+	return readLimited(f, h.opt.MaxFile)
+}
+
+// readLimited 最多读取 max 字节，超过时返回413错误
+// Read at most max bytes, failing with 413 beyond that
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	b, e := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if e != nil {
+		return nil, errorf(http.StatusBadRequest, "%v", e)
+	}
+	if int64(len(b)) > max {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "icon larger than %d bytes", max)
+	}
+	return b, nil
+}
+
+// largest 像素最多的条目，位深度高的优先
+// The entry with the most pixels, the deepest one first
+func largest(wi *ico.WinIcon) int {
+	best := -1
+	var bw, bb int
+	for _, e := range wi.Entries() {
+		if w := e.Width * e.Height; best < 0 || w > bw || w == bw && e.BitsPerPixel > bb {
+			best, bw, bb = e.Index, w, e.BitsPerPixel
+		}
+	}
+	return best
+}
+
+// checkPixels 在解码之前按图像数据的头检查 render 可能解码的条目：
+// 有 size 时 Best 可能尝试所有条目，否则只有 index。
+// 头无效的条目会解码失败，这里不检查
+// Check the entries render may decode against MaxPixels using the
+// headers of their image data: with size Best may try every entry,
+// otherwise only index. Entries with invalid headers fail to decode
+// anyway and are left alone here.
+func (h *Handler) checkPixels(wi *ico.WinIcon, index int, q request) error {
+	is := []int{index}
+	if q.size > 0 {
+		is = is[:0]
+		for i := range wi.Entries() {
+			is = append(is, i)
+		}
+	}
+	for _, i := range is {
+		w, ht, e := wi.ImageSize(i)
+		if e != nil {
+			continue
+		}
+		if int64(w)*int64(ht) > h.opt.MaxPixels {
+			return errorf(http.StatusRequestEntityTooLarge, "entry %d is %dx%d, more than %d pixels", i, w, ht, h.opt.MaxPixels)
+		}
+	}
+	return nil
+}
+
+// render 生成响应的数据。条目不需要缩放且以PNG存储时直接使用其数据
+// Produce the response body. A PNG entry that needs no resampling is
+// served as stored.
+func render(wi *ico.WinIcon, index int, entry []byte, q request) ([]byte, error) {
+	var img image.Image
+	if q.size > 0 {
+		i, m := wi.Best(q.size, q.scale)
+		if m == nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "no entry can be decoded")
+		}
+		if i != index {
+			// Best 跳过了无法解码的条目
+			// Best skipped an entry that does not decode
+			var e error
+			if entry, e = wi.GetImageData(i); e != nil {
+				return nil, e
+			}
+		}
+		index, img = i, m
+	} else {
+		m, e := wi.Image(index)
+		if e != nil {
+			return nil, errorf(http.StatusUnprocessableEntity, "entry %d: %v", index, e)
+		}
+		img = m
+	}
+	es := wi.Entries()[index]
+	asStored := es.Format == ico.FormatPNG && img.Bounds().Dx() == es.Width && img.Bounds().Dy() == es.Height
+
+	var buf bytes.Buffer
+	switch q.format {
+	case FormatICO:
+		iw := ico.NewWriter(&buf, new(bytes.Buffer))
+		var e error
+		if asStored {
+			e = iw.AddPNG(bytes.NewReader(entry))
+		} else {
+			e = iw.AddImage(img)
+		}
+		if e != nil {
+			return nil, e
+		}
+		if e := iw.Close(); e != nil {
+			return nil, e
+		}
+	default:
+		if asStored {
+			return entry, nil
+		}
+		if e := png.Encode(&buf, img); e != nil {
+			return nil, e
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// etagMatch If-None-Match 中是否包含 etag(弱比较)
+// Whether If-None-Match lists etag, compared weakly
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
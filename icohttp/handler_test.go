@@ -0,0 +1,420 @@
+package icohttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gemark/WinIconTools/ico"
+)
+
+func solid(size int, c color.Color) image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < len(m.Pix); i += 4 {
+		r, g, b, a := c.RGBA()
+		m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+	}
+	return m
+}
+
+// testIcon 48x48(DIB)、32x32(PNG)、16x16(DIB)三个条目的图标(最大的在前)，及32x32的PNG数据
+func testIcon(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	var p bytes.Buffer
+	if err := png.Encode(&p, solid(32, color.NRGBA{0, 0xff, 0, 0xff})); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := ico.NewWriter(&out, new(bytes.Buffer))
+	if err := w.AddImage(solid(16, color.NRGBA{0xff, 0, 0, 0xff})); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddPNG(bytes.NewReader(p.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddImage(solid(48, color.NRGBA{0, 0, 0xff, 0xff})); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), p.Bytes()
+}
+
+// testServer 测试用的服务，Close 时同时删除其 Root 目录
+type testServer struct {
+	*httptest.Server
+	dir string
+}
+
+// newTestServer 以临时目录为 Root 的服务，目录中有 testIcon 的 icon.ico
+func newTestServer(t *testing.T, opt *Options) *testServer {
+	t.Helper()
+	icon, _ := testIcon(t)
+	dir, err := ioutil.TempDir("", "icohttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "icon.ico"), icon, 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	if opt == nil {
+		opt = &Options{}
+	}
+	opt.Root = http.Dir(dir)
+	return &testServer{httptest.NewServer(NewHandler(opt)), dir}
+}
+
+func (s *testServer) Close() {
+	s.Server.Close()
+	os.RemoveAll(s.dir)
+}
+
+func get(t *testing.T, url string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return do(t, req)
+}
+
+func do(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, b
+}
+
+func decodePNG(t *testing.T, b []byte) image.Image {
+	t.Helper()
+	m, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("png.Decode() = %v", err)
+	}
+	return m
+}
+
+func TestHandler_PNG(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	_, p := testIcon(t)
+
+	// 不需要缩放的PNG条目原样输出
+	res, b := get(t, s.URL+"/icon?path=icon.ico&size=32&format=png")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("status %d, Content-Type %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if !bytes.Equal(b, p) {
+		t.Error("PNG entry not served as stored")
+	}
+
+	tests := []struct {
+		query string
+		size  int
+		c     color.NRGBA
+	}{
+		{"size=16", 16, color.NRGBA{0xff, 0, 0, 0xff}},
+		{"size=24", 24, color.NRGBA{0, 0xff, 0, 0xff}}, // 较大的32缩小
+		{"size=16&scale=1.5", 24, color.NRGBA{0, 0xff, 0, 0xff}},
+		{"size=64", 64, color.NRGBA{0, 0, 0xff, 0xff}}, // 最大的48放大
+		{"index=2", 16, color.NRGBA{0xff, 0, 0, 0xff}},
+		{"", 48, color.NRGBA{0, 0, 0xff, 0xff}}, // 没有参数时为最大的条目
+	}
+	for _, tt := range tests {
+		res, b := get(t, s.URL+"/?path=icon.ico&"+tt.query)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d %s", tt.query, res.StatusCode, b)
+			continue
+		}
+		m := decodePNG(t, b)
+		if m.Bounds().Dx() != tt.size || m.Bounds().Dy() != tt.size {
+			t.Errorf("%s: size %v, want %d", tt.query, m.Bounds(), tt.size)
+		}
+		if c := color.NRGBAModel.Convert(m.At(tt.size/2, tt.size/2)); c != tt.c {
+			t.Errorf("%s: color %v, want %v", tt.query, c, tt.c)
+		}
+	}
+}
+
+func TestHandler_ICO(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	_, p := testIcon(t)
+	for _, tt := range []struct {
+		query, accept string
+	}{
+		{"format=ico", ""},
+		{"", "image/x-icon"},
+		{"", "image/png;q=0.5, image/vnd.microsoft.icon"},
+	} {
+		res, b := get(t, s.URL+"/?path=icon.ico&size=32&"+tt.query, "Accept", tt.accept)
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/x-icon" {
+			t.Errorf("%q %q: status %d, Content-Type %q", tt.query, tt.accept, res.StatusCode, res.Header.Get("Content-Type"))
+			continue
+		}
+		if res.Header.Get("Vary") != "Accept" {
+			t.Errorf("Vary = %q", res.Header.Get("Vary"))
+		}
+		wi, err := ico.LoadIconReaderAt(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		es := wi.Entries()
+		if len(es) != 1 || es[0].Width != 32 || es[0].Format != ico.FormatPNG {
+			t.Fatalf("entries = %+v", es)
+		}
+		if d, _ := wi.GetImageData(0); !bytes.Equal(d, p) {
+			t.Error("PNG entry not copied as stored")
+		}
+	}
+}
+
+func TestHandler_Negotiate(t *testing.T) {
+	tests := []struct {
+		accept, want string
+	}{
+		{"", FormatPNG},
+		{"*/*", FormatPNG},
+		{"image/*", FormatPNG},
+		{"image/webp, image/*;q=0.8", FormatPNG},
+		{"image/x-icon", FormatICO},
+		{"image/png;q=0.1, */*;q=0.9", FormatICO},
+		{"image/png;q=0, image/*", FormatICO},
+		{"text/html", ""},
+		{"image/png;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiate(tt.accept); got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+
+	s := newTestServer(t, nil)
+	defer s.Close()
+	if res, _ := get(t, s.URL+"/?path=icon.ico", "Accept", "text/html"); res.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("status %d, want 406", res.StatusCode)
+	}
+}
+
+func TestHandler_ETag(t *testing.T) {
+	s := newTestServer(t, &Options{MaxAge: time.Hour})
+	defer s.Close()
+	res, _ := get(t, s.URL+"/?path=icon.ico&size=24")
+	etag := res.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || res.Header.Get("Cache-Control") != "public, max-age=3600" {
+		t.Fatalf("ETag %q, Cache-Control %q", etag, res.Header.Get("Cache-Control"))
+	}
+	res, b := get(t, s.URL+"/?path=icon.ico&size=24", "If-None-Match", `"x", W/`+etag)
+	if res.StatusCode != http.StatusNotModified || len(b) != 0 {
+		t.Errorf("If-None-Match: status %d", res.StatusCode)
+	}
+	// 参数不同时ETag也不同
+	for _, q := range []string{"size=16", "size=24&format=ico", "size=24&scale=2"} {
+		if res, _ := get(t, s.URL+"/?path=icon.ico&"+q); res.Header.Get("ETag") == etag {
+			t.Errorf("%s: same ETag", q)
+		}
+	}
+	// 没有 size 时 scale 不影响ETag
+	res, _ = get(t, s.URL+"/?path=icon.ico")
+	if r2, _ := get(t, s.URL+"/?path=icon.ico&scale=2"); r2.Header.Get("ETag") != res.Header.Get("ETag") {
+		t.Errorf("scale without size: ETag %q, want %q", r2.Header.Get("ETag"), res.Header.Get("ETag"))
+	}
+}
+
+func TestHandler_MaxPixels(t *testing.T) {
+	s := newTestServer(t, &Options{MaxPixels: 32 * 32, MaxAge: time.Hour})
+	defer s.Close()
+	for q, want := range map[string]int{
+		"index=2": http.StatusOK,
+		"index=1": http.StatusOK,
+		"":        http.StatusRequestEntityTooLarge, // 最大的48x48条目
+		"size=16": http.StatusRequestEntityTooLarge, // Best 可能尝试任何条目
+	} {
+		res, b := get(t, s.URL+"/?path=icon.ico&"+q)
+		if res.StatusCode != want {
+			t.Errorf("%s: status %d %s, want %d", q, res.StatusCode, b, want)
+		}
+		// 错误响应不能被缓存
+		if want != http.StatusOK && (res.Header.Get("ETag") != "" || res.Header.Get("Cache-Control") != "") {
+			t.Errorf("%s: error response has ETag %q, Cache-Control %q", q, res.Header.Get("ETag"), res.Header.Get("Cache-Control"))
+		}
+	}
+	// 没有限制时得到的ETag不能让超过限制的条目返回304
+	big := newTestServer(t, nil)
+	defer big.Close()
+	res, _ := get(t, big.URL+"/?path=icon.ico&format=png")
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("unlimited: status %d, ETag %q", res.StatusCode, etag)
+	}
+	if res, b := get(t, s.URL+"/?path=icon.ico&format=png", "If-None-Match", etag); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("If-None-Match over the limit: status %d %s, want 413", res.StatusCode, b)
+	}
+
+	// 目录与数据的尺寸不一致时按IHDR检查，不解码
+	var p bytes.Buffer
+	if err := png.Encode(&p, solid(1, color.Black)); err != nil {
+		t.Fatal(err)
+	}
+	huge := p.Bytes()
+	binary.BigEndian.PutUint32(huge[16:], 30000)
+	binary.BigEndian.PutUint32(huge[20:], 30000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	var icon bytes.Buffer
+	w := ico.NewWriter(&icon, new(bytes.Buffer))
+	if err := w.AddPNG(bytes.NewReader(huge)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = newTestServer(t, nil)
+	defer s.Close()
+	req := mustRequest(t, http.MethodPost, s.URL+"/?format=png", bytes.NewReader(icon.Bytes()), "")
+	if res, b := do(t, req); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("huge IHDR: status %d %s, want 413", res.StatusCode, b)
+	}
+}
+
+func TestHandler_Upload(t *testing.T) {
+	s := newTestServer(t, &Options{MaxUpload: 1 << 15})
+	defer s.Close()
+	icon, p := testIcon(t)
+
+	res, b := do(t, mustRequest(t, http.MethodPost, s.URL+"/?size=32", bytes.NewReader(icon), "application/octet-stream"))
+	if res.StatusCode != http.StatusOK || !bytes.Equal(b, p) {
+		t.Errorf("raw upload: status %d", res.StatusCode)
+	}
+	if res.Header.Get("Cache-Control") != "" {
+		t.Errorf("Cache-Control = %q", res.Header.Get("Cache-Control"))
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("name", "x")
+	fw, _ := mw.CreateFormFile("file", "icon.ico")
+	fw.Write(icon)
+	mw.Close()
+	res, b = do(t, mustRequest(t, http.MethodPost, s.URL+"/?index=2", &form, mw.FormDataContentType()))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("multipart upload: status %d %s", res.StatusCode, b)
+	}
+	if m := decodePNG(t, b); m.Bounds().Dx() != 16 {
+		t.Errorf("multipart upload: size %v", m.Bounds())
+	}
+
+	big := append(append([]byte{}, icon...), make([]byte, 1<<15)...)
+	if res, _ := do(t, mustRequest(t, http.MethodPost, s.URL, bytes.NewReader(big), "")); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload: status %d, want 413", res.StatusCode)
+	}
+}
+
+func mustRequest(t *testing.T, method, url string, body io.Reader, ct string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	return req
+}
+
+func TestHandler_Errors(t *testing.T) {
+	s := newTestServer(t, &Options{MaxFile: 1 << 16, MaxSize: 128})
+	defer s.Close()
+	dir := s.dir
+	ioutil.WriteFile(filepath.Join(dir, "bad.ico"), []byte("not an icon at all"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "big.ico"), make([]byte, 1<<16+1), 0644)
+
+	tests := []struct {
+		method, query string
+		status        int
+	}{
+		{http.MethodGet, "", http.StatusBadRequest},
+		{http.MethodGet, "path=missing.ico", http.StatusNotFound},
+		{http.MethodGet, "path=../handler.go", http.StatusNotFound},
+		{http.MethodGet, "path=bad.ico", http.StatusUnsupportedMediaType},
+		{http.MethodGet, "path=big.ico", http.StatusRequestEntityTooLarge},
+		{http.MethodGet, "path=icon.ico&size=x", http.StatusBadRequest},
+		{http.MethodGet, "path=icon.ico&size=256", http.StatusBadRequest},
+		{http.MethodGet, "path=icon.ico&size=64&scale=4", http.StatusBadRequest},
+		{http.MethodGet, "path=icon.ico&index=3", http.StatusNotFound},
+		{http.MethodGet, "path=icon.ico&index=0&size=16", http.StatusBadRequest},
+		{http.MethodGet, "path=icon.ico&format=gif", http.StatusBadRequest},
+		{http.MethodDelete, "path=icon.ico", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, s.URL+"/?"+tt.query, nil)
+		if res, b := do(t, req); res.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d %s, want %d", tt.method, tt.query, res.StatusCode, b, tt.status)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodHead, s.URL+"/?path=icon.ico&size=16", nil)
+	if res, b := do(t, req); res.StatusCode != http.StatusOK || len(b) != 0 || res.ContentLength <= 0 {
+		t.Errorf("HEAD: status %d, %d bytes, Content-Length %d", res.StatusCode, len(b), res.ContentLength)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := newCache(2)
+	c.add("a", []byte("1"))
+	c.add("b", []byte("2"))
+	c.get("a")
+	c.add("c", []byte("3")) // 淘汰最久未使用的b
+	if _, ok := c.get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if b, ok := c.get("a"); !ok || string(b) != "1" {
+		t.Errorf("get(a) = %q, %v", b, ok)
+	}
+	if c.len() != 2 {
+		t.Errorf("len() = %d", c.len())
+	}
+	off := newCache(-1)
+	off.add("a", nil)
+	if off.len() != 0 {
+		t.Error("disabled cache stored an entry")
+	}
+
+	// 相同的请求只生成一次
+	h := NewHandler(nil)
+	icon, _ := testIcon(t)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?size=20", bytes.NewReader(icon)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+	}
+	if h.cache.len() != 1 {
+		t.Errorf("cache holds %d responses", h.cache.len())
+	}
+}
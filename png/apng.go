@@ -0,0 +1,376 @@
+// APNG动画：解析及写入acTL、fcTL、fdAT块，合成每一帧
+
+package png
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+)
+
+// APNG 的块 APNG chunks
+const (
+	CacTL = "acTL" // 动画控制，帧数及播放次数
+	CfcTL = "fcTL" // 帧控制，区域、延迟及处理方式
+	CfdAT = "fdAT" // 帧数据，第一帧以外的图像数据
+)
+
+// DisposeOp 显示下一帧之前对当前帧区域的处理
+// What happens to the region of a frame before the next one
+type DisposeOp uint8
+
+// 帧的处理方式 Dispose operations
+const (
+	DisposeNone       DisposeOp = iota // 保留 leave the frame as is
+	DisposeBackground                  // 清除为透明 clear to transparent black
+	DisposePrevious                    // 恢复为绘制前的内容 restore what was there before
+)
+
+// BlendOp 帧与画布的混合方式
+// How a frame is blended onto the canvas
+type BlendOp uint8
+
+// 帧的混合方式 Blend operations
+const (
+	BlendSource BlendOp = iota // 替换区域内的像素 replace the pixels of the region
+	BlendOver                  // alpha 混合 alpha compositing
+)
+
+// Frame APNG 的一帧
+// A frame of an APNG
+type Frame struct {
+	Region   image.Rectangle // 帧在画布中的区域
+	DelayNum uint16          // 延迟的分子
+	DelayDen uint16          // 延迟的分母，0表示100
+	Dispose  DisposeOp       // 显示后的处理方式
+	Blend    BlendOp         // 混合方式
+	data     [][]byte        // 压缩的图像数据(IDAT或去掉序号的fdAT)
+}
+
+// Delay 帧的显示时间
+// Delay returns how long the frame is shown
+func (f Frame) Delay() time.Duration {
+	den := time.Duration(f.DelayDen)
+	if den == 0 {
+		den = 100
+	}
+	return time.Duration(f.DelayNum) * time.Second / den
+}
+
+// Animation APNG 的动画信息
+// Animation control of an APNG
+type Animation struct {
+	NumPlays      int     // 播放次数，0为无限循环 number of loops, 0 for infinite
+	HiddenDefault bool    // 默认图像(IDAT)不属于动画 the IDAT image is not a frame
+	Frames        []Frame // 所有帧
+}
+
+// parseAnimation 按顺序遍历所有块，解析acTL、fcTL及fdAT块。
+// 没有acTL块时不是APNG，img.Animation 为nil
+// Walk the chunks in order and parse acTL, fcTL and fdAT.
+// Without an acTL chunk the image is not animated and
+// img.Animation stays nil.
+func (pb PNGBODY) parseAnimation(img *PNGImage) error {
+	if pb.searchChunk(CacTL) == -1 {
+		return nil
+	}
+	hdr, e := img.GetPNGIHDR()
+	if e != nil {
+		return e
+	}
+	canvas := image.Rect(0, 0, hdr.GetWidth(), hdr.GetHeight())
+	var (
+		anim     *Animation
+		count    int
+		seq      uint32
+		seenIDAT bool
+	)
+	for o := PNGHEADSIZE; o+3*CTLENGTH <= len(pb); {
+		start := o
+		l := int(binary.BigEndian.Uint32(pb[o : o+CTLENGTH]))
+		name := string(pb[o+CTLENGTH : o+2*CTLENGTH])
+		if l < 0 || o+3*CTLENGTH+l > len(pb) {
+			return &ChunkError{Type: name, Offset: start, Kind: KindLength}
+		}
+		data := pb[o+2*CTLENGTH : o+2*CTLENGTH+l]
+		o += 3*CTLENGTH + l
+		if name == CIEND {
+			break
+		}
+		// fcTL 及 fdAT 共用从0开始连续的序号
+		// fcTL and fdAT share one sequence counting up from 0
+		checkSeq := func() error {
+			if len(data) < 4 || binary.BigEndian.Uint32(data) != seq {
+				return &ChunkError{Type: name, Offset: start, Kind: KindSequence}
+			}
+			seq++
+			return nil
+		}
+		switch name {
+		case CacTL:
+			if len(data) != 8 {
+				return errors.New(CacTL + " data length error")
+			}
+			count = int(binary.BigEndian.Uint32(data))
+			anim = &Animation{NumPlays: int(binary.BigEndian.Uint32(data[4:])), HiddenDefault: true}
+		case CfcTL:
+			if anim == nil {
+				continue
+			}
+			if len(data) != 26 {
+				return errors.New(CfcTL + " data length error")
+			}
+			if e := checkSeq(); e != nil {
+				return e
+			}
+			f := Frame{
+				DelayNum: binary.BigEndian.Uint16(data[20:]),
+				DelayDen: binary.BigEndian.Uint16(data[22:]),
+				Dispose:  DisposeOp(data[24]),
+				Blend:    BlendOp(data[25]),
+			}
+			x, y := int(binary.BigEndian.Uint32(data[12:])), int(binary.BigEndian.Uint32(data[16:]))
+			f.Region = image.Rect(x, y, x+int(binary.BigEndian.Uint32(data[4:])), y+int(binary.BigEndian.Uint32(data[8:])))
+			if f.Region.Empty() || !f.Region.In(canvas) || f.Dispose > DisposePrevious || f.Blend > BlendOver {
+				return errors.New(CfcTL + " invalid frame")
+			}
+			if len(anim.Frames) == 0 && f.Region != canvas {
+				return errors.New(CfcTL + " first frame must cover the image")
+			}
+			if !seenIDAT && len(anim.Frames) == 0 {
+				// fcTL 在IDAT之前，默认图像即第一帧
+				// fcTL before IDAT: the default image is the first frame
+				anim.HiddenDefault = false
+			}
+			anim.Frames = append(anim.Frames, f)
+		case CIDAT:
+			seenIDAT = true
+			if anim != nil && !anim.HiddenDefault && len(anim.Frames) == 1 {
+				anim.Frames[0].data = append(anim.Frames[0].data, data)
+			}
+		case CfdAT:
+			if anim == nil {
+				continue
+			}
+			if e := checkSeq(); e != nil {
+				return e
+			}
+			if len(anim.Frames) == 0 || !seenIDAT {
+				return errors.New(CfdAT + " chunk out of order")
+			}
+			fr := &anim.Frames[len(anim.Frames)-1]
+			fr.data = append(fr.data, data[4:])
+		}
+	}
+	if anim == nil {
+		return nil
+	}
+	if len(anim.Frames) != count {
+		return errors.New(CacTL + " frame count error")
+	}
+	for _, f := range anim.Frames {
+		if len(f.data) == 0 {
+			return errors.New(CfcTL + " frame without data")
+		}
+	}
+	img.Animation = anim
+	return nil
+}
+
+// DecodeFrame 解码第 i 帧，返回的图像大小为帧的区域大小
+// DecodeFrame decodes frame i; the image has the size of the
+// frame region.
+func (img *PNGImage) DecodeFrame(i int) (image.Image, error) {
+	if img.Animation == nil {
+		return nil, errors.New("not an animated png")
+	}
+	if i < 0 || i >= len(img.Animation.Frames) {
+		return nil, errors.New("frame index out of range")
+	}
+	hdr, e := img.GetPNGIHDR()
+	if e != nil {
+		return nil, e
+	}
+	ch, e := hdr.channels()
+	if e != nil {
+		return nil, e
+	}
+	f := img.Animation.Frames[i]
+	m, _, e := img.decode(hdr, ch, f.Region.Dx(), f.Region.Dy(), f.data)
+	return m, e
+}
+
+// maxCompositePixels Composite 返回的所有画面的像素总数上限(NRGBA共256MiB)
+// Limit on the pixels of all the canvases returned by Composite
+// (256 MiB of NRGBA)
+const maxCompositePixels = 1 << 26
+
+// Composite 按照每帧的混合及处理方式合成完整的画面，
+// 返回的每个图像都是显示该帧时的整个画布。每帧保留一个画布的副本，
+// 帧数乘以画布大小超过 maxCompositePixels 时返回错误，
+// 这种动画使用 CompositeFunc 逐帧处理
+// Composite renders the animation following the blend and dispose
+// operations of every frame. Each returned image is the whole
+// canvas as it looks while that frame is shown. Every frame keeps a
+// copy of the canvas, so animations whose frame count times canvas
+// size exceeds maxCompositePixels fail; use CompositeFunc for them.
+func (img *PNGImage) Composite() ([]image.Image, error) {
+	if img.Animation == nil {
+		return nil, errors.New("not an animated png")
+	}
+	hdr, e := img.GetPNGIHDR()
+	if e != nil {
+		return nil, e
+	}
+	px := int64(hdr.GetWidth()) * int64(hdr.GetHeight())
+	if px > 0 && int64(len(img.Animation.Frames)) > maxCompositePixels/px {
+		return nil, errors.New("animation too large to composite, use CompositeFunc")
+	}
+	out := make([]image.Image, 0, len(img.Animation.Frames))
+	e = img.CompositeFunc(func(i int, canvas *image.NRGBA) error {
+		snap := image.NewNRGBA(canvas.Rect)
+		copy(snap.Pix, canvas.Pix)
+		out = append(out, snap)
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	return out, nil
+}
+
+// CompositeFunc 与 Composite 相同地合成每一帧，但只使用一个画布：
+// 每帧绘制后以帧的序号和画布调用 fn，画布只在调用期间有效，之后
+// 会被下一帧修改。fn 返回的错误会停止合成并由 CompositeFunc 返回
+// CompositeFunc renders the frames like Composite but on a single
+// canvas: fn is called with the index of every frame and the canvas
+// once the frame is drawn. The canvas is only valid during the call,
+// the next frame draws over it. An error from fn stops the rendering
+// and is returned.
+func (img *PNGImage) CompositeFunc(fn func(i int, canvas *image.NRGBA) error) error {
+	if img.Animation == nil {
+		return errors.New("not an animated png")
+	}
+	hdr, e := img.GetPNGIHDR()
+	if e != nil {
+		return e
+	}
+	// 第一帧覆盖整个画布，先解码以便在分配画布之前检查尺寸
+	// the first frame covers the canvas; decoding it first checks the
+	// size before the canvas is allocated
+	first, e := img.DecodeFrame(0)
+	if e != nil {
+		return e
+	}
+	canvas := image.NewNRGBA(image.Rect(0, 0, hdr.GetWidth(), hdr.GetHeight()))
+	for i, f := range img.Animation.Frames {
+		m := first
+		if i > 0 {
+			if m, e = img.DecodeFrame(i); e != nil {
+				return e
+			}
+		}
+		dispose := f.Dispose
+		if i == 0 && dispose == DisposePrevious {
+			dispose = DisposeBackground
+		}
+		var saved *image.NRGBA
+		if dispose == DisposePrevious {
+			saved = image.NewNRGBA(f.Region)
+			draw.Draw(saved, f.Region, canvas, f.Region.Min, draw.Src)
+		}
+		op := draw.Src
+		if f.Blend == BlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, f.Region, m, image.Point{}, op)
+
+		if e := fn(i, canvas); e != nil {
+			return e
+		}
+
+		switch dispose {
+		case DisposeBackground:
+			draw.Draw(canvas, f.Region, image.Transparent, image.Point{}, draw.Src)
+		case DisposePrevious:
+			draw.Draw(canvas, f.Region, saved, f.Region.Min, draw.Src)
+		}
+	}
+	return nil
+}
+
+// EncodeAnimation 将 frames 写为8位RGBA的APNG。a.Frames[i] 为
+// frames[i] 的区域、延迟及处理方式，图像大小必须与区域相同；
+// 第一帧的区域即整个画布并同时作为默认图像(忽略 HiddenDefault)
+// EncodeAnimation writes frames as an 8 bit RGBA APNG. a.Frames[i]
+// holds the region, delay and operations of frames[i], whose size
+// must match the region. The region of the first frame is the whole
+// canvas and the first frame is also the default image, so
+// HiddenDefault is ignored.
+func EncodeAnimation(w io.Writer, a *Animation, frames []image.Image) error {
+	if a == nil || len(frames) == 0 || len(frames) != len(a.Frames) {
+		return errors.New("frames do not match the animation")
+	}
+	canvas := a.Frames[0].Region
+	if canvas.Min != (image.Point{}) || canvas.Empty() {
+		return errors.New(CfcTL + " first frame must cover the image")
+	}
+	for i, f := range a.Frames {
+		if f.Region.Empty() || !f.Region.In(canvas) || frames[i].Bounds().Size() != f.Region.Size() {
+			return errors.New(CfcTL + " invalid frame")
+		}
+	}
+
+	if _, e := w.Write(PNGHEAD); e != nil {
+		return e
+	}
+	ihdr := make([]byte, CIHDRLEN)
+	binary.BigEndian.PutUint32(ihdr, uint32(canvas.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(canvas.Dy()))
+	ihdr[8], ihdr[9] = 8, ColorRGBA
+	if e := writeChunk(w, CIHDR, ihdr); e != nil {
+		return e
+	}
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl, uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(a.NumPlays))
+	if e := writeChunk(w, CacTL, actl); e != nil {
+		return e
+	}
+	var seq uint32
+	for i, f := range a.Frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl, seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(f.Region.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(f.Region.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(f.Region.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(f.Region.Min.Y))
+		binary.BigEndian.PutUint16(fctl[20:], f.DelayNum)
+		binary.BigEndian.PutUint16(fctl[22:], f.DelayDen)
+		fctl[24], fctl[25] = byte(f.Dispose), byte(f.Blend)
+		seq++
+		if e := writeChunk(w, CfcTL, fctl); e != nil {
+			return e
+		}
+		d, e := compressNRGBA(frames[i])
+		if e != nil {
+			return e
+		}
+		if i == 0 {
+			e = writeChunk(w, CIDAT, d)
+		} else {
+			fdat := make([]byte, 4, 4+len(d))
+			binary.BigEndian.PutUint32(fdat, seq)
+			seq++
+			e = writeChunk(w, CfdAT, append(fdat, d...))
+		}
+		if e != nil {
+			return e
+		}
+	}
+	_, e := w.Write(ChunkIEND)
+	return e
+}
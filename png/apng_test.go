@@ -0,0 +1,244 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solidImage 生成单色的测试图像
+func solidImage(w, h int, c color.NRGBA) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(m.Pix); i += 4 {
+		m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return m
+}
+
+// encodeTestAnimation 写入动画并使用 LoadPNGFile 载入
+func encodeTestAnimation(t *testing.T, a *Animation, frames []image.Image) *PNGImage {
+	t.Helper()
+	var b bytes.Buffer
+	if err := EncodeAnimation(&b, a, frames); err != nil {
+		t.Fatalf("EncodeAnimation() = %v", err)
+	}
+	return loadTestPNG(t, b.Bytes())
+}
+
+func TestEncodeAnimation(t *testing.T) {
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+	blue := color.NRGBA{0, 0, 0xff, 0x80}
+	a := &Animation{
+		NumPlays: 3,
+		Frames: []Frame{
+			{Region: image.Rect(0, 0, 8, 6), DelayNum: 1, DelayDen: 10},
+			{Region: image.Rect(2, 1, 5, 4), DelayNum: 50, Blend: BlendOver, Dispose: DisposeBackground},
+		},
+	}
+	frames := []image.Image{solidImage(8, 6, red), solidImage(3, 3, blue)}
+	img := encodeTestAnimation(t, a, frames)
+	if img.Animation == nil {
+		t.Fatal("Animation = nil")
+	}
+	got := img.Animation
+	if got.NumPlays != 3 || got.HiddenDefault || len(got.Frames) != 2 {
+		t.Fatalf("Animation = %+v", got)
+	}
+	for i, f := range got.Frames {
+		w := a.Frames[i]
+		if f.Region != w.Region || f.DelayNum != w.DelayNum || f.DelayDen != w.DelayDen || f.Dispose != w.Dispose || f.Blend != w.Blend {
+			t.Errorf("Frames[%d] = %+v, want %+v", i, f, w)
+		}
+		m, err := img.DecodeFrame(i)
+		if err != nil {
+			t.Fatalf("DecodeFrame(%d) = %v", i, err)
+		}
+		if err := sameImage(m, frames[i]); err != nil {
+			t.Errorf("DecodeFrame(%d): %v", i, err)
+		}
+	}
+	if d := got.Frames[0].Delay(); d != 100*time.Millisecond {
+		t.Errorf("Frames[0].Delay() = %v", d)
+	}
+	if d := got.Frames[1].Delay(); d != 500*time.Millisecond {
+		t.Errorf("Frames[1].Delay() = %v", d)
+	}
+	// 默认图像即第一帧
+	m, err := img.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sameImage(m, frames[0]); err != nil {
+		t.Errorf("Decode(): %v", err)
+	}
+	if _, err := img.DecodeFrame(2); err == nil {
+		t.Error("DecodeFrame(2) = nil error")
+	}
+}
+
+func TestPNGImage_Composite(t *testing.T) {
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+	green := color.NRGBA{0, 0xff, 0, 0xff}
+	half := color.NRGBA{0, 0, 0xff, 0x80}
+	clear := color.NRGBA{}
+	tests := []struct {
+		name    string
+		dispose DisposeOp
+		blend   BlendOp
+		// 第二帧区域内的像素，以及第三帧时该像素的颜色
+		second, third color.NRGBA
+	}{
+		{"none/source", DisposeNone, BlendSource, half, half},
+		{"background/source", DisposeBackground, BlendSource, half, clear},
+		{"previous/source", DisposePrevious, BlendSource, half, red},
+		{"previous/over", DisposePrevious, BlendOver, color.NRGBA{0x7f, 0, 0x80, 0xff}, red},
+	}
+	for _, tt := range tests {
+		a := &Animation{Frames: []Frame{
+			{Region: image.Rect(0, 0, 4, 4)},
+			{Region: image.Rect(1, 1, 3, 3), Dispose: tt.dispose, Blend: tt.blend},
+			{Region: image.Rect(0, 0, 1, 1), Blend: BlendOver},
+		}}
+		frames := []image.Image{solidImage(4, 4, red), solidImage(2, 2, half), solidImage(1, 1, green)}
+		img := encodeTestAnimation(t, a, frames)
+		out, err := img.Composite()
+		if err != nil {
+			t.Fatalf("%s: Composite() = %v", tt.name, err)
+		}
+		if len(out) != 3 {
+			t.Fatalf("%s: %d images", tt.name, len(out))
+		}
+		at := func(i, x, y int) color.NRGBA {
+			return color.NRGBAModel.Convert(out[i].At(x, y)).(color.NRGBA)
+		}
+		if c := at(0, 1, 1); c != red {
+			t.Errorf("%s: frame 0 = %v", tt.name, c)
+		}
+		if c := at(1, 1, 1); c != tt.second {
+			t.Errorf("%s: frame 1 = %v, want %v", tt.name, c, tt.second)
+		}
+		if c := at(1, 0, 0); c != red {
+			t.Errorf("%s: frame 1 outside the region = %v", tt.name, c)
+		}
+		if c := at(2, 2, 2); c != tt.third {
+			t.Errorf("%s: frame 2 = %v, want %v", tt.name, c, tt.third)
+		}
+		if c := at(2, 0, 0); c != green {
+			t.Errorf("%s: frame 2 = %v, want %v", tt.name, c, green)
+		}
+	}
+}
+
+func TestPNGImage_CompositeFunc(t *testing.T) {
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+	a := &Animation{Frames: []Frame{
+		{Region: image.Rect(0, 0, 4, 4)},
+		{Region: image.Rect(1, 1, 3, 3)},
+		{Region: image.Rect(0, 0, 1, 1)},
+	}}
+	frames := []image.Image{solidImage(4, 4, red), solidImage(2, 2, red), solidImage(1, 1, red)}
+	img := encodeTestAnimation(t, a, frames)
+	// 所有帧使用同一个画布
+	var canvases []*image.NRGBA
+	err := img.CompositeFunc(func(i int, canvas *image.NRGBA) error {
+		if i != len(canvases) {
+			t.Errorf("frame %d called as %d", len(canvases), i)
+		}
+		canvases = append(canvases, canvas)
+		return nil
+	})
+	if err != nil || len(canvases) != 3 {
+		t.Fatalf("CompositeFunc() = %v after %d frames", err, len(canvases))
+	}
+	if canvases[0] != canvases[1] || canvases[1] != canvases[2] {
+		t.Errorf("CompositeFunc() allocated a canvas per frame")
+	}
+	// fn 的错误停止合成
+	stop := errors.New("stop")
+	n := 0
+	err = img.CompositeFunc(func(i int, canvas *image.NRGBA) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("CompositeFunc(stop) = %v after %d frames", err, n)
+	}
+	// 所有画面超过像素上限时 Composite 失败，不分配画布
+	binary.BigEndian.PutUint32(img.Chunks[0].Data[0:4], 1<<13)
+	binary.BigEndian.PutUint32(img.Chunks[0].Data[4:8], 1<<13)
+	if _, err := img.Composite(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Composite(%d frames of 8192x8192) = %v", len(a.Frames), err)
+	}
+}
+
+func TestParseAnimation_HiddenDefault(t *testing.T) {
+	// 默认图像不属于动画：IDAT 在第一个 fcTL 之前
+	def := testPNG(2, 2, ColorRGBA, 8, false, nil, nil)
+	frame := solidImage(2, 2, color.NRGBA{1, 2, 3, 4})
+	d, err := compressNRGBA(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl, 1)
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[4:], 2)
+	binary.BigEndian.PutUint32(fctl[8:], 2)
+	fdat := append(make([]byte, 4), d...)
+	binary.BigEndian.PutUint32(fdat, 1)
+
+	ihdrEnd := len(PNGHEAD) + 12 + CIHDRLEN
+	b := append([]byte{}, def[:ihdrEnd]...)
+	b = append(b, testChunk(CacTL, actl)...)
+	b = append(b, def[ihdrEnd:len(def)-len(ChunkIEND)]...)
+	b = append(b, testChunk(CfcTL, fctl)...)
+	b = append(b, testChunk(CfdAT, fdat)...)
+	b = append(b, ChunkIEND...)
+
+	img := loadTestPNG(t, b)
+	if img.Animation == nil || !img.Animation.HiddenDefault || len(img.Animation.Frames) != 1 {
+		t.Fatalf("Animation = %+v", img.Animation)
+	}
+	m, err := img.DecodeFrame(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sameImage(m, frame); err != nil {
+		t.Error(err)
+	}
+
+	// 错误的序号
+	binary.BigEndian.PutUint32(fdat, 5)
+	bad := append([]byte{}, b[:len(b)-len(ChunkIEND)-len(testChunk(CfdAT, fdat))]...)
+	bad = append(bad, testChunk(CfdAT, fdat)...)
+	bad = append(bad, ChunkIEND...)
+	if err := PNGBODY(bad).ParsePNGImage(New()); err == nil {
+		t.Error("ParsePNGImage(bad sequence) = nil error")
+	}
+}
+
+func TestEncodeAnimation_Errors(t *testing.T) {
+	m := solidImage(4, 4, color.NRGBA{})
+	tests := []struct {
+		name   string
+		a      *Animation
+		frames []image.Image
+	}{
+		{"nil", nil, []image.Image{m}},
+		{"count", &Animation{Frames: []Frame{{Region: m.Rect}}}, nil},
+		{"offset", &Animation{Frames: []Frame{{Region: image.Rect(1, 1, 5, 5)}}}, []image.Image{m}},
+		{"size", &Animation{Frames: []Frame{{Region: image.Rect(0, 0, 3, 3)}}}, []image.Image{m}},
+		{"outside", &Animation{Frames: []Frame{{Region: m.Rect}, {Region: image.Rect(2, 2, 6, 6)}}}, []image.Image{m, m}},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := EncodeAnimation(&b, tt.a, tt.frames); err == nil {
+			t.Errorf("%s: EncodeAnimation() = nil error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// PNG一致性检查：IHDR的语义、块的顺序、唯一性及名字的属性位
+
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// 块长度及图像尺寸的最大值(2^31-1) Largest chunk length and image dimension
+const maxChunkLength = 1<<31 - 1
+
+// Severity 问题的严重程度
+// How serious a Problem is
+type Severity int
+
+// 问题的严重程度 Severities
+const (
+	SeverityError   Severity = iota // 违反规范，解码器可能拒绝 violates the specification
+	SeverityWarning                 // 合法但可能有问题 legal but questionable
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Problem 一致性检查发现的问题，Offset 为块(长度字段)在文件中的
+// 偏移量，与块无关时为-1
+// A problem found by Check. Offset is where the chunk (its length
+// field) starts in the file, -1 when no chunk is involved.
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Offset   int      `json:"offset"`
+	Chunk    string   `json:"chunk,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Chunk == "" {
+		return fmt.Sprintf("%v: %s", p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%v: %s chunk at offset %d: %s", p.Severity, p.Chunk, p.Offset, p.Message)
+}
+
+// chunkRule 已知块的顺序规则
+// Ordering rules of a known chunk
+type chunkRule struct {
+	multiple   bool // 可以出现多次
+	beforePLTE bool // 必须在PLTE之前
+	beforeIDAT bool // 必须在IDAT之前
+	afterPLTE  bool // 必须在PLTE之后(如果有PLTE)
+}
+
+var chunkRules = map[string]chunkRule{
+	CIHDR:  {},
+	CPLTE:  {beforeIDAT: true},
+	CIDAT:  {multiple: true},
+	CIEND:  {},
+	CtRNS:  {beforeIDAT: true, afterPLTE: true},
+	CpHYs:  {beforeIDAT: true},
+	"bKGD": {beforeIDAT: true, afterPLTE: true},
+	"hIST": {beforeIDAT: true, afterPLTE: true},
+	"cHRM": {beforePLTE: true, beforeIDAT: true},
+	"gAMA": {beforePLTE: true, beforeIDAT: true},
+	"iCCP": {beforePLTE: true, beforeIDAT: true},
+	"sBIT": {beforePLTE: true, beforeIDAT: true},
+	"sRGB": {beforePLTE: true, beforeIDAT: true},
+	"sPLT": {multiple: true, beforeIDAT: true},
+	"eXIf": {},
+	"tIME": {},
+	"tEXt": {multiple: true},
+	"zTXt": {multiple: true},
+	"iTXt": {multiple: true},
+	CacTL:  {beforeIDAT: true},
+	CfcTL:  {multiple: true},
+	CfdAT:  {multiple: true},
+}
+
+// Validate 检查IHDR的语义：尺寸、颜色类型与位深度的组合及
+// 压缩、过滤、交错方法
+// Validate checks the semantics of the header: the dimensions, the
+// combination of color type and bit depth, and the compression,
+// filter and interlace methods.
+func (hdr *IHDR) Validate() error {
+	if hdr.width == 0 || hdr.height == 0 {
+		return errors.New(CIHDR + " zero width or height")
+	}
+	if hdr.width > maxChunkLength || hdr.height > maxChunkLength {
+		return errors.New(CIHDR + " width or height too large")
+	}
+	if _, e := hdr.channels(); e != nil {
+		return e
+	}
+	if hdr.compressionMethod != 0 {
+		return fmt.Errorf("%s invalid compression method %d", CIHDR, hdr.compressionMethod)
+	}
+	if hdr.filterMethod != 0 {
+		return fmt.Errorf("%s invalid filter method %d", CIHDR, hdr.filterMethod)
+	}
+	if hdr.interlaceMethod > 1 {
+		return fmt.Errorf("%s invalid interlace method %d", CIHDR, hdr.interlaceMethod)
+	}
+	return nil
+}
+
+// Check 读取 r 中的所有数据并检查PNG的一致性：文件头、块的长度及CRC、
+// IHDR的语义、块的顺序(IHDR第一、PLTE在IDAT之前、IDAT连续、IEND最后)、
+// 关键块的唯一性、块名字的属性位以及IEND之后的多余数据。
+// 辅助块的CRC错误只是警告。没有问题时返回 nil
+// Check reads all of r and checks the PNG for conformance: the
+// signature, chunk lengths and CRCs, the semantics of IHDR, the
+// ordering of the chunks (IHDR first, PLTE before IDAT, contiguous
+// IDATs, IEND last), the uniqueness of critical chunks, the property
+// bits of chunk names and trailing data after IEND. A CRC mismatch in
+// an ancillary chunk is only a warning. It returns nil when nothing
+// is wrong.
+func Check(r io.Reader) []Problem {
+	b, e := ioutil.ReadAll(r)
+	if e != nil {
+		return []Problem{{Offset: -1, Message: e.Error()}}
+	}
+	return PNGBODY(b).check()
+}
+
+// check 检查已载入的数据，见 Check
+// Check the loaded data, see Check
+func (pb PNGBODY) check() []Problem {
+	var ps []Problem
+	add := func(s Severity, off int, chunk, format string, args ...interface{}) {
+		ps = append(ps, Problem{Severity: s, Offset: off, Chunk: chunk, Message: fmt.Sprintf(format, args...)})
+	}
+	if len(pb) < PNGHEADSIZE || !bytes.Equal(pb[:PNGHEADSIZE], PNGHEAD) {
+		add(SeverityError, -1, "", "invalid signature")
+		return ps
+	}
+	var (
+		hdr      *IHDR
+		seen     = map[string]int{}
+		plte     = -1 // PLTE 的序号
+		idat     = -1 // 第一个IDAT的序号
+		lastIDAT = -1 // 最后一个IDAT的序号
+		n        int  // 块的序号
+		end      bool
+	)
+	o := PNGHEADSIZE
+	for ; o < len(pb) && !end; n++ {
+		if o+3*CTLENGTH > len(pb) {
+			add(SeverityError, o, "", "truncated chunk")
+			return ps
+		}
+		l := binary.BigEndian.Uint32(pb[o:])
+		name := string(pb[o+CTLENGTH : o+2*CTLENGTH])
+		if l > maxChunkLength || o+3*CTLENGTH+int(l) > len(pb) {
+			add(SeverityError, o, name, "length %d exceeds the data", l)
+			return ps
+		}
+		data := pb[o+2*CTLENGTH : o+2*CTLENGTH+int(l)]
+		crc := binary.BigEndian.Uint32(pb[o+2*CTLENGTH+int(l):])
+		if crc32.ChecksumIEEE(pb[o+CTLENGTH:o+2*CTLENGTH+int(l)]) != crc {
+			// 解码器可以忽略损坏的辅助块，只有关键块是错误
+			// decoders may skip a damaged ancillary chunk, only
+			// critical chunks are errors
+			s := SeverityError
+			if len(name) == 4 && name[0]&0x20 != 0 {
+				s = SeverityWarning
+			}
+			add(s, o, name, "crc mismatch")
+		}
+
+		for _, p := range checkName(name) {
+			add(p.Severity, o, name, p.Message)
+		}
+		rule, known := chunkRules[name]
+		if seen[name] > 0 && known && !rule.multiple {
+			add(SeverityError, o, name, "duplicate chunk")
+		}
+		seen[name]++
+		if n == 0 && name != CIHDR {
+			add(SeverityError, o, name, "first chunk is not IHDR")
+		}
+		if known && rule.beforeIDAT && idat >= 0 {
+			add(SeverityError, o, name, "must appear before IDAT")
+		}
+		if known && rule.beforePLTE && plte >= 0 {
+			add(SeverityError, o, name, "must appear before PLTE")
+		}
+		if name == CIDAT && lastIDAT >= 0 && lastIDAT != n-1 {
+			add(SeverityError, o, name, "IDAT chunks are not contiguous")
+		}
+
+		switch name {
+		case CIHDR:
+			if n != 0 {
+				break
+			}
+			if l != CIHDRLEN {
+				add(SeverityError, o, name, "data length %d, want %d", l, CIHDRLEN)
+				break
+			}
+			hdr = &IHDR{
+				width:             binary.BigEndian.Uint32(data),
+				height:            binary.BigEndian.Uint32(data[4:]),
+				bitdepth:          data[8],
+				colorType:         data[9],
+				compressionMethod: data[10],
+				filterMethod:      data[11],
+				interlaceMethod:   data[12],
+			}
+			if e := hdr.Validate(); e != nil {
+				add(SeverityError, o, name, "%v", e)
+			}
+		case CPLTE:
+			plte = n
+			if l == 0 || l%3 != 0 || l/3 > 256 {
+				add(SeverityError, o, name, "invalid palette length %d", l)
+			} else if hdr != nil && hdr.colorType == ColorPaletted && int(l/3) > 1<<hdr.bitdepth {
+				add(SeverityError, o, name, "%d entries exceed bit depth %d", l/3, hdr.bitdepth)
+			}
+			if hdr != nil && (hdr.colorType == ColorGray || hdr.colorType == ColorGrayAlpha) {
+				add(SeverityError, o, name, "not allowed for color type %d", hdr.colorType)
+			}
+		case CtRNS:
+			if hdr != nil {
+				if m := checkTRNS(hdr, len(data)); m != "" {
+					add(SeverityError, o, name, "%s", m)
+				}
+			}
+		case CIDAT:
+			if idat < 0 {
+				idat = n
+			}
+			lastIDAT = n
+		case CIEND:
+			end = true
+			if l != 0 {
+				add(SeverityError, o, name, "data length %d, want 0", l)
+			}
+		}
+		if known && rule.afterPLTE && plte < 0 && hdr != nil && hdr.colorType == ColorPaletted {
+			add(SeverityError, o, name, "must appear after PLTE")
+		}
+		o += 3*CTLENGTH + int(l)
+	}
+
+	if seen[CIHDR] == 0 {
+		add(SeverityError, -1, CIHDR, "missing")
+	}
+	if seen[CIDAT] == 0 {
+		add(SeverityError, -1, CIDAT, "missing")
+	}
+	if hdr != nil && hdr.colorType == ColorPaletted && plte < 0 {
+		add(SeverityError, -1, CPLTE, "missing for color type 3")
+	}
+	if !end {
+		add(SeverityError, -1, CIEND, "missing")
+	} else if o < len(pb) {
+		add(SeverityWarning, o, "", "%d bytes of trailing data after IEND", len(pb)-o)
+	}
+	return ps
+}
+
+// checkTRNS 检查tRNS块是否允许出现及其数据长度，没有问题时返回""
+// Check whether tRNS is allowed and its data length, "" when fine
+func checkTRNS(hdr *IHDR, l int) string {
+	switch hdr.colorType {
+	case ColorGray:
+		if l != 2 {
+			return fmt.Sprintf("data length %d, want 2", l)
+		}
+	case ColorRGB:
+		if l != 6 {
+			return fmt.Sprintf("data length %d, want 6", l)
+		}
+	case ColorPaletted:
+		if l > 1<<hdr.bitdepth {
+			return fmt.Sprintf("%d entries exceed bit depth %d", l, hdr.bitdepth)
+		}
+	default:
+		return fmt.Sprintf("not allowed for color type %d", hdr.colorType)
+	}
+	return ""
+}
+
+// checkName 检查块名字的属性位：每个字节必须是字母，第三个字节
+// (保留位)必须是大写；未知的关键块及私有的关键块解码器无法处理，
+// 关键块不应设置可安全复制位
+// Check the property bits of a chunk name: every byte must be a
+// letter and the third (reserved bit) uppercase. Decoders cannot
+// handle unknown or private critical chunks, and critical chunks
+// should not have the safe-to-copy bit set.
+func checkName(name string) []Problem {
+	var ps []Problem
+	for i := 0; i < len(name); i++ {
+		c := name[i] &^ 0x20
+		if c < 'A' || c > 'Z' {
+			return []Problem{{Severity: SeverityError, Message: fmt.Sprintf("invalid chunk name %q", name)}}
+		}
+	}
+	lower := func(i int) bool { return name[i]&0x20 != 0 }
+	ancillary, private, reserved, safe := lower(0), lower(1), lower(2), lower(3)
+	if reserved {
+		ps = append(ps, Problem{Severity: SeverityError, Message: "reserved bit set in chunk name"})
+	}
+	if ancillary {
+		return ps
+	}
+	if _, known := chunkRules[name]; private {
+		ps = append(ps, Problem{Severity: SeverityError, Message: "private critical chunk"})
+	} else if !known {
+		ps = append(ps, Problem{Severity: SeverityError, Message: "unknown critical chunk"})
+	}
+	if safe {
+		ps = append(ps, Problem{Severity: SeverityWarning, Message: "critical chunk marked safe to copy"})
+	}
+	return ps
+}
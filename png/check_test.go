@@ -0,0 +1,152 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// rebuildPNG 使用给定的块重新组成PNG数据
+func rebuildPNG(chunks ...[]byte) []byte {
+	return append(append([]byte{}, PNGHEAD...), bytes.Join(chunks, nil)...)
+}
+
+// splitChunks 将PNG数据按块拆分
+func splitChunks(b []byte) [][]byte {
+	var cs [][]byte
+	for o := PNGHEADSIZE; o < len(b); {
+		l := int(binary.BigEndian.Uint32(b[o:]))
+		cs = append(cs, b[o:o+3*CTLENGTH+l])
+		o += 3*CTLENGTH + l
+	}
+	return cs
+}
+
+// hasProblem 是否存在块 chunk 包含 msg 的问题
+func hasProblem(ps []Problem, chunk, msg string) bool {
+	for _, p := range ps {
+		if p.Chunk == chunk && strings.Contains(p.Message, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheck(t *testing.T) {
+	plte := []byte{0, 0, 0, 0xff, 0xff, 0xff}
+	good := testPNG(4, 4, ColorPaletted, 1, false, plte, []byte{0x80})
+	// IHDR, PLTE, tRNS, IDAT, IDAT, IEND
+	cs := splitChunks(good)
+	if len(cs) != 6 {
+		t.Fatalf("%d chunks", len(cs))
+	}
+	ihdr := func(w, h uint32, depth, ct, cm, fm, im uint8) []byte {
+		d := make([]byte, CIHDRLEN)
+		binary.BigEndian.PutUint32(d, w)
+		binary.BigEndian.PutUint32(d[4:], h)
+		d[8], d[9], d[10], d[11], d[12] = depth, ct, cm, fm, im
+		return testChunk(CIHDR, d)
+	}
+	badCRC := append([]byte{}, cs[2]...)
+	badCRC[len(badCRC)-1] ^= 1
+
+	tests := []struct {
+		name  string
+		b     []byte
+		chunk string
+		msg   string
+	}{
+		{"signature", []byte("GIF89a"), "", "invalid signature"},
+		{"zero width", rebuildPNG(ihdr(0, 4, 8, 0, 0, 0, 0), cs[3], cs[5]), CIHDR, "zero width"},
+		{"rgb 4bit", rebuildPNG(ihdr(4, 4, 4, ColorRGB, 0, 0, 0), cs[3], cs[5]), CIHDR, "bit depth"},
+		{"compression", rebuildPNG(ihdr(4, 4, 8, 0, 1, 0, 0), cs[3], cs[5]), CIHDR, "compression method"},
+		{"filter", rebuildPNG(ihdr(4, 4, 8, 0, 0, 2, 0), cs[3], cs[5]), CIHDR, "filter method"},
+		{"interlace", rebuildPNG(ihdr(4, 4, 8, 0, 0, 0, 2), cs[3], cs[5]), CIHDR, "interlace method"},
+		{"not first", rebuildPNG(cs[1], cs[0], cs[3], cs[5]), CPLTE, "first chunk"},
+		{"duplicate", rebuildPNG(cs[0], cs[1], cs[1], cs[3], cs[5]), CPLTE, "duplicate"},
+		{"plte after idat", rebuildPNG(cs[0], cs[3], cs[1], cs[5]), CPLTE, "before IDAT"},
+		{"missing plte", rebuildPNG(cs[0], cs[3], cs[5]), CPLTE, "missing"},
+		{"trns before plte", rebuildPNG(cs[0], cs[2], cs[1], cs[3], cs[5]), CtRNS, "after PLTE"},
+		{"gray plte", rebuildPNG(ihdr(4, 4, 8, ColorGray, 0, 0, 0), cs[1], cs[3], cs[5]), CPLTE, "not allowed"},
+		{"idat split", rebuildPNG(cs[0], cs[1], cs[3], testChunk("tEXt", []byte("a\x00b")), cs[4], cs[5]), CIDAT, "contiguous"},
+		{"no iend", rebuildPNG(cs[0], cs[1], cs[3]), CIEND, "missing"},
+		{"crc", rebuildPNG(cs[0], cs[1], badCRC, cs[3], cs[5]), CtRNS, "crc"},
+		{"trailing", append(append([]byte{}, good...), 1, 2, 3), "", "trailing data"},
+		{"truncated", good[:len(good)-20], "", ""},
+		{"unknown critical", rebuildPNG(cs[0], cs[1], testChunk("ABCD", nil), cs[3], cs[5]), "ABCD", "unknown critical"},
+		{"private critical", rebuildPNG(cs[0], cs[1], testChunk("AbCD", nil), cs[3], cs[5]), "AbCD", "private critical"},
+		{"reserved", rebuildPNG(cs[0], cs[1], testChunk("abcd", nil), cs[3], cs[5]), "abcd", "reserved bit"},
+		{"safe critical", rebuildPNG(cs[0], cs[1], testChunk("ABCd", nil), cs[3], cs[5]), "ABCd", "safe to copy"},
+		{"name", rebuildPNG(cs[0], cs[1], testChunk("ab1d", nil), cs[3], cs[5]), "ab1d", "invalid chunk name"},
+	}
+	for _, tt := range tests {
+		ps := Check(bytes.NewReader(tt.b))
+		if len(ps) == 0 {
+			t.Errorf("%s: no problems", tt.name)
+			continue
+		}
+		if tt.msg != "" && !hasProblem(ps, tt.chunk, tt.msg) {
+			t.Errorf("%s: %v", tt.name, ps)
+		}
+	}
+
+	// 辅助块的CRC错误是警告，关键块的是错误
+	badPLTE := append([]byte{}, cs[1]...)
+	badPLTE[len(badPLTE)-1] ^= 1
+	for _, tt := range []struct {
+		b    []byte
+		want Severity
+	}{
+		{rebuildPNG(cs[0], cs[1], badCRC, cs[3], cs[4], cs[5]), SeverityWarning},
+		{rebuildPNG(cs[0], badPLTE, cs[2], cs[3], cs[4], cs[5]), SeverityError},
+	} {
+		ps := Check(bytes.NewReader(tt.b))
+		if len(ps) != 1 || ps[0].Severity != tt.want {
+			t.Errorf("Check(bad crc) = %v, want severity %v", ps, tt.want)
+		}
+	}
+
+	for _, b := range [][]byte{
+		good,
+		testPNG(3, 5, ColorRGBA, 16, true, nil, nil),
+		testPNG(3, 5, ColorGray, 2, false, nil, []byte{0, 1}),
+		rebuildPNG(cs[0], testChunk("gAMA", []byte{0, 0, 0xb1, 0x8f}), cs[1], cs[2], cs[3], cs[4], testChunk("tEXt", []byte("a\x00b")), cs[5]),
+	} {
+		if ps := Check(bytes.NewReader(b)); ps != nil {
+			t.Errorf("Check(valid) = %v", ps)
+		}
+	}
+}
+
+func TestCheck_Files(t *testing.T) {
+	for _, n := range []string{"vkico256x256@32bit.png", "vkico256x256@8bit.png"} {
+		b, err := ioutil.ReadFile("../testico/" + n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ps := Check(bytes.NewReader(b)); ps != nil {
+			t.Errorf("%s: %v", n, ps)
+		}
+	}
+	b, err := ioutil.ReadFile("../testico/vkico128x128@32bit.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := Check(bytes.NewReader(b)); !hasProblem(ps, CIDAT, "crc") {
+		t.Errorf("corrupt file: %v", ps)
+	}
+}
+
+func TestGetPNGIHDR_Validate(t *testing.T) {
+	img := loadTestPNG(t, testPNG(2, 2, ColorRGB, 8, false, nil, nil))
+	img.Chunks[0].Data[9] = 5
+	if _, err := img.GetPNGIHDR(); err == nil {
+		t.Error("GetPNGIHDR(color type 5) = nil error")
+	}
+	s := Problem{Severity: SeverityWarning, Offset: 33, Chunk: CIDAT, Message: "x"}.String()
+	if s != "warning: IDAT chunk at offset 33: x" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,71 @@
+// 块错误的类型，CRC的严格、警告及修复策略
+
+package png
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// 块错误的种类 Kinds of chunk errors
+const (
+	KindCRC      = "crc"             // CRC 不匹配 CRC mismatch
+	KindLength   = "length"          // 长度超出数据 length runs past the data
+	KindMissing  = "missing"         // 必需的块不存在 required chunk not found
+	KindSequence = "sequence number" // APNG 的序号错误 APNG sequence number out of order
+)
+
+// ChunkError 块的错误，Offset 为块(长度字段)在文件中的偏移量，
+// 不存在的块为-1
+// An error of a chunk. Offset is where the chunk (its length field)
+// starts in the file, -1 for a missing chunk.
+type ChunkError struct {
+	Type   string // 块的名字 chunk type
+	Offset int    // 块的偏移量 chunk offset
+	Kind   string // 错误的种类 KindCRC, KindLength, KindMissing 或 KindSequence
+}
+
+func (e *ChunkError) Error() string {
+	if e.Kind == KindMissing {
+		return e.Type + " chunk not found"
+	}
+	return fmt.Sprintf("%s chunk %s error at offset %d", e.Type, e.Kind, e.Offset)
+}
+
+// CRCPolicy CRC 不匹配时的处理方式
+// What to do about a CRC mismatch
+type CRCPolicy int
+
+// CRC 策略 CRC policies
+const (
+	CRCStrict CRCPolicy = iota // 返回错误(默认) fail with a *ChunkError (default)
+	CRCWarn                    // 记录到 Warnings 后继续 record it in Warnings and go on
+	CRCRepair                  // 重新计算 CRC，并记录到 Warnings recompute the CRC and record it in Warnings
+)
+
+// ParseOptions 解析PNG的选项，nil 为默认选项
+// Options of parsing, nil means the defaults
+type ParseOptions struct {
+	CRC CRCPolicy // CRC 策略
+}
+
+// verify 按照 CRC 策略检查块的CRC，off 为块的偏移量
+// Check the CRC of a chunk at off following the CRC policy
+func (img *PNGImage) verify(ch *Chunk, off int) error {
+	if ch.Crc.check(ch) {
+		return nil
+	}
+	ce := &ChunkError{Type: ch.ChunkType, Offset: off, Kind: KindCRC}
+	switch img.policy {
+	case CRCWarn:
+	case CRCRepair:
+		crc := make(CRC32, CTLENGTH)
+		binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(append([]byte(ch.ChunkType), ch.Data...)))
+		ch.Crc = crc
+	default:
+		return ce
+	}
+	img.Warnings = append(img.Warnings, ce)
+	return nil
+}
@@ -0,0 +1,157 @@
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+// corruptCRC 破坏第一个名字为 name 的块的CRC，返回块的偏移量
+func corruptCRC(b []byte, name string) int {
+	o := PNGBODY(b).searchChunk(name) - CTLENGTH
+	l := int(binary.BigEndian.Uint32(b[o:]))
+	b[o+2*CTLENGTH+l] ^= 0xff
+	return o
+}
+
+func TestParsePNGImageOptions_CRC(t *testing.T) {
+	plte := []byte{0, 0, 0, 0xff, 0xff, 0xff}
+	good := testPNG(5, 3, ColorPaletted, 1, false, plte, []byte{0x80})
+	for _, name := range []string{CIHDR, CPLTE, CtRNS, CIDAT} {
+		b := append([]byte{}, good...)
+		off := corruptCRC(b, name)
+
+		// 严格：返回 *ChunkError
+		err := PNGBODY(b).ParsePNGImage(New())
+		ce, ok := err.(*ChunkError)
+		if !ok || ce.Type != name || ce.Kind != KindCRC || ce.Offset != off {
+			t.Errorf("%s: ParsePNGImage() = %#v, want crc error at %d", name, err, off)
+		}
+
+		// 警告：继续解析，记录错误
+		img := New()
+		if err := PNGBODY(b).ParsePNGImageOptions(img, &ParseOptions{CRC: CRCWarn}); err != nil {
+			t.Fatalf("%s: CRCWarn: %v", name, err)
+		}
+		if len(img.Warnings) != 1 || img.Warnings[0].Type != name || img.Warnings[0].Offset != off {
+			t.Errorf("%s: CRCWarn: Warnings = %v", name, img.Warnings)
+		}
+		if _, err := img.Decode(); err != nil {
+			t.Errorf("%s: CRCWarn: Decode() = %v", name, err)
+		}
+
+		// 修复：重新计算CRC
+		orig := append([]byte{}, b...)
+		img = New()
+		if err := PNGBODY(b).ParsePNGImageOptions(img, &ParseOptions{CRC: CRCRepair}); err != nil {
+			t.Fatalf("%s: CRCRepair: %v", name, err)
+		}
+		if len(img.Warnings) != 1 {
+			t.Errorf("%s: CRCRepair: Warnings = %v", name, img.Warnings)
+		}
+		for _, c := range img.Chunks {
+			if !c.Crc.check(c) {
+				t.Errorf("%s: CRCRepair: %s chunk crc not repaired", name, c.ChunkType)
+			}
+		}
+		if !bytes.Equal(b, orig) {
+			t.Errorf("%s: CRCRepair modified the input", name)
+		}
+	}
+}
+
+func TestParsePNGImage_ChunkErrors(t *testing.T) {
+	b := testPNG(2, 2, ColorGray, 8, false, nil, nil)
+	// 去掉IEND
+	err := PNGBODY(b[:len(b)-len(ChunkIEND)]).ParsePNGImage(New())
+	if ce, ok := err.(*ChunkError); !ok || ce.Type != CIEND || ce.Kind != KindMissing || ce.Offset != -1 {
+		t.Errorf("ParsePNGImage(no IEND) = %#v", err)
+	}
+	if err == nil || err.Error() != "IEND chunk not found" {
+		t.Errorf("Error() = %v", err)
+	}
+	// 没有PLTE及tRNS不是错误
+	img := New()
+	if err := PNGBODY(b).ParsePNGImage(img); err != nil || len(img.Warnings) != 0 {
+		t.Errorf("ParsePNGImage() = %v, Warnings = %v", err, img.Warnings)
+	}
+}
+
+func TestLoadPNGFileOptions_Corrupt(t *testing.T) {
+	// 这个文件的IDAT块的CRC是错误的
+	f, err := os.Open("../testico/vkico128x128@32bit.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := New().LoadPNGFile(f); err == nil {
+		t.Fatal("LoadPNGFile() = nil error")
+	} else if ce, ok := err.(*ChunkError); !ok || ce.Type != CIDAT || ce.Kind != KindCRC {
+		t.Fatalf("LoadPNGFile() = %#v", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	img := New()
+	if err := img.LoadPNGFileOptions(f, &ParseOptions{CRC: CRCWarn}); err != nil {
+		t.Fatalf("LoadPNGFileOptions() = %v", err)
+	}
+	if len(img.Warnings) == 0 {
+		t.Error("no warnings")
+	}
+	m, err := img.Decode()
+	if err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	if m.Bounds().Dx() != 128 || m.Bounds().Dy() != 128 {
+		t.Errorf("Decode() bounds = %v", m.Bounds())
+	}
+}
+
+// withChunks 在IHDR之后插入块
+func withChunks(b []byte, chunks ...[]byte) []byte {
+	o := PNGHEADSIZE + 3*CTLENGTH + CIHDRLEN
+	out := append([]byte{}, b[:o]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, b[o:]...)
+}
+
+func TestParsePNGImageOptions_AncillaryCRC(t *testing.T) {
+	text := testChunk("tEXt", []byte("Comment\x00hello"))
+	good := withChunks(testPNG(3, 3, ColorRGB, 8, false, nil, nil), text)
+	b := append([]byte{}, good...)
+	off := corruptCRC(b, "tEXt")
+
+	err := PNGBODY(b).ParsePNGImage(New())
+	if ce, ok := err.(*ChunkError); !ok || ce.Type != "tEXt" || ce.Kind != KindCRC || ce.Offset != off {
+		t.Errorf("ParsePNGImage() = %#v, want tEXt crc error at %d", err, off)
+	}
+
+	for _, policy := range []CRCPolicy{CRCWarn, CRCRepair} {
+		img := New()
+		if err := PNGBODY(b).ParsePNGImageOptions(img, &ParseOptions{CRC: policy}); err != nil {
+			t.Fatalf("policy %d: %v", policy, err)
+		}
+		if len(img.Warnings) != 1 || img.Warnings[0].Type != "tEXt" || img.Warnings[0].Offset != off {
+			t.Errorf("policy %d: Warnings = %v", policy, img.Warnings)
+		}
+		if _, err := img.Decode(); err != nil {
+			t.Errorf("policy %d: Decode() = %v", policy, err)
+		}
+		// 辅助块按原来的顺序写回；修复时CRC也被修复
+		var out bytes.Buffer
+		if _, err := img.WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+		want := b
+		if policy == CRCRepair {
+			want = good
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("policy %d: WriteTo() differs from the input", policy)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+// 解压IDAT数据并还原扫描线的过滤，解码为图像
+
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// 颜色类型 Color types
+const (
+	ColorGray      = 0 // 灰度
+	ColorRGB       = 2 // 真彩色
+	ColorPaletted  = 3 // 索引颜色
+	ColorGrayAlpha = 4 // 带alpha的灰度
+	ColorRGBA      = 6 // 带alpha的真彩色
+)
+
+// 扫描线的过滤类型 Scanline filter types
+const (
+	FilterNone    = 0
+	FilterSub     = 1
+	FilterUp      = 2
+	FilterAverage = 3
+	FilterPaeth   = 4
+)
+
+// adam7 Adam7 交错的7次扫描：x、y的起点和步长
+// The seven passes of Adam7 interlacing: x and y offset and step
+var adam7 = [7][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// Decode 将已解析的块解码为图像：合并并解压所有IDAT块，还原每行
+// 扫描线的过滤，支持所有颜色类型、位深度、Adam7交错及tRNS透明度。
+// 返回的图像类型与标准库 image/png 相同。
+// 每行扫描线的过滤类型记录在 Filters 中。
+// 读取完所有扫描线后即停止，不检查zlib流结尾的Adler-32校验值
+// Decode turns the parsed chunks into an image: it inflates the
+// concatenated IDAT chunks and unfilters every scanline. All color
+// types, bit depths, Adam7 interlacing and tRNS transparency are
+// supported, and the image types match those of image/png.
+// The filter type of every scanline is recorded in Filters.
+// Decoding stops after the last scanline, so the Adler-32 checksum
+// at the end of the zlib stream is not verified.
+func (img *PNGImage) Decode() (image.Image, error) {
+	hdr, e := img.GetPNGIHDR()
+	if e != nil {
+		return nil, e
+	}
+	ch, e := hdr.channels()
+	if e != nil {
+		return nil, e
+	}
+	if hdr.compressionMethod != 0 || hdr.filterMethod != 0 || hdr.interlaceMethod > 1 {
+		return nil, errors.New("unsupported compression, filter or interlace method")
+	}
+	m, f, e := img.decode(hdr, ch, hdr.GetWidth(), hdr.GetHeight(), img.idats())
+	if e != nil {
+		return nil, e
+	}
+	img.Filters = f
+	return m, nil
+}
+
+// decode 解压 data 并解码为 w*h 的图像，颜色类型和位深度由 hdr 决定，
+// 同时返回每行扫描线的过滤类型。APNG的每一帧也使用该函数解码
+// Inflate data and decode a w*h image in the color type and bit
+// depth of hdr, also returning the filter type of every scanline.
+// The frames of an APNG are decoded the same way.
+func (img *PNGImage) decode(hdr *IHDR, ch, w, h int, data [][]byte) (image.Image, []byte, error) {
+	if w <= 0 || h <= 0 {
+		return nil, nil, errors.New(CIHDR + " invalid image size")
+	}
+	bits := int(hdr.bitdepth) * ch
+	passes := [][4]int{{0, 0, 1, 1}}
+	if hdr.interlaceMethod == 1 {
+		passes = adam7[:]
+	}
+	n := 0
+	for _, d := range data {
+		n += len(d)
+	}
+	// 分配内存之前检查尺寸
+	// check the size before allocating anything
+	if e := checkSize(w, h, bits, passes, n); e != nil {
+		return nil, nil, e
+	}
+	dst, set, e := img.newImage(hdr, w, h)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	zr, e := zlib.NewReader(bytes.NewReader(bytes.Join(data, nil)))
+	if e != nil {
+		return nil, nil, e
+	}
+	defer zr.Close()
+
+	bpp := (bits + 7) / 8 // 过滤时每像素的字节数，至少为1
+	var filters []byte
+	for _, p := range passes {
+		pw := (w - p[0] + p[2] - 1) / p[2]
+		ph := (h - p[1] + p[3] - 1) / p[3]
+		if pw <= 0 || ph <= 0 {
+			continue
+		}
+		stride := (pw*bits + 7) / 8
+		cur := make([]byte, 1+stride)
+		prev := make([]byte, 1+stride)
+		for y := 0; y < ph; y++ {
+			if _, e := io.ReadFull(zr, cur); e != nil {
+				return nil, nil, e
+			}
+			if e := unfilter(cur[0], cur[1:], prev[1:], bpp); e != nil {
+				return nil, nil, e
+			}
+			filters = append(filters, cur[0])
+			for x := 0; x < pw; x++ {
+				set(p[0]+x*p[2], p[1]+y*p[3], cur[1:], x)
+			}
+			cur, prev = prev, cur
+		}
+	}
+	return dst, filters, nil
+}
+
+// FilterStats 统计 Decode 记录的各种过滤类型的扫描线数量，
+// 下标为过滤类型(FilterNone 至 FilterPaeth)
+// FilterStats counts the scanlines recorded by Decode per filter
+// type, indexed from FilterNone to FilterPaeth.
+func (img *PNGImage) FilterStats() [5]int {
+	var n [5]int
+	for _, f := range img.Filters {
+		if int(f) < len(n) {
+			n[f]++
+		}
+	}
+	return n
+}
+
+// idats 所有IDAT块的数据
+// Data of every IDAT chunk in order
+func (img *PNGImage) idats() [][]byte {
+	d := make([][]byte, len(img.IDAT))
+	for i, v := range img.IDAT {
+		d[i] = v
+	}
+	return d
+}
+
+// chunk 获取已解析的名字为 name 的第一个块，没有时返回nil
+// The first parsed chunk named name, or nil
+func (img *PNGImage) chunk(name string) *Chunk {
+	for _, c := range img.Chunks {
+		if c != nil && c.ChunkType == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// channels 根据颜色类型和位深度返回每像素的通道数，组合无效时返回错误
+// Number of channels of the color type, failing for an invalid
+// combination of color type and bit depth
+func (hdr *IHDR) channels() (int, error) {
+	d := hdr.bitdepth
+	switch hdr.colorType {
+	case ColorGray:
+		if d == 1 || d == 2 || d == 4 || d == 8 || d == 16 {
+			return 1, nil
+		}
+	case ColorPaletted:
+		if d == 1 || d == 2 || d == 4 || d == 8 {
+			return 1, nil
+		}
+	case ColorRGB:
+		if d == 8 || d == 16 {
+			return 3, nil
+		}
+	case ColorGrayAlpha:
+		if d == 8 || d == 16 {
+			return 2, nil
+		}
+	case ColorRGBA:
+		if d == 8 || d == 16 {
+			return 4, nil
+		}
+	}
+	return 0, errors.New(CIHDR + " invalid color type or bit depth")
+}
+
+// unfilter 还原一行扫描线的过滤，prev 为上一行(第一行为全0)
+// Undo the filter of a scanline; prev is the previous one, all
+// zero for the first line
+func unfilter(f byte, cur, prev []byte, bpp int) error {
+	switch f {
+	case FilterNone:
+	case FilterSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case FilterUp:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case FilterAverage:
+		for i := range cur {
+			var a int
+			if i >= bpp {
+				a = int(cur[i-bpp])
+			}
+			cur[i] += byte((a + int(prev[i])) / 2)
+		}
+	case FilterPaeth:
+		for i := range cur {
+			var a, c int
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+			cur[i] += paeth(a, int(prev[i]), c)
+		}
+	default:
+		return errors.New("invalid scanline filter type")
+	}
+	return nil
+}
+
+// paeth Paeth 预测函数
+// The Paeth predictor
+func paeth(a, b, c int) byte {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return byte(a)
+	}
+	if pb <= pc {
+		return byte(b)
+	}
+	return byte(c)
+}
+
+// abs 整数的绝对值
+// Absolute value of an int
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// setFunc 将扫描线 row 中第 i 个像素写入图像的 (x, y)
+// Store pixel i of the scanline row at (x, y) of the image
+type setFunc func(x, y int, row []byte, i int)
+
+// sample 读取扫描线中第 i 个位深度小于8的样本
+// Read sample i of a scanline with a bit depth below 8
+func sample(row []byte, i, depth int) uint8 {
+	o := i * depth
+	shift := uint(8 - depth - o%8)
+	return row[o/8] >> shift & (1<<uint(depth) - 1)
+}
+
+// maxDeflateRatio deflate 的最大压缩比，每个压缩的字节最多解压为约1032字节
+// The largest deflate ratio: a compressed byte inflates to about 1032 bytes
+const maxDeflateRatio = 1032
+
+// checkSize 检查 w*h 的图像(每像素 bits 位)能否解码：图像的像素数据
+// (每像素最多8字节)及每次扫描的扫描线不能溢出 int，解压后的扫描线
+// 也不能超过 n 字节的压缩数据可能得到的大小。这样伪造的IHDR在分配
+// 内存之前就被拒绝
+// Check that a w*h image of bits per pixel can be decoded: neither
+// the pixels (at most 8 bytes each) nor the scanlines of any pass may
+// overflow int, and the inflated scanlines cannot be larger than n
+// compressed bytes could possibly produce. A forged IHDR is thus
+// rejected before anything is allocated.
+func checkSize(w, h, bits int, passes [][4]int, n int) error {
+	const maxInt = int64(^uint(0) >> 1)
+	if int64(h) > maxInt/8/int64(w) {
+		return errors.New(CIHDR + " image size overflows")
+	}
+	var raw int64
+	for _, p := range passes {
+		pw := (int64(w) - int64(p[0]) + int64(p[2]) - 1) / int64(p[2])
+		ph := (int64(h) - int64(p[1]) + int64(p[3]) - 1) / int64(p[3])
+		if pw <= 0 || ph <= 0 {
+			continue
+		}
+		// pw*bits 最多为 2^31*64，不会溢出 int64
+		// pw*bits is at most 2^31*64 and fits int64
+		stride := (pw*int64(bits) + 7) / 8
+		if stride >= maxInt || ph > maxInt/(stride+1) {
+			return errors.New(CIHDR + " image size overflows")
+		}
+		raw += (stride + 1) * ph
+		if raw < 0 || raw > maxInt {
+			return errors.New(CIHDR + " image size overflows")
+		}
+	}
+	if raw/maxDeflateRatio > int64(n) {
+		return errors.New(CIDAT + " data too short for the image size")
+	}
+	return nil
+}
+
+// newImage 根据IHDR创建图像及写入像素的函数
+// Create the image described by the header and the function
+// storing its pixels
+func (img *PNGImage) newImage(hdr *IHDR, w, h int) (image.Image, setFunc, error) {
+	r := image.Rect(0, 0, w, h)
+	depth := int(hdr.bitdepth)
+	var trns []byte
+	if c := img.chunk(CtRNS); c != nil {
+		trns = c.Data
+	}
+	// 灰度及真彩色的 tRNS 为一个16位的颜色值
+	// tRNS of gray and truecolor images is one 16 bit color
+	key := func(i int) (uint16, bool) {
+		if len(trns) < 2*(i+1) {
+			return 0, false
+		}
+		return binary.BigEndian.Uint16(trns[2*i:]), true
+	}
+
+	switch hdr.colorType {
+	case ColorGray:
+		kv, hasKey := key(0)
+		if depth == 16 {
+			if hasKey {
+				m := image.NewNRGBA64(r)
+				return m, func(x, y int, row []byte, i int) {
+					v := binary.BigEndian.Uint16(row[2*i:])
+					a := uint16(0xffff)
+					if v == kv {
+						a = 0
+					}
+					m.SetNRGBA64(x, y, color.NRGBA64{v, v, v, a})
+				}, nil
+			}
+			m := image.NewGray16(r)
+			return m, func(x, y int, row []byte, i int) {
+				m.SetGray16(x, y, color.Gray16{binary.BigEndian.Uint16(row[2*i:])})
+			}, nil
+		}
+		scale := uint8(255 / (1<<uint(depth) - 1))
+		read := func(row []byte, i int) uint8 {
+			if depth == 8 {
+				return row[i]
+			}
+			return sample(row, i, depth)
+		}
+		if hasKey {
+			m := image.NewNRGBA(r)
+			return m, func(x, y int, row []byte, i int) {
+				s := read(row, i)
+				a := uint8(0xff)
+				if uint16(s) == kv {
+					a = 0
+				}
+				v := s * scale
+				m.SetNRGBA(x, y, color.NRGBA{v, v, v, a})
+			}, nil
+		}
+		m := image.NewGray(r)
+		return m, func(x, y int, row []byte, i int) {
+			m.SetGray(x, y, color.Gray{read(row, i) * scale})
+		}, nil
+
+	case ColorRGB:
+		kr, hasKey := key(0)
+		kg, _ := key(1)
+		kb, _ := key(2)
+		hasKey = hasKey && len(trns) >= 6
+		if depth == 16 {
+			if hasKey {
+				m := image.NewNRGBA64(r)
+				return m, func(x, y int, row []byte, i int) {
+					p := row[6*i:]
+					c := color.NRGBA64{binary.BigEndian.Uint16(p), binary.BigEndian.Uint16(p[2:]), binary.BigEndian.Uint16(p[4:]), 0xffff}
+					if c.R == kr && c.G == kg && c.B == kb {
+						c.A = 0
+					}
+					m.SetNRGBA64(x, y, c)
+				}, nil
+			}
+			m := image.NewRGBA64(r)
+			return m, func(x, y int, row []byte, i int) {
+				p := row[6*i:]
+				m.SetRGBA64(x, y, color.RGBA64{binary.BigEndian.Uint16(p), binary.BigEndian.Uint16(p[2:]), binary.BigEndian.Uint16(p[4:]), 0xffff})
+			}, nil
+		}
+		if hasKey {
+			m := image.NewNRGBA(r)
+			return m, func(x, y int, row []byte, i int) {
+				p := row[3*i:]
+				c := color.NRGBA{p[0], p[1], p[2], 0xff}
+				if uint16(c.R) == kr && uint16(c.G) == kg && uint16(c.B) == kb {
+					c.A = 0
+				}
+				m.SetNRGBA(x, y, c)
+			}, nil
+		}
+		m := image.NewRGBA(r)
+		return m, func(x, y int, row []byte, i int) {
+			p := row[3*i:]
+			m.SetRGBA(x, y, color.RGBA{p[0], p[1], p[2], 0xff})
+		}, nil
+
+	case ColorPaletted:
+		plte := img.chunk(CPLTE)
+		if plte == nil || len(plte.Data)%3 != 0 || len(plte.Data) == 0 {
+			return nil, nil, errors.New(CPLTE + " chunk missing or invalid")
+		}
+		// 与 image/png 相同，超出调色板的索引为不透明的黑色
+		// As in image/png, indexes past the palette are opaque black
+		n := len(plte.Data) / 3
+		pal := make(color.Palette, 256)
+		for i := range pal {
+			c := color.NRGBA{0, 0, 0, 0xff}
+			if i < n {
+				c.R, c.G, c.B = plte.Data[3*i], plte.Data[3*i+1], plte.Data[3*i+2]
+			}
+			if i < len(trns) {
+				c.A = trns[i]
+			}
+			pal[i] = c
+		}
+		m := image.NewPaletted(r, pal[:n])
+		return m, func(x, y int, row []byte, i int) {
+			var v uint8
+			if depth < 8 {
+				v = sample(row, i, depth)
+			} else {
+				v = row[i]
+			}
+			if int(v) >= len(m.Palette) {
+				m.Palette = pal[:int(v)+1]
+			}
+			m.SetColorIndex(x, y, v)
+		}, nil
+
+	case ColorGrayAlpha:
+		if depth == 16 {
+			m := image.NewNRGBA64(r)
+			return m, func(x, y int, row []byte, i int) {
+				v := binary.BigEndian.Uint16(row[4*i:])
+				m.SetNRGBA64(x, y, color.NRGBA64{v, v, v, binary.BigEndian.Uint16(row[4*i+2:])})
+			}, nil
+		}
+		m := image.NewNRGBA(r)
+		return m, func(x, y int, row []byte, i int) {
+			v := row[2*i]
+			m.SetNRGBA(x, y, color.NRGBA{v, v, v, row[2*i+1]})
+		}, nil
+
+	case ColorRGBA:
+		if depth == 16 {
+			m := image.NewNRGBA64(r)
+			return m, func(x, y int, row []byte, i int) {
+				p := row[8*i:]
+				m.SetNRGBA64(x, y, color.NRGBA64{binary.BigEndian.Uint16(p), binary.BigEndian.Uint16(p[2:]), binary.BigEndian.Uint16(p[4:]), binary.BigEndian.Uint16(p[6:])})
+			}, nil
+		}
+		m := image.NewNRGBA(r)
+		return m, func(x, y int, row []byte, i int) {
+			p := row[4*i:]
+			m.SetNRGBA(x, y, color.NRGBA{p[0], p[1], p[2], p[3]})
+		}, nil
+	}
+	return nil, nil, errors.New(CIHDR + " invalid color type or bit depth")
+}
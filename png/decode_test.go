@@ -0,0 +1,310 @@
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	stdpng "image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testChunk 生成一个带CRC的块
+func testChunk(name string, data []byte) []byte {
+	b := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], name)
+	b = append(b, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(b[4:]))
+	return append(b, crc...)
+}
+
+// filterRow 使用过滤类型 f 过滤一行扫描线
+func filterRow(f byte, cur, prev []byte, bpp int) []byte {
+	out := make([]byte, len(cur))
+	for i := range cur {
+		var a, b, c int
+		if i >= bpp {
+			a, c = int(cur[i-bpp]), int(prev[i-bpp])
+		}
+		b = int(prev[i])
+		switch f {
+		case FilterNone:
+			out[i] = cur[i]
+		case FilterSub:
+			out[i] = cur[i] - byte(a)
+		case FilterUp:
+			out[i] = cur[i] - byte(b)
+		case FilterAverage:
+			out[i] = cur[i] - byte((a+b)/2)
+		case FilterPaeth:
+			out[i] = cur[i] - paeth(a, b, c)
+		}
+	}
+	return out
+}
+
+// testPNG 生成测试用的PNG数据：样本值由坐标计算，
+// 每行依次使用五种过滤类型，IDAT分为两个块
+func testPNG(w, h int, ct, depth uint8, interlace bool, plte, trns []byte) []byte {
+	ch := map[uint8]int{ColorGray: 1, ColorRGB: 3, ColorPaletted: 1, ColorGrayAlpha: 2, ColorRGBA: 4}[ct]
+	bits := int(depth) * ch
+	bpp := (bits + 7) / 8
+	max := 1<<uint(depth) - 1
+	if ct == ColorPaletted {
+		max = len(plte)/3 - 1
+	}
+	passes := [][4]int{{0, 0, 1, 1}}
+	if interlace {
+		passes = adam7[:]
+	}
+	var raw []byte
+	row := 0
+	for _, p := range passes {
+		pw := (w - p[0] + p[2] - 1) / p[2]
+		ph := (h - p[1] + p[3] - 1) / p[3]
+		if pw <= 0 || ph <= 0 {
+			continue
+		}
+		stride := (pw*bits + 7) / 8
+		prev := make([]byte, stride)
+		for y := 0; y < ph; y++ {
+			cur := make([]byte, stride)
+			for x := 0; x < pw; x++ {
+				for c := 0; c < ch; c++ {
+					px, py := p[0]+x*p[2], p[1]+y*p[3]
+					s := (px*37 + py*101 + c*59) % (max + 1)
+					i := x*ch + c
+					switch depth {
+					case 16:
+						binary.BigEndian.PutUint16(cur[2*i:], uint16(s*0x0101+px))
+					case 8:
+						cur[i] = uint8(s)
+					default:
+						o := i * int(depth)
+						cur[o/8] |= uint8(s) << uint(8-int(depth)-o%8)
+					}
+				}
+			}
+			f := byte(row % 5)
+			raw = append(raw, f)
+			raw = append(raw, filterRow(f, cur, prev, bpp)...)
+			prev = cur
+			row++
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(raw)
+	zw.Close()
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr, uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9] = depth, ct
+	if interlace {
+		ihdr[12] = 1
+	}
+	b := append([]byte{}, PNGHEAD...)
+	b = append(b, testChunk(CIHDR, ihdr)...)
+	if plte != nil {
+		b = append(b, testChunk(CPLTE, plte)...)
+	}
+	if trns != nil {
+		b = append(b, testChunk(CtRNS, trns)...)
+	}
+	d := z.Bytes()
+	b = append(b, testChunk(CIDAT, d[:len(d)/2])...)
+	b = append(b, testChunk(CIDAT, d[len(d)/2:])...)
+	return append(b, ChunkIEND...)
+}
+
+// loadTestPNG 将数据写入临时文件后使用 LoadPNGFile 载入
+func loadTestPNG(t *testing.T, b []byte) *PNGImage {
+	t.Helper()
+	f, err := ioutil.TempFile("", "png*.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	img := New()
+	if err := img.LoadPNGFile(f); err != nil {
+		t.Fatalf("LoadPNGFile() = %v", err)
+	}
+	return img
+}
+
+// sameImage 比较两个图像的类型及所有像素
+func sameImage(a, b image.Image) error {
+	if fmt.Sprintf("%T", a) != fmt.Sprintf("%T", b) {
+		return fmt.Errorf("type %T, want %T", a, b)
+	}
+	if a.Bounds() != b.Bounds() {
+		return fmt.Errorf("bounds %v, want %v", a.Bounds(), b.Bounds())
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ca := color.NRGBA64Model.Convert(a.At(x, y))
+			cb := color.NRGBA64Model.Convert(b.At(x, y))
+			if ca != cb {
+				return fmt.Errorf("pixel (%d,%d) = %v, want %v", x, y, ca, cb)
+			}
+		}
+	}
+	return nil
+}
+
+func TestPNGImage_Decode(t *testing.T) {
+	plte := make([]byte, 3*200)
+	for i := range plte {
+		plte[i] = uint8(i * 7)
+	}
+	tests := []struct {
+		ct     uint8
+		depths []uint8
+		plte   []byte
+		trns   []byte
+	}{
+		{ColorGray, []uint8{1, 2, 4, 8, 16}, nil, nil},
+		{ColorGray, []uint8{1, 2, 4, 8, 16}, nil, []byte{0, 1}},
+		{ColorRGB, []uint8{8, 16}, nil, nil},
+		{ColorRGB, []uint8{8}, nil, []byte{0, 37, 0, 59, 0, 118}},
+		{ColorPaletted, []uint8{1, 2, 4, 8}, plte[:3*2], nil},
+		{ColorPaletted, []uint8{4, 8}, plte, []byte{0, 0x40, 0x80}},
+		{ColorGrayAlpha, []uint8{8, 16}, nil, nil},
+		{ColorRGBA, []uint8{8, 16}, nil, nil},
+	}
+	for _, tt := range tests {
+		for _, depth := range tt.depths {
+			for _, interlace := range []bool{false, true} {
+				for _, size := range [][2]int{{13, 11}, {1, 1}, {33, 2}} {
+					name := fmt.Sprintf("ct%d/%dbit/interlace=%v/trns=%v/%dx%d", tt.ct, depth, interlace, tt.trns != nil, size[0], size[1])
+					plte := tt.plte
+					if tt.ct == ColorPaletted && depth < 8 && len(plte) > 3<<depth {
+						plte = plte[:3<<depth]
+					}
+					b := testPNG(size[0], size[1], tt.ct, depth, interlace, plte, tt.trns)
+					want, err := stdpng.Decode(bytes.NewReader(b))
+					if err != nil {
+						t.Fatalf("%s: image/png: %v", name, err)
+					}
+					img := loadTestPNG(t, b)
+					got, err := img.Decode()
+					if err != nil {
+						t.Errorf("%s: Decode() = %v", name, err)
+						continue
+					}
+					if err := sameImage(got, want); err != nil {
+						t.Errorf("%s: %v", name, err)
+					}
+					if len(img.Filters) == 0 {
+						t.Errorf("%s: no filters recorded", name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPNGImage_DecodeFiles(t *testing.T) {
+	for _, n := range []string{"vkico256x256@32bit.png", "vkico256x256@8bit.png"} {
+		b, err := ioutil.ReadFile("../testico/" + n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := stdpng.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := loadTestPNG(t, b)
+		got, err := img.Decode()
+		if err != nil {
+			t.Fatalf("%s: Decode() = %v", n, err)
+		}
+		if err := sameImage(got, want); err != nil {
+			t.Errorf("%s: %v", n, err)
+		}
+		s := img.FilterStats()
+		if s[0]+s[1]+s[2]+s[3]+s[4] != want.Bounds().Dy() {
+			t.Errorf("%s: FilterStats() = %v for %d rows", n, s, want.Bounds().Dy())
+		}
+	}
+}
+
+func TestPNGImage_DecodeErrors(t *testing.T) {
+	b := testPNG(4, 4, ColorRGB, 8, false, nil, nil)
+	// 无效的过滤类型
+	img := loadTestPNG(t, b)
+	img.IDAT = IDATS{ImageData(zlibBytes([]byte{9, 0, 0, 0}))}
+	if _, err := img.Decode(); err == nil {
+		t.Errorf("Decode(filter 9) = nil error")
+	}
+	// 数据不足
+	img = loadTestPNG(t, b)
+	img.IDAT = img.IDAT[:1]
+	if _, err := img.Decode(); err == nil {
+		t.Errorf("Decode(truncated) = nil error")
+	}
+	// 无效的位深度
+	img = loadTestPNG(t, b)
+	img.Chunks[0].Data[8] = 4
+	if _, err := img.Decode(); err == nil {
+		t.Errorf("Decode(RGB 4bit) = nil error")
+	}
+}
+
+func TestPNGImage_DecodeHugeHeader(t *testing.T) {
+	tests := []struct {
+		w, h  uint32
+		depth uint8
+		ct    uint8
+	}{
+		{1 << 30, 1 << 30, 8, ColorRGBA},      // 像素数据溢出 int
+		{1<<31 - 1, 1<<31 - 1, 16, ColorRGBA}, // 扫描线溢出 int
+		{1 << 30, 1, 16, ColorRGBA},           // 一行扫描线远大于数据可能解压的大小
+		{30000, 30000, 8, ColorRGBA},          // 解压炸弹
+	}
+	for _, tt := range tests {
+		ihdr := make([]byte, 13)
+		binary.BigEndian.PutUint32(ihdr, tt.w)
+		binary.BigEndian.PutUint32(ihdr[4:], tt.h)
+		ihdr[8], ihdr[9] = tt.depth, tt.ct
+		b := append([]byte{}, PNGHEAD...)
+		b = append(b, testChunk(CIHDR, ihdr)...)
+		b = append(b, testChunk(CIDAT, zlibBytes(make([]byte, 1024)))...)
+		b = append(b, testChunk(CIEND, nil)...)
+		if len(b) > 200 {
+			t.Fatalf("test PNG has %d bytes", len(b))
+		}
+		img, err := Parse(b)
+		if err != nil {
+			t.Fatalf("%dx%d: Parse() = %v", tt.w, tt.h, err)
+		}
+		if _, err := img.Decode(); err == nil {
+			t.Errorf("%dx%d: Decode() = nil error", tt.w, tt.h)
+		}
+	}
+}
+
+// zlibBytes 压缩数据
+func zlibBytes(b []byte) []byte {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(b)
+	zw.Close()
+	return z.Bytes()
+}
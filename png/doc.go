@@ -0,0 +1,20 @@
+// Package png 按块解析、检查、解码及编辑PNG图像。
+//
+//   - 载入：Parse、Read、ParseHeader、PNGImage.LoadPNGFileOptions
+//   - 检查：Check、IHDR.Validate，CRC 策略见 ParseOptions
+//   - 解码：PNGImage.Decode，APNG 见 DecodeFrame、Composite
+//   - 编辑及写入：IHDR 的设置函数、PNGImage.WriteTo、EncodeAnimation
+//
+// 导出的名字保持兼容；被替代的名字标记为 Deprecated 并继续可用。
+//
+// Package png parses, checks, decodes and edits PNG images chunk by chunk.
+//
+//   - Loading: Parse, Read, ParseHeader, PNGImage.LoadPNGFileOptions.
+//   - Checking: Check, IHDR.Validate; see ParseOptions for the CRC policy.
+//   - Decoding: PNGImage.Decode, and DecodeFrame and Composite for APNG.
+//   - Editing and writing: the IHDR setters, PNGImage.WriteTo and
+//     EncodeAnimation.
+//
+// Exported names stay compatible; replaced names are marked Deprecated
+// and keep working.
+package png
@@ -0,0 +1,265 @@
+// 写入PNG块，按颜色类型及位深度编码、过滤并压缩图像数据
+
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// writeChunk 写入一个块：长度、名字、数据及CRC
+// Write a chunk: length, name, data and CRC
+func writeChunk(w io.Writer, name string, data []byte) error {
+	b := make([]byte, 2*CTLENGTH, 3*CTLENGTH+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[CTLENGTH:], name)
+	b = append(b, data...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-CTLENGTH:], crc32.ChecksumIEEE(b[CTLENGTH:len(b)-CTLENGTH]))
+	_, e := w.Write(b)
+	return e
+}
+
+// filterLine 使用过滤类型 f 过滤一行扫描线，结果写入 out
+// Filter a scanline with the filter type f into out
+func filterLine(f byte, cur, prev, out []byte, bpp int) {
+	for i := range cur {
+		var a, c int
+		if i >= bpp {
+			a, c = int(cur[i-bpp]), int(prev[i-bpp])
+		}
+		b := int(prev[i])
+		switch f {
+		case FilterNone:
+			out[i] = cur[i]
+		case FilterSub:
+			out[i] = cur[i] - byte(a)
+		case FilterUp:
+			out[i] = cur[i] - byte(b)
+		case FilterAverage:
+			out[i] = cur[i] - byte((a+b)/2)
+		case FilterPaeth:
+			out[i] = cur[i] - paeth(a, b, c)
+		}
+	}
+}
+
+// filterCost 过滤结果的代价：按有符号字节计算的绝对值之和
+// Cost of a filtered line: the sum of its bytes as signed magnitudes
+func filterCost(b []byte) int {
+	n := 0
+	for _, v := range b {
+		n += abs(int(int8(v)))
+	}
+	return n
+}
+
+// makeChunk 创建一个块并计算其CRC
+// Create a chunk with its CRC
+func makeChunk(name string, data []byte) *Chunk {
+	crc := make(CRC32, CTLENGTH)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(append([]byte(name), data...)))
+	return NewChunk(len(data), name, ChunkData(data), crc)
+}
+
+// writeFiltered 使用代价最小的过滤类型过滤一行扫描线，写入过滤类型及结果
+// Filter a scanline with the cheapest filter type and write the
+// filter type followed by the filtered bytes
+func writeFiltered(w io.Writer, cur, prev []byte, bpp int) error {
+	best := make([]byte, 1+len(cur))
+	try := make([]byte, len(cur))
+	cost := -1
+	for f := byte(FilterNone); f <= FilterPaeth; f++ {
+		filterLine(f, cur, prev, try, bpp)
+		if c := filterCost(try); cost < 0 || c < cost {
+			cost = c
+			best[0] = f
+			copy(best[1:], try)
+		}
+	}
+	_, e := w.Write(best)
+	return e
+}
+
+// compressNRGBA 将图像转换为8位RGBA(颜色类型6)的扫描线，每行选择
+// 代价最小的过滤类型，返回zlib压缩的数据
+// Turn the image into 8 bit RGBA scanlines (color type 6), each
+// filtered with the cheapest filter type, and return them deflated
+func compressNRGBA(img image.Image) ([]byte, error) {
+	r := img.Bounds()
+	m, ok := img.(*image.NRGBA)
+	if !ok || m.Rect.Min != (image.Point{}) {
+		m = image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(m, m.Rect, img, r.Min, draw.Src)
+	}
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	stride := 4 * w
+	prev := make([]byte, stride)
+	var z bytes.Buffer
+	zw, e := zlib.NewWriterLevel(&z, zlib.BestCompression)
+	if e != nil {
+		return nil, e
+	}
+	for y := 0; y < h; y++ {
+		cur := m.Pix[y*m.Stride : y*m.Stride+stride]
+		if e := writeFiltered(zw, cur, prev, 4); e != nil {
+			return nil, e
+		}
+		prev = cur
+	}
+	if e := zw.Close(); e != nil {
+		return nil, e
+	}
+	return z.Bytes(), nil
+}
+
+// encodeImage 按照 hdr 的颜色类型、位深度及交错方法编码图像，
+// 返回zlib压缩的图像数据，以及颜色类型3所需的PLTE和tRNS数据。
+// 样本值按位深度取整；转换为灰度时使用与 image/color 相同的亮度公式；
+// 没有alpha通道的颜色类型(0、2)不接受含有透明像素的图像，
+// 颜色类型3的图像颜色数不能超过 2^位深度
+// Encode m in the color type, bit depth and interlace method of hdr,
+// returning the deflated image data and, for color type 3, the data
+// of PLTE and tRNS. Samples are rounded to the bit depth and gray
+// uses the luma formula of image/color. Color types without alpha
+// (0 and 2) refuse images with transparent pixels, and a paletted
+// image may not have more than 2^depth colors.
+func encodeImage(m image.Image, hdr *IHDR) (data, plte, trns []byte, e error) {
+	ch, e := hdr.channels()
+	if e != nil {
+		return nil, nil, nil, e
+	}
+	r := m.Bounds()
+	w, h := r.Dx(), r.Dy()
+	if w != hdr.GetWidth() || h != hdr.GetHeight() {
+		return nil, nil, nil, errors.New("image size does not match " + CIHDR)
+	}
+	depth := int(hdr.bitdepth)
+	maxv := uint32(1)<<uint(depth) - 1
+	q := func(v uint16) uint16 {
+		return uint16((uint32(v)*maxv + 0x7fff) / 0xffff)
+	}
+	var index map[color.NRGBA]int
+	switch hdr.colorType {
+	case ColorPaletted:
+		index, plte, trns, e = buildPalette(m, 1<<uint(depth))
+		if e != nil {
+			return nil, nil, nil, e
+		}
+	case ColorGray, ColorRGB:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+					return nil, nil, nil, fmt.Errorf("image has transparent pixels, color type %d has no alpha", hdr.colorType)
+				}
+			}
+		}
+	}
+	// sample 取得 (x, y) 处按位深度取整的样本值
+	sample := func(x, y int, s []uint16) {
+		c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+		gray := uint16((19595*uint32(c.R) + 38470*uint32(c.G) + 7471*uint32(c.B) + 1<<15) >> 16)
+		switch hdr.colorType {
+		case ColorGray:
+			s[0] = q(gray)
+		case ColorGrayAlpha:
+			s[0], s[1] = q(gray), q(c.A)
+		case ColorRGB:
+			s[0], s[1], s[2] = q(c.R), q(c.G), q(c.B)
+		case ColorRGBA:
+			s[0], s[1], s[2], s[3] = q(c.R), q(c.G), q(c.B), q(c.A)
+		case ColorPaletted:
+			s[0] = uint16(index[color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)])
+		}
+	}
+
+	passes := [][4]int{{0, 0, 1, 1}}
+	if hdr.interlaceMethod == 1 {
+		passes = adam7[:]
+	}
+	bits := depth * ch
+	bpp := (bits + 7) / 8
+	s := make([]uint16, ch)
+	var z bytes.Buffer
+	zw, e := zlib.NewWriterLevel(&z, zlib.BestCompression)
+	if e != nil {
+		return nil, nil, nil, e
+	}
+	for _, p := range passes {
+		pw := (w - p[0] + p[2] - 1) / p[2]
+		ph := (h - p[1] + p[3] - 1) / p[3]
+		if pw <= 0 || ph <= 0 {
+			continue
+		}
+		stride := (pw*bits + 7) / 8
+		prev := make([]byte, stride)
+		for y := 0; y < ph; y++ {
+			cur := make([]byte, stride)
+			for x := 0; x < pw; x++ {
+				sample(r.Min.X+p[0]+x*p[2], r.Min.Y+p[1]+y*p[3], s)
+				for c, v := range s {
+					i := x*ch + c
+					switch depth {
+					case 16:
+						binary.BigEndian.PutUint16(cur[2*i:], v)
+					case 8:
+						cur[i] = uint8(v)
+					default:
+						o := i * depth
+						cur[o/8] |= uint8(v) << uint(8-depth-o%8)
+					}
+				}
+			}
+			if e := writeFiltered(zw, cur, prev, bpp); e != nil {
+				return nil, nil, nil, e
+			}
+			prev = cur
+		}
+	}
+	if e := zw.Close(); e != nil {
+		return nil, nil, nil, e
+	}
+	return z.Bytes(), plte, trns, nil
+}
+
+// buildPalette 收集图像中所有的颜色作为调色板，颜色数超过 n 时返回错误。
+// 返回颜色的索引、PLTE数据及tRNS数据(全部不透明时为nil)
+// Collect the colors of m into a palette of at most n entries and
+// return the index of every color, the PLTE data and the tRNS data
+// (nil when every color is opaque).
+func buildPalette(m image.Image, n int) (map[color.NRGBA]int, []byte, []byte, error) {
+	index := make(map[color.NRGBA]int)
+	var plte, trns []byte
+	r := m.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if _, ok := index[c]; ok {
+				continue
+			}
+			if len(index) == n {
+				return nil, nil, nil, fmt.Errorf("image has more than %d colors", n)
+			}
+			index[c] = len(index)
+			plte = append(plte, c.R, c.G, c.B)
+			trns = append(trns, c.A)
+		}
+	}
+	// tRNS 末尾的不透明项可以省略
+	// trailing opaque entries of tRNS may be left out
+	for len(trns) > 0 && trns[len(trns)-1] == 0xff {
+		trns = trns[:len(trns)-1]
+	}
+	if len(trns) == 0 {
+		trns = nil
+	}
+	return index, plte, trns, nil
+}
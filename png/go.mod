@@ -1,3 +0,0 @@
-module ImageTools/png
-
-go 1.12
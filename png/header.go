@@ -0,0 +1,137 @@
+// 可编辑的IHDR：修改写回IHDR块，必要时转换像素数据；重新序列化PNG
+
+package png
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// bytes IHDR块的13字节数据
+// The 13 data bytes of the IHDR chunk
+func (hdr *IHDR) bytes() []byte {
+	d := make([]byte, CIHDRLEN)
+	binary.BigEndian.PutUint32(d, hdr.width)
+	binary.BigEndian.PutUint32(d[4:], hdr.height)
+	d[8], d[9], d[10], d[11], d[12] = hdr.bitdepth, hdr.colorType, hdr.compressionMethod, hdr.filterMethod, hdr.interlaceMethod
+	return d
+}
+
+// apply 修改IHDR。由 GetPNGIHDR 返回的IHDR，修改后必须有效并写回
+// 图像的IHDR块，否则返回错误且不做修改；其他IHDR只修改其本身
+// Apply f to the header. For a header returned by GetPNGIHDR the
+// result must be valid and is written back into the IHDR chunk of
+// the image, otherwise nothing changes. Any other header is simply
+// changed.
+func (hdr *IHDR) apply(f func(h *IHDR)) error {
+	n := *hdr
+	f(&n)
+	if hdr.img != nil {
+		if e := n.Validate(); e != nil {
+			return e
+		}
+		if e := hdr.img.setHeader(&n); e != nil {
+			return e
+		}
+	}
+	*hdr = n
+	return nil
+}
+
+// setHeader 将 hdr 写回IHDR块并重新计算CRC。位深度、颜色类型或
+// 交错方法改变时，先解码图像再按新的格式重新编码(同时替换PLTE、
+// tRNS及IDAT块)；按PNG规范只保留可安全复制(名字第4个字母为小写)
+// 的辅助块。尺寸不能改变，APNG不能转换
+// Write hdr back into the IHDR chunk with a new CRC. When the bit
+// depth, color type or interlace method changes, the image is decoded
+// and encoded again in the new format, replacing PLTE, tRNS and the
+// IDAT chunks; as the PNG specification requires, only the ancillary
+// chunks that are safe to copy (lower case fourth letter) are kept.
+// The size cannot change and an APNG cannot be converted.
+func (img *PNGImage) setHeader(hdr *IHDR) error {
+	old, e := img.GetPNGIHDR()
+	if e != nil {
+		return e
+	}
+	if hdr.width != old.width || hdr.height != old.height {
+		return errors.New(CIHDR + " size cannot change without new image data")
+	}
+	chunks := img.Chunks
+	if hdr.bitdepth != old.bitdepth || hdr.colorType != old.colorType || hdr.interlaceMethod != old.interlaceMethod {
+		if img.Animation != nil {
+			return errors.New("cannot convert the pixels of an animated png")
+		}
+		m, e := img.Decode()
+		if e != nil {
+			return e
+		}
+		data, plte, trns, e := encodeImage(m, hdr)
+		if e != nil {
+			return e
+		}
+		img.IDAT = IDATS{ImageData(data)}
+		img.Filters = nil
+		chunks = Chunks{nil}
+		for _, c := range img.Chunks[1:] {
+			switch c.ChunkType {
+			case CPLTE, CtRNS:
+			case CIDAT:
+				if data == nil {
+					continue
+				}
+				if plte != nil {
+					chunks = append(chunks, makeChunk(CPLTE, plte))
+				}
+				if trns != nil {
+					chunks = append(chunks, makeChunk(CtRNS, trns))
+				}
+				chunks = append(chunks, makeChunk(CIDAT, data))
+				data = nil
+			default:
+				if c.ChunkType == CIEND || safeToCopy(c.ChunkType) {
+					chunks = append(chunks, c)
+				}
+			}
+		}
+	}
+	chunks[0] = makeChunk(CIHDR, hdr.bytes())
+	img.Chunks = chunks
+	return nil
+}
+
+// safeToCopy 辅助块的名字第4个字母为小写时，修改关键块后仍可复制
+// Whether the ancillary chunk name, by its lower case fourth letter,
+// may be copied after the critical chunks changed
+func safeToCopy(name string) bool {
+	return len(name) == 4 && name[0]&0x20 != 0 && name[3]&0x20 != 0
+}
+
+// WriteTo 将文件头及 Chunks 中的所有块(包括辅助块)按顺序写入 w，
+// 实现 io.WriterTo
+// WriteTo writes the file header and every chunk in Chunks, ancillary
+// ones included, to w in order, implementing io.WriterTo.
+func (img *PNGImage) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	h := img.FileHeader
+	if h == nil {
+		h = PNGHEAD
+	}
+	c, e := w.Write(h)
+	n += int64(c)
+	if e != nil {
+		return n, e
+	}
+	for _, ch := range img.Chunks {
+		b := make([]byte, 2*CTLENGTH, 3*CTLENGTH+len(ch.Data))
+		binary.BigEndian.PutUint32(b, uint32(len(ch.Data)))
+		copy(b[CTLENGTH:], ch.ChunkType)
+		b = append(append(b, ch.Data...), ch.Crc...)
+		c, e := w.Write(b)
+		n += int64(c)
+		if e != nil {
+			return n, e
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,179 @@
+package png
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	stdpng "image/png"
+	"os"
+	"testing"
+)
+
+// reloadPNG 使用 WriteTo 序列化后重新载入，并确认标准库也能解码
+func reloadPNG(t *testing.T, img *PNGImage) (*PNGImage, image.Image) {
+	t.Helper()
+	var b bytes.Buffer
+	if _, err := img.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() = %v", err)
+	}
+	std, err := stdpng.Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("image/png: %v", err)
+	}
+	if ps := Check(bytes.NewReader(b.Bytes())); ps != nil {
+		t.Fatalf("Check() = %v", ps)
+	}
+	return loadTestPNG(t, b.Bytes()), std
+}
+
+func TestIHDR_SetWriteBack(t *testing.T) {
+	tests := []struct {
+		ct, depth uint8
+		set       func(h *IHDR) error
+		ct2       int
+		depth2    int
+	}{
+		{ColorRGBA, 8, func(h *IHDR) error { return h.SetBits(16) }, ColorRGBA, 16},
+		{ColorRGBA, 8, func(h *IHDR) error { return h.SetInterlaceMethod(1) }, ColorRGBA, 8},
+		{ColorRGB, 16, func(h *IHDR) error { return h.SetBits(8) }, ColorRGB, 8},
+		{ColorGray, 4, func(h *IHDR) error { return h.SetBits(8) }, ColorGray, 8},
+		{ColorGray, 8, func(h *IHDR) error { return h.SetColorType(ColorRGB) }, ColorRGB, 8},
+		{ColorGray, 8, func(h *IHDR) error { return h.SetColorType(ColorGrayAlpha) }, ColorGrayAlpha, 8},
+		{ColorGrayAlpha, 16, func(h *IHDR) error { return h.SetColorType(ColorRGBA) }, ColorRGBA, 16},
+		{ColorGray, 2, func(h *IHDR) error { return h.SetColorType(ColorPaletted) }, ColorPaletted, 2},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("ct%d/%dbit->ct%d/%dbit", tt.ct, tt.depth, tt.ct2, tt.depth2)
+		img := loadTestPNG(t, testPNG(7, 5, tt.ct, tt.depth, false, nil, nil))
+		want, err := img.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := img.GetPNGIHDR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.set(hdr); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := img.GetPNGIHDR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.GetColorType() != tt.ct2 || got.GetBits() != tt.depth2 || *got != *hdr {
+			t.Errorf("%s: IHDR = %+v", name, got)
+		}
+		if !img.Chunks[0].Crc.check(img.Chunks[0]) {
+			t.Errorf("%s: IHDR crc not updated", name)
+		}
+		re, std := reloadPNG(t, img)
+		m, err := re.Decode()
+		if err != nil {
+			t.Fatalf("%s: Decode() = %v", name, err)
+		}
+		if err := sameImage(m, std); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// 位深度降低以外的转换是无损的(灰度的颜色本身就相同)
+		if tt.depth2 >= int(tt.depth) {
+			r := want.Bounds()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					a := color.NRGBA64Model.Convert(want.At(x, y)).(color.NRGBA64)
+					b := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+					if a != b {
+						t.Fatalf("%s: pixel (%d,%d) = %v, want %v", name, x, y, b, a)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestIHDR_SetRejected(t *testing.T) {
+	b := testPNG(4, 4, ColorRGBA, 8, false, nil, nil)
+	img := loadTestPNG(t, b)
+	hdr, err := img.GetPNGIHDR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := *hdr
+	tests := []struct {
+		name string
+		set  func() error
+	}{
+		{"width", func() error { return hdr.SetWidth(8) }},
+		{"height", func() error { return hdr.SetHeight(0) }},
+		{"negative", func() error { return hdr.SetWidth(-1) }},
+		{"bits", func() error { return hdr.SetBits(4) }},
+		{"color type", func() error { return hdr.SetColorType(5) }},
+		{"range", func() error { return hdr.SetBits(264) }},
+		{"compression", func() error { return hdr.SetCompressionMethod(1) }},
+		{"filter", func() error { return hdr.SetFilterMethod(1) }},
+		// 有透明像素的图像不能转换为没有alpha的颜色类型
+		{"alpha", func() error { return hdr.SetColorType(ColorRGB) }},
+	}
+	for _, tt := range tests {
+		if err := tt.set(); err == nil {
+			t.Errorf("%s: nil error", tt.name)
+		}
+		if *hdr != before {
+			t.Errorf("%s: IHDR changed to %+v", tt.name, hdr)
+		}
+	}
+	var out bytes.Buffer
+	if _, err := img.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Error("rejected changes modified the image")
+	}
+
+	// 颜色数超过调色板
+	f, err := os.Open("../testico/vkico256x256@32bit.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	icon := New()
+	if err := icon.LoadPNGFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if hdr, err = icon.GetPNGIHDR(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hdr.SetColorType(ColorPaletted); err == nil {
+		t.Error("SetColorType(ColorPaletted) = nil error")
+	}
+
+	// 没有所属图像的IHDR只修改其本身
+	var h IHDR
+	if err := h.SetWidth(3); err != nil || h.GetWidth() != 3 {
+		t.Errorf("detached SetWidth() = %v, width %d", err, h.GetWidth())
+	}
+}
+
+func TestIHDR_SetKeepsSafeChunks(t *testing.T) {
+	b := withChunks(testPNG(4, 4, ColorRGB, 8, false, nil, nil),
+		testChunk("gAMA", []byte{0, 0, 0xb1, 0x8f}), testChunk("tEXt", []byte("Title\x00x")))
+	b = append(b[:len(b)-len(ChunkIEND)], append(testChunk("zTXt", []byte("k\x00\x00")), ChunkIEND...)...)
+	img := loadTestPNG(t, b)
+	hdr, err := img.GetPNGIHDR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hdr.SetBits(16); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range img.Chunks {
+		names = append(names, c.ChunkType)
+	}
+	// gAMA 不能安全复制，被丢弃
+	if got := fmt.Sprint(names); got != "[IHDR tEXt IDAT zTXt IEND]" {
+		t.Errorf("chunks = %s", got)
+	}
+	reloadPNG(t, img)
+}
@@ -16,15 +16,13 @@
 package png
 
 import (
-	"bufio"
 	"bytes"
-	"container/list"
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
-	"strings"
 )
 
 const (
@@ -53,7 +51,7 @@ const (
 	// CsTER = "sTER" // 用于立体图像的立体图像指示器块
 	// CtEXt = "tEXt" // 可以存储可以在ISO / IEC 8859-1中表示的文本
 	// CtIME = "tIME" // 存储上次更改图像的时间
-	// CtRNS = "tRNS" // 包含透明度信息
+	CtRNS = "tRNS" // 包含透明度信息
 	// CzTXt = "zTXt" // 包含与tEXt具有相同限制的压缩文本（和压缩方法标记）
 )
 
@@ -84,13 +82,14 @@ type (
 )
 
 type IHDR struct {
-	width             uint32 // 宽度
-	height            uint32 // 高度
-	bitdepth          uint8  // 颜色位深度
-	colorType         uint8  // 颜色类型
-	compressionMethod uint8  // 压缩方法
-	filterMethod      uint8  // 滤波器方法
-	interlaceMethod   uint8  // 交错方法
+	width             uint32    // 宽度
+	height            uint32    // 高度
+	bitdepth          uint8     // 颜色位深度
+	colorType         uint8     // 颜色类型
+	compressionMethod uint8     // 压缩方法
+	filterMethod      uint8     // 滤波器方法
+	interlaceMethod   uint8     // 交错方法
+	img               *PNGImage // 所属的图像，修改会写回其IHDR块
 }
 
 // PNG 图像的二进制数据实际上是以文件头 file header 以及 chunk 块组合而成。
@@ -109,9 +108,13 @@ type chunkStruct struct {
 // PNG 的整体结构
 // Overall structure
 type pngStruct struct {
-	FileHeader Header // 8 bytes
-	Chunks     Chunks // At least 3 chunk: IHDR, IDAT, IEND or more chunk
-	IDAT       IDATS  // IDAT datas
+	FileHeader Header        // 8 bytes
+	Chunks     Chunks        // At least 3 chunk: IHDR, IDAT, IEND or more chunk
+	IDAT       IDATS         // IDAT datas
+	Filters    []byte        // 每行扫描线的过滤类型，Decode 后有效 filter type of every scanline, set by Decode
+	Animation  *Animation    // APNG的动画信息，不是动画时为nil animation of an APNG, nil otherwise
+	Warnings   []*ChunkError // 按 CRC 策略忽略或修复的错误 errors ignored or repaired by the CRC policy
+	policy     CRCPolicy     // 解析时的 CRC 策略
 }
 
 // New 创建一个PNGImage对象返回对象的指针
@@ -120,6 +123,57 @@ func New() *PNGImage {
 	return new(PNGImage)
 }
 
+// Parse 解析内存中的PNG数据，返回的图像与 b 共用内存
+// Parse the PNG data in b; the image shares the memory of b
+func Parse(b []byte) (*PNGImage, error) {
+	img := New()
+	if e := img.parse(PNGBODY(b), nil); e != nil {
+		return nil, e
+	}
+	return img, nil
+}
+
+// Read 读取 r 中的所有数据并解析，r 可以是任何 io.Reader
+// Read all of r, any io.Reader, and parse it
+func Read(r io.Reader) (*PNGImage, error) {
+	img := New()
+	if e := img.LoadPNGFile(r); e != nil {
+		return nil, e
+	}
+	return img, nil
+}
+
+// ParseHeader 只解析文件头及IHDR块，b 至少需要33字节。
+// 检查IHDR的CRC及语义，返回的IHDR不属于任何图像
+// Parse only the file header and the IHDR chunk, which needs the
+// first 33 bytes of b. The CRC and the semantics of IHDR are checked;
+// the returned header belongs to no image.
+func ParseHeader(b []byte) (*IHDR, error) {
+	if len(b) < PNGHEADSIZE+3*CTLENGTH+CIHDRLEN {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if !Header(b[:PNGHEADSIZE]).check() {
+		return nil, errors.New("Invalid header data")
+	}
+	o := PNGHEADSIZE
+	if binary.BigEndian.Uint32(b[o:]) != CIHDRLEN || string(b[o+CTLENGTH:o+2*CTLENGTH]) != CIHDR {
+		return nil, &ChunkError{Type: CIHDR, Offset: -1, Kind: KindMissing}
+	}
+	d := o + 2*CTLENGTH
+	img := New()
+	ch := NewChunk(CIHDRLEN, CIHDR, ChunkData(b[d:d+CIHDRLEN]), CRC32(b[d+CIHDRLEN:d+CIHDRLEN+CTLENGTH]))
+	if e := img.verify(ch, o); e != nil {
+		return nil, e
+	}
+	img.Chunks = Chunks{ch}
+	hdr, e := img.GetPNGIHDR()
+	if e != nil {
+		return nil, e
+	}
+	hdr.img = nil
+	return hdr, nil
+}
+
 // NewChunk 创建一个Chunk对象返回对象的指针
 // create Chunk object and return object pointer
 func NewChunk(length int, chunkName string, data ChunkData, crc CRC32) *Chunk {
@@ -140,69 +194,72 @@ func (h Header) check() bool {
 	return false
 }
 
-// ParsePNGImage 解析PNG图像数据(块解析)
-// Parse PNG Image chunk
+// ParsePNGImage 使用默认选项解析PNG图像数据(块解析)
+// Parse the chunks with the default options
 func (pb PNGBODY) ParsePNGImage(img *PNGImage) error {
-	l := list.New()
-	// get IHDR chunk 获取IHDR块
-	hdr, e := pb.getChunk(CIHDR)
-	if e != nil {
-		return e
-	} else {
-		l.PushBack(hdr)
+	return pb.ParsePNGImageOptions(img, nil)
+}
+
+// ParsePNGImageOptions 解析PNG图像数据(块解析)，opt 为 nil 时
+// 使用默认选项。按顺序读取直到IEND的所有块(包括辅助块)，每个块的
+// CRC都按 CRC 策略检查；IHDR必须是第一块，至少有一个IDAT块。
+// 块的错误为 *ChunkError
+// Parse the chunks; a nil opt uses the defaults. Every chunk up to
+// IEND, ancillary ones included, is kept in order and its CRC is
+// checked following the CRC policy. IHDR must come first and at
+// least one IDAT is required. Errors of chunks are *ChunkError.
+// Parse PNG Image chunk
+func (pb PNGBODY) ParsePNGImageOptions(img *PNGImage, opt *ParseOptions) error {
+	if opt == nil {
+		opt = new(ParseOptions)
 	}
-	// get PLTE chunk 获取调色板块
-	// 这个可能有，可能没有，见前面定义的注释
-	plt, e := pb.getChunk(CPLTE)
-	if plt != nil {
-		l.PushBack(plt)
-	} else {
-		if e != nil && strings.Contains(e.Error(), "crc") {
+	img.policy = opt.CRC
+	img.Warnings = nil
+	var (
+		chunks Chunks
+		idats  IDATS
+		end    bool
+	)
+	for o := PNGHEADSIZE; !end && o+2*CTLENGTH <= len(pb); {
+		l := int(binary.BigEndian.Uint32(pb[o : o+CTLENGTH]))
+		name := string(pb[o+CTLENGTH : o+2*CTLENGTH])
+		i := o + 2*CTLENGTH
+		if l < 0 || l > len(pb) || i+l+CTLENGTH > len(pb) {
+			return &ChunkError{Type: name, Offset: o, Kind: KindLength}
+		}
+		ch := NewChunk(l, name, ChunkData(pb[i:i+l]), CRC32(pb[i+l:i+l+CTLENGTH]))
+		if e := img.verify(ch, o); e != nil {
 			return e
 		}
+		switch name {
+		case CIDAT:
+			idats = append(idats, ImageData(ch.Data))
+		case CIEND:
+			end = true
+		}
+		chunks = append(chunks, ch)
+		o = i + l + CTLENGTH
 	}
-	// get IDAT and processing IDATS
-	// 获取 IDAT 块，可能有多个
-	e = pb.procIDATS(l, img)
-	if e != nil {
-		return e
-	}
-	// get IEND chunk
-	// 获取PNG文件的结尾块
-	end, e := pb.getChunk(CIEND)
-	if e != nil {
-		return e
+	if len(chunks) == 0 || chunks[0].ChunkType != CIHDR {
+		return &ChunkError{Type: CIHDR, Offset: -1, Kind: KindMissing}
 	}
-	l.PushBack(end)
-	// 链表数据转换到 Chunks
-	img.listToChunks(l)
-	return nil
-}
-
-func (pb PNGBODY) procIDATS(l *list.List, img *PNGImage) error {
-	dc := pb.searchIDATChunk()
-	if len(dc) < 1 {
-		return errors.New(CIDAT + " chunk not found")
+	if len(idats) == 0 {
+		return &ChunkError{Type: CIDAT, Offset: -1, Kind: KindMissing}
 	}
-	idats, e := pb.getIDATChunk(dc)
-	if e != nil {
-		return e
+	if !end {
+		return &ChunkError{Type: CIEND, Offset: -1, Kind: KindMissing}
 	}
-	img.IDAT = make(IDATS, len(idats))
-	for i, v := range idats {
-		img.IDAT[i] = ImageData((*v).Data)
-		l.PushBack(*v)
-	}
-	return nil
+	img.Chunks = chunks
+	img.IDAT = idats
+	// APNG的动画块，可能没有
+	return pb.parseAnimation(img)
 }
 
-func (img *PNGImage) listToChunks(l *list.List) {
-	img.Chunks = make(Chunks, l.Len())
-	for i := 0; i < l.Len(); i++ {
-		elm := l.Front()
-		obj, _ := (elm.Value).(*Chunk)
-		img.Chunks[i] = obj
-	}
+// missing 错误是否为块不存在
+// Whether e reports a missing chunk
+func missing(e error) bool {
+	ce, ok := e.(*ChunkError)
+	return ok && ce.Kind == KindMissing
 }
 
 func (img *PNGImage) GetPNGIHDR() (*IHDR, error) {
@@ -229,7 +286,7 @@ func (img *PNGImage) GetPNGIHDR() (*IHDR, error) {
 		p[j] = d[i]
 		j++
 	}
-	return &IHDR{
+	hdr := &IHDR{
 		width:             uint32(w),
 		height:            uint32(h),
 		bitdepth:          p[0],
@@ -237,7 +294,12 @@ func (img *PNGImage) GetPNGIHDR() (*IHDR, error) {
 		compressionMethod: p[2],
 		filterMethod:      p[3],
 		interlaceMethod:   p[4],
-	}, nil
+	}
+	if e := hdr.Validate(); e != nil {
+		return nil, e
+	}
+	hdr.img = img
+	return hdr, nil
 }
 
 // getUint32 获取二进制数据中以大端序存放的Uint32类型数据
@@ -249,32 +311,6 @@ func getUint32(buf PNGBODY) (v int, err error) {
 	return int(binary.BigEndian.Uint32(buf)), nil
 }
 
-// getChunk 获取PNG中的chunk块
-// 成功返回 *Chunk
-// 失败返回 error
-func (pb PNGBODY) getChunk(ctn string) (*Chunk, error) {
-	c := pb.searchChunk(ctn)
-	if c == -1 {
-		return nil, errors.New(ctn + " chunk not found")
-	} else {
-		l, e := getUint32(pb[c-CTLENGTH : c])
-		if e != nil {
-			return nil, e
-		}
-		i := c + CTLENGTH
-		o := i + l
-		c := pb[o : o+CTLENGTH]
-		ch := NewChunk(l, ctn,
-			ChunkData(pb[i:o]),
-			CRC32(c),
-		)
-		if !ch.Crc.check(ch) {
-			return nil, errors.New(ctn + " chunk crc error")
-		}
-		return ch, nil
-	}
-}
-
 // check CRC32 循环冗余检测
 // 将chunk中的crc32数据与我们自己生成的crc32数据进行比对
 // cyclic redundancy check(32bit)
@@ -305,172 +341,216 @@ func (c CRC32) check(ck *Chunk) bool {
 // name of the chunk If found, returns the offset in
 // the data, or -1 if not found.
 func (pb PNGBODY) searchChunk(c string) int {
-	return bytes.Index(pb, []byte(c))
-}
-
-// searchIDATChunk 获取IDAT块的偏移量(可能有多个)
-// Get the offset of the IDAT block (possibly multiple)
-func (pb PNGBODY) searchIDATChunk() []int {
-	offset := make([]int, 256)
-	start := 0
-	end := len(pb)
-	var j int
-	for j = 0; j < len(offset); j++ {
-		i := bytes.Index(pb[start:end], []byte(CIDAT))
-		if i == -1 {
-			break
-		}
-		l := int(binary.BigEndian.Uint32(pb[i-CTLENGTH : i]))
-		offset[j] = i
-		start = l
+	o := pb.chunkOffsets(c)
+	if len(o) == 0 {
+		return -1
 	}
-	return offset[:j]
-}
-
-// getIDATChunk 获取IDAT块的偏移量(可能有多个IDAT块)
-// Get the offset of the IDAT chunk (possibly multiple)
-func (pb PNGBODY) getIDATChunk(offset []int) ([]*Chunk, error) {
-	cs := make([]*Chunk, len(offset))
-	for j, v := range offset {
-		l, e := getUint32(pb[v-CTLENGTH : v])
-		if e != nil {
-			return nil, e
+	return o[0]
+}
+
+// chunkOffsets 按块的结构逐个遍历，返回名字为 c 的所有块的
+// chunkTypeCode 的偏移量。块数据中出现的相同字节不会被误认
+// Walk the chunks one by one and return the offset of the type
+// code of every chunk named c. The same bytes inside the data
+// of a chunk are never mistaken for a chunk.
+func (pb PNGBODY) chunkOffsets(c string) []int {
+	var offset []int
+	for o := PNGHEADSIZE; o+2*CTLENGTH <= len(pb); {
+		l := int(binary.BigEndian.Uint32(pb[o : o+CTLENGTH]))
+		if string(pb[o+CTLENGTH:o+2*CTLENGTH]) == c {
+			offset = append(offset, o+CTLENGTH)
 		}
-		i := v + CTLENGTH
-		o := i + l
-		c := pb[o : o+CTLENGTH]
-		ch := NewChunk(l, CIDAT,
-			ChunkData(pb[i:o]),
-			CRC32(c),
-		)
-		if !ch.Crc.check(ch) {
-			return nil, errors.New(CIDAT + " chunk crc error")
+		if l < 0 || l > len(pb) {
+			break
 		}
-		cs[j] = ch
+		o += 3*CTLENGTH + l
 	}
-	return cs, nil
+	return offset
 }
 
-// GetPNGSize 获取已得到的文件数据大小
+// Size 获取已得到的文件数据大小
 // 可用于和io.Reader转换为*os.File后，
 // 得到的FileInfo对象的文件大小进行比对
 // Get the size of the obtained file data
 // Used to compare the file size of the
 // resulting FileInfo object after converting
 // it to *os.File with io.Reader
-func (pb PNGBODY) GetPNGSzie() int {
+func (pb PNGBODY) Size() int {
 	return len(pb)
 }
 
-// LoadPNGFile 载入 PNG 文件的数据(包含解析)
-// load png file data, and parse chunk data.
+// GetPNGSzie 与 Size 相同
+//
+// Deprecated: 使用 Size。Use Size.
+func (pb PNGBODY) GetPNGSzie() int {
+	return pb.Size()
+}
+
+// LoadPNGFile 使用默认选项载入 PNG 文件的数据(包含解析)，
+// rd 可以是任何 io.Reader
+// load png file data from any io.Reader, and parse chunk data
+// with the default options.
 func (img *PNGImage) LoadPNGFile(rd io.Reader) error {
-	r := bufio.NewReader(rd)
-	hb, e := r.Peek(PNGHEADSIZE)
-	if e != nil {
-		return e
+	return img.LoadPNGFileOptions(rd, nil)
+}
+
+// LoadPNGFileOptions 载入 PNG 文件的数据并按 opt 解析
+// load png file data, and parse chunk data with opt.
+func (img *PNGImage) LoadPNGFileOptions(rd io.Reader, opt *ParseOptions) error {
+	var b PNGBODY
+	if s, e := img.getReaderSize(rd); e == nil {
+		// 文件的大小已知，一次读取
+		// the size of a file is known, read it in one go
+		if b, e = img.loadAllBytes(rd, s); e != nil {
+			return e
+		}
+	} else {
+		if b, e = ioutil.ReadAll(rd); e != nil {
+			return e
+		}
+	}
+	return img.parse(b, opt)
+}
+
+// parse 检查文件头并解析数据，图像与 b 共用内存
+// Check the file header and parse b; the image shares b
+func (img *PNGImage) parse(b PNGBODY, opt *ParseOptions) error {
+	if len(b) < PNGHEADSIZE {
+		return io.ErrUnexpectedEOF
 	}
-	img.FileHeader = hb
+	img.FileHeader = Header(b[:PNGHEADSIZE])
 	if !img.FileHeader.check() {
 		return errors.New("Invalid header data")
 	}
-	s, e := img.getReaderSize(rd)
-	if e != nil {
-		return e
-	}
-	b := img.loadAllBytes(r, s)
-	if b == nil || b.GetPNGSzie() != s {
-		return errors.New("Nil slice or load data error")
-	}
-	if e := b.ParsePNGImage(img); e != nil {
-		return e
-	}
-	return nil
+	return b.ParsePNGImageOptions(img, opt)
 }
 
-// getReaderSize 获取 io.Reader -> *os.File 的大小
-// io.Reader 实际上是一个 *os.File 指针，通过该指针
-// 我们可以获取实际的文件大小
-// io.Reader is actually a *os.File pointer,
-// through which we can get the actual file size
+// getReaderSize 获取 io.Reader -> *os.File 从当前位置开始的剩余大小
+// io.Reader 是 *os.File 指针时，通过该指针
+// 我们可以获取实际的文件大小，否则返回错误
+// When io.Reader is a *os.File pointer, get the size
+// that remains from its current position, otherwise fail
 func (img *PNGImage) getReaderSize(rd io.Reader) (int, error) {
 	fs, is := rd.(*os.File)
 	if is {
 		fi, e := fs.Stat()
+		if e != nil || !fi.Mode().IsRegular() {
+			return -1, errors.New("not a regular file")
+		}
+		pos, e := fs.Seek(0, io.SeekCurrent)
 		if e != nil {
 			return -1, e
 		}
-		return int(fi.Size()), nil
+		return int(fi.Size() - pos), nil
 	} else {
 		return -1, errors.New("not file pointer")
 	}
 }
 
-// loadAllBytes 载入png文件的所有数据，返回 PNGBODY
-// load png file all data(return PNGBODY or nil)
-func (img *PNGImage) loadAllBytes(rd *bufio.Reader, size int) PNGBODY {
-	var e error
+// loadAllBytes 一次载入png文件的所有数据，返回 PNGBODY
+// 数据不足 size 时返回 io.ErrUnexpectedEOF
+// load png file all data in one go, io.ErrUnexpectedEOF
+// is returned when there are fewer than size bytes.
+func (img *PNGImage) loadAllBytes(rd io.Reader, size int) (PNGBODY, error) {
 	p := make(PNGBODY, size)
-	for i := 0; i < size; i++ {
-		p[i], e = rd.ReadByte()
-		if e != nil {
-			break
-		}
+	if _, e := io.ReadFull(rd, p); e != nil {
+		return nil, e
 	}
-	return p
+	return p, nil
 }
 
 func (hdr *IHDR) GetWidth() int {
 	return int(hdr.width)
 }
-func (hdr *IHDR) SetWidth(w int) {
-	hdr.width = uint32(w)
+
+// SetWidth 修改宽度。GetPNGIHDR 返回的IHDR的尺寸不能改变，
+// 除非宽度不变都返回错误；只有独立的IHDR(如 ParseHeader 的结果)可以修改
+// Change the width. The size of a header returned by GetPNGIHDR cannot
+// change, so it fails unless w is the current width; only a detached
+// header, such as one from ParseHeader, takes a new width.
+func (hdr *IHDR) SetWidth(w int) error {
+	if w < 0 {
+		return errors.New(CIHDR + " negative width")
+	}
+	return hdr.apply(func(h *IHDR) { h.width = uint32(w) })
 }
 
 func (hdr *IHDR) GetHeight() int {
 	return int(hdr.height)
 }
 
-func (hdr *IHDR) SetHeight(h int) {
-	hdr.height = uint32(h)
+// SetHeight 修改高度，与 SetWidth 相同，GetPNGIHDR 返回的IHDR不能修改
+// Change the height; as with SetWidth a header returned by GetPNGIHDR
+// cannot take a new height.
+func (hdr *IHDR) SetHeight(h int) error {
+	if h < 0 {
+		return errors.New(CIHDR + " negative height")
+	}
+	return hdr.apply(func(n *IHDR) { n.height = uint32(h) })
 }
 
 func (hdr *IHDR) GetBits() int {
 	return int(hdr.bitdepth)
 }
 
-func (hdr *IHDR) SetBits(b int) {
-	hdr.bitdepth = uint8(b)
+// SetBits 修改位深度。GetPNGIHDR 返回的IHDR会转换图像的像素数据，
+// 颜色类型(SetColorType)及交错方法(SetInterlaceMethod)也是如此；
+// 修改后无效或无法转换时返回错误且不做修改
+// Change the bit depth. For a header returned by GetPNGIHDR the pixels
+// of the image are converted, as they are by SetColorType and
+// SetInterlaceMethod; nothing changes and an error is returned when
+// the result is invalid or cannot be converted.
+func (hdr *IHDR) SetBits(b int) error {
+	return hdr.setByte(func(h *IHDR) *uint8 { return &h.bitdepth }, b)
+}
+
+// BitsPerPixel 每像素的位数：位深度乘以通道数
+// Bits per pixel: the bit depth times the number of channels
+func (hdr *IHDR) BitsPerPixel() int {
+	ch, e := hdr.channels()
+	if e != nil {
+		return int(hdr.bitdepth)
+	}
+	return ch * int(hdr.bitdepth)
 }
 
 func (hdr *IHDR) GetColorType() int {
 	return int(hdr.colorType)
 }
 
-func (hdr *IHDR) SetColorType(ct int) {
-	hdr.colorType = uint8(ct)
+func (hdr *IHDR) SetColorType(ct int) error {
+	return hdr.setByte(func(h *IHDR) *uint8 { return &h.colorType }, ct)
 }
 
 func (hdr *IHDR) GetCompressionMethod() int {
 	return int(hdr.compressionMethod)
 }
 
-func (hdr *IHDR) SetCompressionMethod(cpm int) {
-	hdr.compressionMethod = uint8(cpm)
+func (hdr *IHDR) SetCompressionMethod(cpm int) error {
+	return hdr.setByte(func(h *IHDR) *uint8 { return &h.compressionMethod }, cpm)
 }
 
 func (hdr *IHDR) GetFilterMethod() int {
 	return int(hdr.filterMethod)
 }
 
-func (hdr *IHDR) SetFilterMethod(fltrm int) {
-	hdr.filterMethod = uint8(fltrm)
+func (hdr *IHDR) SetFilterMethod(fltrm int) error {
+	return hdr.setByte(func(h *IHDR) *uint8 { return &h.filterMethod }, fltrm)
 }
 
 func (hdr *IHDR) GetInterlaceMethod() int {
 	return int(hdr.interlaceMethod)
 }
 
-func (hdr *IHDR) SetInterlaceMethod(iterm int) {
-	hdr.interlaceMethod = uint8(iterm)
+func (hdr *IHDR) SetInterlaceMethod(iterm int) error {
+	return hdr.setByte(func(h *IHDR) *uint8 { return &h.interlaceMethod }, iterm)
+}
+
+// setByte 修改IHDR中一个字节的字段
+// Change a one byte field of the header
+func (hdr *IHDR) setByte(field func(h *IHDR) *uint8, v int) error {
+	if v < 0 || v > 0xff {
+		return errors.New(CIHDR + " value out of range")
+	}
+	return hdr.apply(func(h *IHDR) { *field(h) = uint8(v) })
 }
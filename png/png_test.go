@@ -16,12 +16,16 @@
 package png
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"os"
 	"testing"
+	"testing/iotest"
 )
 
 func TestPNGImage_LoadPNGFile(t *testing.T) {
-	p := "../img/vkico256x256@32bit.png"
+	p := "../testico/vkico256x256@32bit.png"
 	f, e := os.Open(p)
 	if e != nil {
 		panic(e)
@@ -48,3 +52,131 @@ func TestPNGImage_LoadPNGFile(t *testing.T) {
 		ihdr.GetInterlaceMethod(),
 	)
 }
+
+func TestParseAndRead(t *testing.T) {
+	b, e := ioutil.ReadFile("../testico/vkico256x256@8bit.png")
+	if e != nil {
+		t.Fatal(e)
+	}
+	check := func(name string, img *PNGImage, e error) {
+		t.Helper()
+		if e != nil {
+			t.Fatalf("%s() = %v", name, e)
+		}
+		hdr, e := img.GetPNGIHDR()
+		if e != nil {
+			t.Fatalf("%s: GetPNGIHDR() = %v", name, e)
+		}
+		if hdr.GetWidth() != 256 || hdr.GetHeight() != 256 {
+			t.Errorf("%s: size %dx%d", name, hdr.GetWidth(), hdr.GetHeight())
+		}
+		if _, e := img.Decode(); e != nil {
+			t.Errorf("%s: Decode() = %v", name, e)
+		}
+	}
+	img, e := Parse(b)
+	check("Parse", img, e)
+	img, e = Read(bytes.NewReader(b))
+	check("Read", img, e)
+	img, e = Read(iotest.OneByteReader(bytes.NewReader(b)))
+	check("Read(OneByteReader)", img, e)
+
+	// 文件的当前位置不在开头
+	f, e := ioutil.TempFile("", "png*.png")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, e := f.Write(append([]byte("junk"), b...)); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := f.Seek(4, io.SeekStart); e != nil {
+		t.Fatal(e)
+	}
+	img, e = Read(f)
+	check("Read(*os.File)", img, e)
+
+	for _, bad := range [][]byte{nil, b[:4], []byte("GIF89a...........")} {
+		if _, e := Parse(bad); e == nil {
+			t.Errorf("Parse(%q) = nil error", bad)
+		}
+		if _, e := Read(bytes.NewReader(bad)); e == nil {
+			t.Errorf("Read(%q) = nil error", bad)
+		}
+	}
+	if _, e := Read(iotest.ErrReader(io.ErrClosedPipe)); e != io.ErrClosedPipe {
+		t.Errorf("Read(error) = %v", e)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		ct, depth uint8
+		bpp       int
+	}{
+		{ColorGray, 1, 1}, {ColorGray, 16, 16}, {ColorRGB, 8, 24}, {ColorPaletted, 4, 4},
+		{ColorGrayAlpha, 8, 16}, {ColorRGBA, 8, 32}, {ColorRGBA, 16, 64},
+	}
+	for _, tt := range tests {
+		var plte []byte
+		if tt.ct == ColorPaletted {
+			plte = make([]byte, 3*16)
+		}
+		b := testPNG(300, 2, tt.ct, tt.depth, false, plte, nil)
+		hdr, e := ParseHeader(b[:33])
+		if e != nil {
+			t.Fatalf("ParseHeader(ct%d %dbit) = %v", tt.ct, tt.depth, e)
+		}
+		if hdr.GetWidth() != 300 || hdr.GetHeight() != 2 || hdr.BitsPerPixel() != tt.bpp {
+			t.Errorf("ct%d %dbit: %dx%d %dbpp", tt.ct, tt.depth, hdr.GetWidth(), hdr.GetHeight(), hdr.BitsPerPixel())
+		}
+		// 不属于任何图像，设置函数只修改其本身
+		if e := hdr.SetWidth(1); e != nil || hdr.GetWidth() != 1 {
+			t.Errorf("SetWidth() = %v", e)
+		}
+	}
+	b := testPNG(2, 2, ColorRGB, 8, false, nil, nil)
+	if _, e := ParseHeader(b[:32]); e != io.ErrUnexpectedEOF {
+		t.Errorf("ParseHeader(short) = %v", e)
+	}
+	bad := append([]byte{}, b...)
+	bad[32] ^= 1
+	if _, e := ParseHeader(bad); e == nil {
+		t.Error("ParseHeader(bad crc) = nil error")
+	}
+	bad = append([]byte{}, b...)
+	bad[24] = 3 // RGB 3bit
+	copy(bad[29:33], testChunk(CIHDR, bad[16:29])[21:])
+	if _, e := ParseHeader(bad); e == nil || e == io.ErrUnexpectedEOF {
+		t.Errorf("ParseHeader(invalid depth) = %v", e)
+	} else if _, crc := e.(*ChunkError); crc {
+		t.Errorf("ParseHeader(invalid depth) = %v, want a validation error", e)
+	}
+}
+
+func BenchmarkPNGImage_LoadPNGFile(b *testing.B) {
+	for _, n := range []string{"vkico256x256@32bit.png", "vkico256x256@8bit.png"} {
+		b.Run(n, func(b *testing.B) {
+			f, e := os.Open("../testico/" + n)
+			if e != nil {
+				b.Fatal(e)
+			}
+			defer f.Close()
+			fi, e := f.Stat()
+			if e != nil {
+				b.Fatal(e)
+			}
+			b.SetBytes(fi.Size())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, e := f.Seek(0, io.SeekStart); e != nil {
+					b.Fatal(e)
+				}
+				if e := New().LoadPNGFile(f); e != nil {
+					b.Fatal(e)
+				}
+			}
+		})
+	}
+}